@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+var logsDropped = metrics.counter("logs_dropped_total",
+	"Access log lines dropped because the log buffer was full.")
+
+// accessLog carries per-request log lines. It is set up in main; until then
+// (or if it never is) access lines are written synchronously like any other.
+var accessLog *accessLogger
+
+// accessLogger writes access log lines from a single goroutine so a slow log
+// destination can't hold up request handling. When the buffer is full lines
+// are dropped and counted rather than waited on. Errors and audit entries
+// don't go through here: they keep using log directly, which blocks.
+type accessLogger struct {
+	mu     sync.RWMutex
+	closed bool
+	lines  chan []byte
+	done   chan struct{}
+}
+
+func newAccessLogger(size int) *accessLogger {
+	if size < 1 {
+		size = 1
+	}
+
+	al := &accessLogger{
+		lines: make(chan []byte, size),
+		done:  make(chan struct{}),
+	}
+	logsDropped.add(0)
+	go al.run()
+	return al
+}
+
+// printf formats the line up front, including the caller's file and line,
+// so it reads the same as one written with log.Printf.
+func (al *accessLogger) printf(format string, args ...any) {
+	if al == nil {
+		log.Output(2, fmt.Sprintf(format, args...))
+		return
+	}
+
+	line := fmt.Appendf(nil, format, args...)
+	if _, file, lineNo, ok := runtime.Caller(1); ok && log.Flags()&log.Lshortfile != 0 {
+		line = append(fmt.Appendf(nil, "%s:%d: ", filepath.Base(file), lineNo), line...)
+	}
+	line = append(line, '\n')
+
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	if al.closed {
+		log.Writer().Write(line)
+		return
+	}
+	select {
+	case al.lines <- line:
+	default:
+		logsDropped.inc()
+	}
+}
+
+func (al *accessLogger) run() {
+	defer close(al.done)
+	for line := range al.lines {
+		log.Writer().Write(line)
+	}
+}
+
+// close writes out whatever is still buffered and waits for it to finish.
+// Lines logged afterwards are written synchronously.
+func (al *accessLogger) close() {
+	if al == nil {
+		return
+	}
+
+	al.mu.Lock()
+	if !al.closed {
+		al.closed = true
+		close(al.lines)
+	}
+	al.mu.Unlock()
+	<-al.done
+}
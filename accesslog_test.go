@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledWriter blocks every write until released.
+type stalledWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (sw *stalledWriter) Write(p []byte) (int, error) {
+	<-sw.release
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.buf.Write(p)
+}
+
+func logsDroppedTotal(t *testing.T) int {
+	t.Helper()
+	m := regexp.MustCompile(`(?m)^logs_dropped_total (\d+)$`).FindStringSubmatch(metricsOutput(t))
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func TestAccessLogDropsWhenStalled(t *testing.T) {
+	sink := &stalledWriter{release: make(chan struct{})}
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(sink)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+
+	al := newAccessLogger(2)
+	before := logsDroppedTotal(t)
+
+	done := make(chan struct{})
+	go func() {
+		for i := range 10 {
+			al.printf("line %d", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("printf blocked on a stalled log writer")
+	}
+
+	dropped := logsDroppedTotal(t) - before
+	// One line may already be with the stalled writer, two are buffered.
+	if dropped < 7 || dropped > 8 {
+		t.Errorf("%d lines dropped, want 7 or 8", dropped)
+	}
+
+	close(sink.release)
+	al.close()
+	if got := strings.Count(sink.buf.String(), "\n"); got != 10-dropped {
+		t.Errorf("%d lines written after the flush, want %d", got, 10-dropped)
+	}
+
+	al.printf("after close")
+	if !strings.HasSuffix(sink.buf.String(), "after close\n") {
+		t.Error("a line logged after close was not written synchronously")
+	}
+}
+
+func TestAuditLogStaysSynchronous(t *testing.T) {
+	logs := captureLog(t)
+	prev := accessLog
+	accessLog = newAccessLogger(1)
+	t.Cleanup(func() {
+		accessLog.close()
+		accessLog = prev
+	})
+
+	auditLog(httptest.NewRequest(http.MethodPost, "/admin/pause", nil), "pause")
+	if !strings.Contains(logs.String(), "AUDIT - Action: pause") {
+		t.Error("audit record was not written before auditLog returned")
+	}
+}
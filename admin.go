@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// acceptancePaused is set while an operator has paused request acceptance.
+// Unlike shutting down, the server keeps its listener and resumes serving
+// as soon as the flag is cleared.
+var acceptancePaused atomic.Bool
+
+func pauseMiddleware(retryAfter time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if acceptancePaused.Load() {
+				overloadHandler(w, r, http.StatusServiceUnavailable, retryAfter, "Request acceptance is paused")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// auditLog records an administrative action. It is written synchronously so
+// the record exists before the action's response is sent.
+func auditLog(r *http.Request, action string) {
+	log.Printf("[%v] AUDIT - Action: %s | RemoteAddr: %s | User-Agent: %s",
+		requestInfo(r.Context()).ID,
+		action,
+		r.RemoteAddr,
+		r.UserAgent(),
+	)
+}
+
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	acceptancePaused.Store(true)
+	auditLog(r, "pause")
+	adminStatusHandler(w, r, "Request acceptance paused")
+}
+
+func resumeHandler(w http.ResponseWriter, r *http.Request) {
+	acceptancePaused.Store(false)
+	auditLog(r, "resume")
+	adminStatusHandler(w, r, "Request acceptance resumed")
+}
+
+func adminStatusHandler(w http.ResponseWriter, r *http.Request, message string) {
+	requestID := requestInfo(r.Context()).ID
+
+	response := map[string]interface{}{
+		"status":     "success",
+		"message":    message,
+		"paused":     acceptancePaused.Load(),
+		"request_id": requestID,
+		"timestamp":  formatTimestamp(time.Now()),
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
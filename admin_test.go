@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseAndResume(t *testing.T) {
+	logs := captureLog(t)
+	t.Cleanup(func() { acceptancePaused.Store(false) })
+
+	app := pauseMiddleware(2 * time.Second)(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("before pause: status = %d, want 200", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	pauseHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/pause", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"paused":true`) {
+		t.Fatalf("pause: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	rec = serve()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("while paused: status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+
+	rec = httptest.NewRecorder()
+	resumeHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/resume", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"paused":false`) {
+		t.Fatalf("resume: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("after resume: status = %d, want 200", rec.Code)
+	}
+
+	out := logs.String()
+	for _, action := range []string{"pause", "resume"} {
+		if !strings.Contains(out, "AUDIT - Action: "+action+" |") {
+			t.Errorf("no audit record for %s in:\n%s", action, out)
+		}
+	}
+}
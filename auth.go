@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// authLevel is the authentication a route requires. It has no usable zero
+// value: setupRoutes refuses to start with a route that doesn't declare one,
+// so a new route can't end up public by omission.
+type authLevel int
+
+const (
+	authUnset authLevel = iota
+	authNone
+	authToken
+	authAdmin
+)
+
+func (a authLevel) String() string {
+	switch a {
+	case authNone:
+		return "none"
+	case authToken:
+		return "token"
+	case authAdmin:
+		return "admin"
+	}
+	return "unset"
+}
+
+// authMiddleware enforces a route's declared auth level. authToken routes
+// accept API_TOKEN or ADMIN_TOKEN; authAdmin routes accept only ADMIN_TOKEN.
+// With no token configured for a level, its routes reject every request.
+func authMiddleware(level authLevel, config *Config) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		var tokens []string
+		switch level {
+		case authNone:
+			return next
+		case authToken:
+			tokens = []string{config.APIToken, config.AdminToken}
+		case authAdmin:
+			tokens = []string{config.AdminToken}
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !validBearerToken(r, tokens...) {
+				log.Printf("[%v] Rejected unauthenticated request - Auth: %s | Path: %s | RemoteAddr: %s",
+					requestInfo(r.Context()).ID, level, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", level.String()))
+				errorHandler(w, r, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func validBearerToken(r *http.Request, tokens ...string) bool {
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	credentials = strings.TrimSpace(credentials)
+
+	for _, token := range tokens {
+		if token != "" && subtle.ConstantTimeCompare([]byte(credentials), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
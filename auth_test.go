@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRoutesEnforceDeclaredAuth(t *testing.T) {
+	captureLog(t)
+	t.Cleanup(func() { acceptancePaused.Store(false) })
+
+	config := loadConfig()
+	config.APIToken = "api-secret"
+	config.AdminToken = "admin-secret"
+	config.DebugEndpoints = true
+	config.Dashboard = true
+	handler := newTestServer(t, config)
+
+	accepted := map[authLevel][]string{
+		authNone:  {"", "wrong", "api-secret", "admin-secret"},
+		authToken: {"api-secret", "admin-secret"},
+		authAdmin: {"admin-secret"},
+	}
+	tokens := []string{"", "wrong", "api-secret", "admin-secret"}
+
+	routes := routeTable(config)
+	levels := map[authLevel]bool{}
+	for _, rt := range routes {
+		levels[rt.auth] = true
+		method := http.MethodGet
+		if len(rt.methods) > 0 {
+			method = rt.methods[0]
+		}
+		for _, token := range tokens {
+			// Streaming routes stay open; their auth decision is all
+			// this test needs, so only check the rejections there.
+			want := slices.Contains(accepted[rt.auth], token)
+			if rt.streaming && want {
+				continue
+			}
+			req := httptest.NewRequest(method, rt.pattern, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Code != http.StatusUnauthorized; got != want {
+				t.Errorf("%s %s (auth %s) with token %q: status %d", method, rt.pattern, rt.auth, token, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Bearer realm="`+rt.auth.String()+`"` {
+				t.Errorf("%s %s: WWW-Authenticate = %q", method, rt.pattern, rec.Header().Get("WWW-Authenticate"))
+			}
+		}
+	}
+	for _, level := range []authLevel{authNone, authToken, authAdmin} {
+		if !levels[level] {
+			t.Errorf("no route declares auth %s", level)
+		}
+	}
+}
+
+func TestAuthTokenLevelRejectsWithoutConfiguredToken(t *testing.T) {
+	captureLog(t)
+	h := authMiddleware(authToken, &Config{})(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d with no API_TOKEN configured, want 401", rec.Code)
+	}
+}
+
+func TestValidBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer secret", true},
+		{"bearer secret", true},
+		{"Bearer  secret ", true},
+		{"Bearer other", false},
+		{"Basic secret", false},
+		{"secret", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", tt.header)
+		if got := validBearerToken(req, "", "secret"); got != tt.want {
+			t.Errorf("validBearerToken(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
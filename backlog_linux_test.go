@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// queuedConnections dials ln count times without ever accepting and
+// reports how many handshakes completed. Linux drops SYNs once the accept
+// queue holds more than the backlog, so the count tracks the backlog.
+func queuedConnections(t *testing.T, ln net.Listener, count int) int {
+	t.Helper()
+	connected := 0
+	for range count {
+		c, err := net.DialTimeout("tcp", ln.Addr().String(), 200*time.Millisecond)
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { c.Close() })
+		connected++
+	}
+	return connected
+}
+
+func TestListenBacklogApplied(t *testing.T) {
+	captureLog(t)
+
+	ln, err := listen(&Config{Port: "0", ListenBacklog: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// Linux queues backlog+1 completed connections.
+	if got := queuedConnections(t, ln, 6); got != 2 {
+		t.Errorf("%d connections completed with LISTEN_BACKLOG=1, want 2", got)
+	}
+
+	def, err := listen(&Config{Port: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer def.Close()
+	if got := queuedConnections(t, def, 6); got != 6 {
+		t.Errorf("%d connections completed with the default backlog, want 6", got)
+	}
+}
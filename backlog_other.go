@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func setListenBacklog(ln net.Listener, backlog int) error {
+	return errors.New("setting the listen backlog is not supported on this platform")
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setListenBacklog re-issues listen(2) on the socket with a new backlog.
+// Linux silently caps the value at net.core.somaxconn.
+func setListenBacklog(ln net.Listener, backlog int) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("not a TCP listener")
+	}
+
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	if err := rc.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// bodyDrainMiddleware discards whatever part of the request body the handler
+// left unread, up to limit bytes, once it returns. A fully drained body lets
+// the connection be reused for the next request; a larger remainder is not
+// worth reading, so the connection is marked to close instead.
+//
+// Marking it only works while the response headers are still unsent. With
+// early set the body is drained as the headers go out, which is only safe
+// for handlers that never read it: anything still reading, such as a proxy
+// transport streaming it upstream, would race the drain. Otherwise, once
+// the headers are out, the server's own discard of the remainder decides
+// whether the connection is kept.
+func bodyDrainMiddleware(limit int64, early bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next(w, r)
+				return
+			}
+
+			dw := &drainingResponseWriter{ResponseWriter: w, body: r.Body, limit: limit}
+			if early {
+				next(dw, r)
+			} else {
+				next(w, r)
+			}
+			dw.drain()
+			r.Body.Close()
+		}
+	}
+}
+
+// ignoresBody reports whether rt's handler can be trusted not to read the
+// request body: it only accepts methods that don't carry one.
+func ignoresBody(rt route) bool {
+	if len(rt.methods) == 0 {
+		return false
+	}
+	for _, m := range rt.methods {
+		if m != http.MethodGet && m != http.MethodHead {
+			return false
+		}
+	}
+	return true
+}
+
+type drainingResponseWriter struct {
+	http.ResponseWriter
+	body    io.Reader
+	limit   int64
+	drained bool
+}
+
+func (dw *drainingResponseWriter) drain() {
+	if dw.drained {
+		return
+	}
+	dw.drained = true
+
+	n, _ := io.CopyN(io.Discard, dw.body, dw.limit+1)
+	if n > dw.limit {
+		dw.ResponseWriter.Header().Set("Connection", "close")
+	}
+}
+
+func (dw *drainingResponseWriter) WriteHeader(status int) {
+	dw.drain()
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+func (dw *drainingResponseWriter) Write(p []byte) (int, error) {
+	dw.drain()
+	return dw.ResponseWriter.Write(p)
+}
+
+func (dw *drainingResponseWriter) Flush() {
+	dw.drain()
+	http.NewResponseController(dw.ResponseWriter).Flush()
+}
+
+func (dw *drainingResponseWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
+
+var (
+	errBodyTooLarge  = errors.New("request body too large")
+	errBodyTruncated = errors.New("request body is shorter than its Content-Length")
+)
+
+// readBody reads the whole request body, refusing more than limit bytes
+// (0 means no limit). Handlers should use it rather than calling Read
+// themselves: a Read may return the final bytes together with io.EOF, and
+// checking the error first drops them.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if limit > 0 && r.ContentLength > limit {
+		return nil, errBodyTooLarge
+	}
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 32<<10)
+	for {
+		n, err := r.Body.Read(chunk)
+		if n > 0 {
+			if limit > 0 && int64(buf.Len()+n) > limit {
+				return nil, errBodyTooLarge
+			}
+			buf.Write(chunk[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errBodyTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.ContentLength > 0 && int64(buf.Len()) < r.ContentLength {
+		return nil, errBodyTruncated
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyErrorHandler answers a request whose body readBody couldn't read.
+func bodyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		errorHandler(w, r, http.StatusRequestEntityTooLarge, "Request body too large")
+	case errors.Is(err, errBodyTruncated):
+		errorHandler(w, r, http.StatusBadRequest, "Request body was cut short")
+	default:
+		log.Printf("[%v] Could not read request body: %v", requestInfo(r.Context()).ID, err)
+		errorHandler(w, r, http.StatusBadRequest, "Could not read request body")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// drainServer serves a handler that never reads the body behind
+// bodyDrainMiddleware, counting the connections it accepts.
+func drainServer(t *testing.T, limit int64, early bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(bodyDrainMiddleware(limit, early)(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts, &conns
+}
+
+func TestBodyDrainKeepsConnectionAlive(t *testing.T) {
+	for _, early := range []bool{false, true} {
+		ts, conns := drainServer(t, 64<<10, early)
+
+		for i := 0; i < 3; i++ {
+			resp, err := ts.Client().Post(ts.URL, "text/plain", strings.NewReader(strings.Repeat("x", 4096)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.Close {
+				t.Fatalf("early=%v, request %d: server asked to close the connection", early, i)
+			}
+		}
+		if n := conns.Load(); n != 1 {
+			t.Errorf("early=%v: %d connections used for 3 requests, want 1", early, n)
+		}
+	}
+}
+
+func TestBodyDrainClosesBeyondLimit(t *testing.T) {
+	ts, _ := drainServer(t, 16, true)
+
+	resp, err := ts.Client().Post(ts.URL, "text/plain", strings.NewReader(strings.Repeat("x", 4096)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("connection kept open although the unread body was over the drain limit")
+	}
+}
+
+func TestBodyDrainBeforeHeaders(t *testing.T) {
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", 100))}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Body = io.NopCloser(body)
+
+	var readAtHeaders int
+	h := bodyDrainMiddleware(1024, true)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		readAtHeaders = body.n
+	})
+	h(httptest.NewRecorder(), req)
+
+	if readAtHeaders != 100 {
+		t.Errorf("%d of 100 body bytes drained when the headers went out, want all of them", readAtHeaders)
+	}
+}
+
+// TestBodyDrainWaitsForTheHandler checks that a handler still reading the
+// body after its headers are out, as a proxy streaming it upstream does,
+// gets all of it.
+func TestBodyDrainWaitsForTheHandler(t *testing.T) {
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", 100))}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Body = io.NopCloser(body)
+
+	var got []byte
+	h := bodyDrainMiddleware(1024, false)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		got, _ = io.ReadAll(r.Body)
+	})
+	h(httptest.NewRecorder(), req)
+
+	if len(got) != 100 {
+		t.Errorf("handler read %d of 100 body bytes after writing its headers, want all of them", len(got))
+	}
+}
+
+func TestIgnoresBody(t *testing.T) {
+	for _, tc := range []struct {
+		methods []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{http.MethodGet}, true},
+		{[]string{http.MethodGet, http.MethodHead}, true},
+		{[]string{http.MethodPost}, false},
+		{[]string{http.MethodGet, http.MethodPut}, false},
+	} {
+		if got := ignoresBody(route{methods: tc.methods}); got != tc.want {
+			t.Errorf("ignoresBody(%v) = %v, want %v", tc.methods, got, tc.want)
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// eofReader hands out its data in chunks, returning the last chunk
+// together with io.EOF as io.Reader allows.
+type eofReader struct {
+	data  string
+	chunk int
+}
+
+func (er *eofReader) Read(p []byte) (int, error) {
+	n := copy(p[:min(len(p), er.chunk)], er.data)
+	er.data = er.data[n:]
+	if er.data == "" {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		limit         int64
+		want          string
+		wantErr       error
+	}{
+		{"data with EOF", &eofReader{data: "hello world", chunk: 4}, 11, 0, "hello world", nil},
+		{"single read with EOF", &eofReader{data: "all at once", chunk: 64}, 11, 0, "all at once", nil},
+		{"unknown length", &eofReader{data: "chunked", chunk: 3}, -1, 0, "chunked", nil},
+		{"at the limit", &eofReader{data: "12345", chunk: 2}, 5, 5, "12345", nil},
+		{"declared over the limit", strings.NewReader("123456"), 6, 5, "", errBodyTooLarge},
+		{"read over the limit", &eofReader{data: "123456", chunk: 2}, -1, 5, "", errBodyTooLarge},
+		{"shorter than Content-Length", &eofReader{data: "short", chunk: 8}, 20, 0, "", errBodyTruncated},
+		{"unexpected EOF", &failingReader{data: "part", err: io.ErrUnexpectedEOF}, -1, 0, "", errBodyTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Body = io.NopCloser(tt.body)
+			req.ContentLength = tt.contentLength
+
+			got, err := readBody(req, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBodyWithoutBody(t *testing.T) {
+	got, err := readBody(httptest.NewRequest(http.MethodGet, "/", nil), 10)
+	if got != nil || err != nil {
+		t.Errorf("readBody = %q, %v; want nothing", got, err)
+	}
+}
+
+func TestMainHandlerReadsBody(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.MaxBodyBytes = 16
+	handler := newTestServer(t, config)
+
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		status        int
+		bodyBytes     float64
+	}{
+		{"data with EOF", &eofReader{data: "last chunk wins", chunk: 4}, 15, http.StatusOK, 15},
+		{"too large", strings.NewReader(strings.Repeat("x", 17)), 17, http.StatusRequestEntityTooLarge, 0},
+		{"cut short", &eofReader{data: "short", chunk: 8}, 10, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Body = io.NopCloser(tt.body)
+			req.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.status == http.StatusOK && body["body_bytes"] != tt.bodyBytes {
+				t.Errorf("body_bytes = %v, want %v", body["body_bytes"], tt.bodyBytes)
+			}
+		})
+	}
+}
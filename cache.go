@@ -0,0 +1,255 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseCache is a bounded LRU of complete GET responses, each kept for
+// ttl after it was stored.
+type responseCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cachedResponse struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxSize int) *responseCache {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	return &responseCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cachedResponse)
+	if c.now().Sub(entry.storedAt) >= c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry, true
+}
+
+func (c *responseCache) put(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// purgeExpired drops every entry past its ttl. Lookups drop expired entries
+// as they find them; this catches the ones nobody asks for again.
+func (c *responseCache) purgeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, el := range c.entries {
+		if now.Sub(el.Value.(*cachedResponse).storedAt) >= c.ttl {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheKey identifies a response by method, full URL and the request
+// headers responses vary on: Accept for the handlers, Accept-Encoding for
+// compression and Origin for CORS.
+func cacheKey(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI() + "\n" + r.Header.Get("Accept") +
+		"\n" + r.Header.Get("Accept-Encoding") + "\n" + r.Header.Get("Origin")
+}
+
+// uncachedHeaders are never stored with a cached response. The encoding,
+// length and CORS headers belong to whichever request is being answered
+// and are set on it by the layers outside the cache, as are the per-request
+// ones. Hop-by-hop headers aren't part of the response at all.
+var uncachedHeaders = append([]string{
+	"Content-Encoding",
+	"Content-Length",
+	"Vary",
+	"Age",
+	"Date",
+	"X-Request-ID",
+	"X-Timeout",
+}, hopByHopHeaders...)
+
+func isUncachedHeader(name string) bool {
+	return strings.HasPrefix(name, "Access-Control-") || slices.ContainsFunc(uncachedHeaders, func(uncached string) bool {
+		return strings.EqualFold(uncached, name)
+	})
+}
+
+// ownHeaders returns the headers in h that weren't already in outer, the
+// header set before the handler ran: what the handler and the layers
+// inside the cache set themselves, less uncachedHeaders.
+func ownHeaders(h, outer http.Header) http.Header {
+	own := http.Header{}
+	for name, values := range h {
+		if isUncachedHeader(name) || slices.Equal(values, outer[name]) {
+			continue
+		}
+		own[name] = slices.Clone(values)
+	}
+	return own
+}
+
+func clientBypassesCache(r *http.Request) bool {
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store") ||
+		strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+}
+
+func cacheMiddleware(cache *responseCache) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if cache == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next(w, r)
+				return
+			}
+
+			key := cacheKey(r)
+			if !clientBypassesCache(r) {
+				if entry, ok := cache.get(key); ok {
+					serveCached(w, r, entry, cache.now())
+					return
+				}
+			}
+
+			cw := &cachingResponseWriter{ResponseWriter: w, status: http.StatusOK, outer: w.Header().Clone()}
+			next(cw, r)
+
+			if cw.cacheable() {
+				cache.put(&cachedResponse{
+					key:      key,
+					status:   cw.status,
+					header:   cw.header,
+					body:     cw.body,
+					storedAt: cache.now(),
+				})
+			}
+		}
+	}
+}
+
+// serveCached answers from entry. The layers outside the cache have already
+// set this request's own headers, so only the handler's are replayed.
+func serveCached(w http.ResponseWriter, r *http.Request, entry *cachedResponse, now time.Time) {
+	h := w.Header()
+	for name, values := range entry.header {
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(entry.storedAt)/time.Second)))
+	if id := requestInfo(r.Context()).ID; id != 0 {
+		h.Set("X-Request-ID", strconv.FormatUint(id, 10))
+	}
+
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// cachingResponseWriter passes the response through while keeping a copy
+// of it for the cache. The handler's headers are taken when it sends them,
+// before any outer layer gets to add its own on the way out.
+type cachingResponseWriter struct {
+	http.ResponseWriter
+	outer       http.Header
+	header      http.Header
+	status      int
+	body        []byte
+	wroteHeader bool
+	streamed    bool
+}
+
+func (cw *cachingResponseWriter) captureHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	cw.header = ownHeaders(cw.ResponseWriter.Header(), cw.outer)
+}
+
+func (cw *cachingResponseWriter) WriteHeader(status int) {
+	if status >= 200 {
+		cw.captureHeader(status)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cachingResponseWriter) Write(p []byte) (int, error) {
+	cw.captureHeader(http.StatusOK)
+	cw.body = append(cw.body, p...)
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush marks the response as a stream, which is never cached.
+func (cw *cachingResponseWriter) Flush() {
+	cw.streamed = true
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *cachingResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *cachingResponseWriter) cacheable() bool {
+	if cw.streamed || !cw.wroteHeader || cw.status != http.StatusOK {
+		return false
+	}
+
+	h := cw.ResponseWriter.Header()
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
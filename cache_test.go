@@ -0,0 +1,211 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedServer puts h behind the cache, with compression and a CORS-like
+// layer outside it as in the real chain. calls counts handler runs.
+func cachedServer(cache *responseCache, calls *int) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("X-Handler", strconv.Itoa(*calls))
+		writeJSON(w, r, http.StatusOK, map[string]string{"data": strings.Repeat("x", 2048)})
+	}
+	outer := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("X-Outer", r.Header.Get("X-Outer"))
+			next(w, r)
+		}
+	}
+	return chain(handler,
+		outer,
+		compressionMiddleware(&Config{CompressionEnabled: true, CompressionMinSize: 1024}),
+		cacheMiddleware(cache),
+	)
+}
+
+func testCache() (*responseCache, *time.Time) {
+	cache := newResponseCache(10*time.Second, 16)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func get(h http.HandlerFunc, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/data?x=1", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestCacheHit(t *testing.T) {
+	cache, now := testCache()
+	calls := 0
+	h := cachedServer(cache, &calls)
+
+	first := get(h, nil)
+	*now = now.Add(3 * time.Second)
+	second := get(h, nil)
+
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want the second request served from the cache", calls)
+	}
+	if got := second.Header().Get("Age"); got != "3" {
+		t.Errorf("Age = %q, want 3", got)
+	}
+	if first.Header().Get("Age") != "" {
+		t.Error("a fresh response carries Age")
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get("X-Handler") != "1" {
+		t.Error("cache hit differs from the response it was stored from")
+	}
+}
+
+func TestCacheTTLExpiry(t *testing.T) {
+	cache, now := testCache()
+	calls := 0
+	h := cachedServer(cache, &calls)
+
+	get(h, nil)
+	*now = now.Add(10 * time.Second)
+	rec := get(h, nil)
+
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want a miss once the TTL has passed", calls)
+	}
+	if rec.Header().Get("Age") != "" {
+		t.Error("response after expiry carries Age, so it came from the cache")
+	}
+}
+
+func TestCacheNoCacheBypass(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"Cache-Control": "no-cache"},
+		{"Cache-Control": "max-age=0, no-store"},
+		{"Pragma": "no-cache"},
+	} {
+		cache, _ := testCache()
+		calls := 0
+		h := cachedServer(cache, &calls)
+
+		get(h, nil)
+		rec := get(h, headers)
+		if calls != 2 || rec.Header().Get("Age") != "" {
+			t.Errorf("%v: handler ran %d times, want the cache bypassed", headers, calls)
+		}
+	}
+}
+
+func TestCacheNoStoreResponse(t *testing.T) {
+	cache, _ := testCache()
+	calls := 0
+	h := chain(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, http.StatusOK, map[string]string{"status": "success"})
+	}, cacheMiddleware(cache))
+
+	get(h, nil)
+	get(h, nil)
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want a no-store response never cached", calls)
+	}
+}
+
+func TestCacheDoesNotStoreOuterHeaders(t *testing.T) {
+	cache, _ := testCache()
+	calls := 0
+	h := cachedServer(cache, &calls)
+
+	gzipped := get(h, map[string]string{"Accept-Encoding": "gzip", "Origin": "https://a.example", "X-Outer": "first"})
+	if gzipped.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("first response wasn't compressed; the test needs it to be")
+	}
+
+	// A client that doesn't accept gzip must get a plain body.
+	plain := get(h, map[string]string{"Origin": "https://a.example"})
+	if ce := plain.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding = %q on a response to a client that didn't accept gzip", ce)
+	}
+	if !strings.HasPrefix(plain.Body.String(), "{") {
+		t.Errorf("body is not plain JSON: %q", plain.Body.String()[:10])
+	}
+
+	// Another origin must not be handed the first origin's CORS grant.
+	other := get(h, map[string]string{"Accept-Encoding": "gzip", "Origin": "https://b.example"})
+	if acao := other.Header().Get("Access-Control-Allow-Origin"); acao != "https://b.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want https://b.example", acao)
+	}
+
+	// A hit replays the handler's headers, while the outer layers' come from
+	// the live request, and compression still applies.
+	calls = 0
+	hit := get(h, map[string]string{"Accept-Encoding": "gzip", "Origin": "https://a.example", "X-Outer": "second"})
+	if calls != 0 || hit.Header().Get("Age") == "" {
+		t.Fatal("expected a cache hit")
+	}
+	if got := hit.Header().Values("X-Outer"); len(got) != 1 || got[0] != "second" {
+		t.Errorf("X-Outer = %q, want the live request's value only", got)
+	}
+	if hit.Header().Get("X-Handler") != "1" {
+		t.Errorf("X-Handler = %q, want the cached handler header", hit.Header().Get("X-Handler"))
+	}
+	if hit.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("cache hit wasn't compressed for a client accepting gzip")
+	}
+	zr, err := gzip.NewReader(hit.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(body), `{"data":`) {
+		t.Errorf("decoded cache hit = %q, want the JSON body", body[:10])
+	}
+}
+
+func TestOwnHeaders(t *testing.T) {
+	outer := http.Header{"Access-Control-Allow-Origin": {"*"}, "X-Timeout": {"5"}, "Vary": {"Accept-Encoding"}}
+	h := outer.Clone()
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", "12")
+	h.Set("Connection", "close")
+	h.Set("X-Request-ID", "7")
+	h.Add("Vary", "Accept")
+	h.Set("ETag", `"abc"`)
+
+	own := ownHeaders(h, outer)
+	if len(own) != 2 || own.Get("Content-Type") == "" || own.Get("ETag") == "" {
+		t.Errorf("ownHeaders = %v, want only Content-Type and ETag", own)
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newResponseCache(time.Minute, 2)
+	cache.put(&cachedResponse{key: "a", storedAt: cache.now()})
+	cache.put(&cachedResponse{key: "b", storedAt: cache.now()})
+	cache.get("a")
+	cache.put(&cachedResponse{key: "c", storedAt: cache.now()})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b was kept although it was the least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
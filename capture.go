@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"iter"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captures is the request capture when CAPTURE_FILE is set. It is set up
+// with the routes.
+var captures *requestCapture
+
+// maxCapturedBody is how much of each request body is kept in a capture
+// entry; the handler still receives the full body.
+const maxCapturedBody = 64 << 10
+
+// requestCapture appends a sample of incoming requests to a file as NDJSON,
+// one object per line, for replay by load-testing tools. It stops writing
+// once the file reaches maxBytes.
+type requestCapture struct {
+	rate      float64
+	maxBytes  int64
+	redact    *redactor
+	bodyTypes []string
+
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	stopped bool
+}
+
+type capturedRequest struct {
+	Timestamp string              `json:"timestamp"`
+	RequestID uint64              `json:"request_id"`
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Query     string              `json:"query,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+
+	// Body is the redacted body, or "[REDACTED]" when it couldn't be
+	// redacted: it was truncated, didn't parse, or has a type the
+	// redactor doesn't understand.
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+
+	// BodyOmitted marks a body left out for its content type. BodyBytes is
+	// its declared length, when there is one.
+	BodyOmitted bool  `json:"body_omitted,omitempty"`
+	BodyBytes   int64 `json:"body_bytes,omitempty"`
+}
+
+func newRequestCapture(config *Config, redact *redactor) (*requestCapture, error) {
+	file, err := os.OpenFile(config.CaptureFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &requestCapture{
+		rate:      config.CaptureSampleRate,
+		maxBytes:  config.CaptureMaxBytes,
+		redact:    redact,
+		bodyTypes: config.CaptureBodyTypes,
+		path:      config.CaptureFile,
+		file:      file,
+		size:      info.Size(),
+	}, nil
+}
+
+// maxCaptureLine bounds one entry when reading the file back: a full body
+// plus generous room for headers and JSON escaping.
+const maxCaptureLine = 8 * maxCapturedBody
+
+// entries yields the captured requests, oldest first. It reads only as far
+// as the file had been written when it started, so it never sees a line
+// that is still being appended.
+func (rc *requestCapture) entries() iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		rc.mu.Lock()
+		size := rc.size
+		rc.mu.Unlock()
+
+		f, err := os.Open(rc.path)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(io.LimitReader(f, size))
+		sc.Buffer(nil, maxCaptureLine)
+		for sc.Scan() {
+			if !yield(json.RawMessage(sc.Bytes()), nil) {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// capturesHandler streams the capture file as a JSON array. It can run to
+// CAPTURE_MAX_BYTES, so it is never buffered whole.
+func capturesHandler(w http.ResponseWriter, r *http.Request) {
+	if captures == nil {
+		errorHandler(w, r, http.StatusServiceUnavailable, "Request capture is not running")
+		return
+	}
+	streamJSONArray(w, r, http.StatusOK, captures.entries())
+}
+
+func (rc *requestCapture) write(entry *capturedRequest) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[%v] Could not encode captured request: %v", entry.RequestID, err)
+		return
+	}
+	line = append(line, '\n')
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.stopped {
+		return
+	}
+	if rc.size+int64(len(line)) > rc.maxBytes {
+		rc.stopped = true
+		log.Printf("Request capture file reached %d bytes, capture stopped", rc.size)
+		return
+	}
+
+	n, err := rc.file.Write(line)
+	rc.size += int64(n)
+	if err != nil {
+		log.Printf("Could not write captured request: %v", err)
+	}
+}
+
+func captureMiddleware(rc *requestCapture) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if rc == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if rand.Float64() >= rc.rate {
+				next(w, r)
+				return
+			}
+
+			entry := &capturedRequest{
+				Timestamp: formatTimestamp(time.Now()),
+				RequestID: requestInfo(r.Context()).ID,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Headers:   rc.redact.header(r.Header),
+			}
+			if r.URL.RawQuery != "" {
+				entry.Query = rc.redact.query(r.URL.RawQuery)
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if r.Body != nil && r.Body != http.NoBody && !capturableMediaType(mediaType, rc.bodyTypes) {
+				// Going by the declared type means a binary body is never
+				// read just to find out it can't be captured.
+				entry.BodyOmitted = true
+				entry.BodyBytes = max(r.ContentLength, 0)
+			} else if r.Body != nil && r.Body != http.NoBody {
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxCapturedBody+1))
+				// Hand back what was read even after an error, so the
+				// handler sees the same bytes and then the same error.
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				entry.Body = redacted
+				if len(body) > maxCapturedBody {
+					// A cut-off document can't be parsed, so it can't be
+					// redacted either.
+					entry.BodyTruncated = true
+				} else if err == nil {
+					if out, ok := rc.redact.body(mediaType, body); ok {
+						entry.Body = string(out)
+					}
+				}
+			}
+
+			rc.write(entry)
+			next(w, r)
+		}
+	}
+}
+
+// capturableMediaType reports whether bodies of mediaType are kept in
+// capture entries. Patterns are exact media types, "type/*" wildcards, or
+// structured syntax suffixes such as "+json". A body without a declared
+// type is kept, since most clients leave it off small text payloads.
+func capturableMediaType(mediaType string, patterns []string) bool {
+	if mediaType == "" {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case strings.HasPrefix(pattern, "+"):
+			if strings.HasSuffix(mediaType, pattern) {
+				return true
+			}
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case mediaType == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+// readCloser pairs a replacement body reader with the original body's Close.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
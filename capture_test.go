@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestCapture returns a capture of every request and a function that
+// reads back the entries written so far.
+func newTestCapture(t *testing.T, bodyTypes []string) (*requestCapture, func() []capturedRequest) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "capture.ndjson")
+	rc, err := newRequestCapture(&Config{
+		CaptureFile:       file,
+		CaptureSampleRate: 1,
+		CaptureMaxBytes:   10 << 20,
+		CaptureBodyTypes:  bodyTypes,
+	}, newRedactor(defaultRedactHeaders, defaultRedactFields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rc.file.Close() })
+
+	return rc, func() []capturedRequest {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var entries []capturedRequest
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var entry capturedRequest
+			if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+				t.Fatalf("capture line is not JSON: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+func TestCaptureRedactsBodies(t *testing.T) {
+	large := `{"password":"hunter2","pad":"` + strings.Repeat("x", maxCapturedBody) + `"}`
+	tests := []struct {
+		name, contentType, body string
+		want                    string
+		truncated               bool
+	}{
+		{"json", "application/json", `{"user":"a","password":"hunter2"}`, `{"password":"[REDACTED]","user":"a"}`, false},
+		{"nested json", "application/json; charset=utf-8", `{"auth":{"token":"t"}}`, `{"auth":{"token":"[REDACTED]"}}`, false},
+		{"form", "application/x-www-form-urlencoded", "user=a&password=hunter2", "password=%5BREDACTED%5D&user=a", false},
+		{"invalid json", "application/json", `{"password":"hunter2"`, redacted, false},
+		{"invalid form", "application/x-www-form-urlencoded", "password=%zz", redacted, false},
+		{"truncated json", "application/json", large, redacted, true},
+		{"text", "text/plain", "user=a&password=hunter2", "user=a&password=[REDACTED]", false},
+		{"xml", "text/xml", "<login><user>a</user><password>hunter2</password></login>",
+			"<login><user>a</user><password>[REDACTED]</password></login>", false},
+		{"no redactor for type", "application/octet-stream", "password=hunter2", redacted, false},
+		{"no content type", "", "password=hunter2", redacted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, entries := newTestCapture(t, []string{"application/json", "application/x-www-form-urlencoded", "text/*", "application/octet-stream"})
+
+			var received string
+			h := captureMiddleware(rc)(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				received = string(b)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			h(httptest.NewRecorder(), req)
+
+			if received != tt.body {
+				t.Errorf("handler received %d bytes, want the original %d", len(received), len(tt.body))
+			}
+			got := entries()
+			if len(got) != 1 {
+				t.Fatalf("%d entries captured, want 1", len(got))
+			}
+			if got[0].Body != tt.want {
+				t.Errorf("captured body = %q, want %q", got[0].Body, tt.want)
+			}
+			if got[0].BodyTruncated != tt.truncated {
+				t.Errorf("body_truncated = %v, want %v", got[0].BodyTruncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestCaptureOmitsUnlistedTypes(t *testing.T) {
+	rc, entries := newTestCapture(t, []string{"application/json"})
+	var received int
+	h := captureMiddleware(rc)(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = len(b)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("\x89PNG...."))
+	req.Header.Set("Content-Type", "image/png")
+	h(httptest.NewRecorder(), req)
+
+	got := entries()
+	if len(got) != 1 || !got[0].BodyOmitted || got[0].Body != "" || got[0].BodyBytes != 8 {
+		t.Errorf("entry = %+v, want the body omitted with body_bytes 8", got)
+	}
+	if received != 8 {
+		t.Errorf("handler received %d bytes, want 8", received)
+	}
+}
+
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.data == "" {
+		return 0, fr.err
+	}
+	n := copy(p, fr.data)
+	fr.data = fr.data[n:]
+	return n, nil
+}
+
+func TestCaptureRestoresBodyAfterReadError(t *testing.T) {
+	rc, entries := newTestCapture(t, []string{"application/json"})
+	errBroken := errors.New("connection reset")
+
+	var received string
+	var readErr error
+	h := captureMiddleware(rc)(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		received, readErr = string(b), err
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", &failingReader{data: `{"password":"hu`, err: errBroken})
+	req.Header.Set("Content-Type", "application/json")
+	h(httptest.NewRecorder(), req)
+
+	if received != `{"password":"hu` || !errors.Is(readErr, errBroken) {
+		t.Errorf("handler read %q, %v; want the partial body and the original error", received, readErr)
+	}
+	if got := entries(); len(got) != 1 || got[0].Body != redacted {
+		t.Errorf("entry = %+v, want a placeholder body", got)
+	}
+}
+
+func TestCapturesEndpoint(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.DebugEndpoints = true
+	config.CaptureFile = filepath.Join(t.TempDir(), "capture.ndjson")
+	config.CaptureSampleRate = 1
+	config.APIToken = "api-secret"
+	srv := httptest.NewServer(newTestServer(t, config))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { captures.file.Close() })
+
+	for range 3 {
+		resp, err := http.Get(srv.URL + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/debug/captures", nil)
+	req.Header.Set("Authorization", "Bearer api-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	var got []capturedRequest
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("body is not a JSON array of captures: %v\n%s", err, body)
+	}
+	// The listing request is captured before it is served, so it ends
+	// the list.
+	want := []string{"/health", "/health", "/health", "/debug/captures"}
+	if len(got) != len(want) {
+		t.Fatalf("%d captures, want %d", len(got), len(want))
+	}
+	for i, entry := range got {
+		if entry.Path != want[i] {
+			t.Errorf("capture %d path = %q, want %q", i, entry.Path, want[i])
+		}
+	}
+}
+
+func TestCaptureEntriesSkipsPartialLine(t *testing.T) {
+	rc, _ := newTestCapture(t, nil)
+	h := captureMiddleware(rc)(func(w http.ResponseWriter, r *http.Request) {})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/done", nil))
+
+	// A write that has not been counted yet, as if still in progress.
+	if _, err := rc.file.WriteString(`{"path":"/half`); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for raw, err := range rc.entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(raw) {
+			t.Errorf("entry is not JSON: %s", raw)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Errorf("read %d entries, want only the complete one", n)
+	}
+}
+
+func TestCaptureDefaultBodyTypes(t *testing.T) {
+	rc, entries := newTestCapture(t, loadConfig().CaptureBodyTypes)
+	h := captureMiddleware(rc)(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		contentType, body string
+		wantBody          string
+		omitted           bool
+	}{
+		{"application/json", `{"password":"hunter2","n":1}`, `{"n":1,"password":"[REDACTED]"}`, false},
+		{"application/problem+json", `{"token":"t"}`, `{"token":"[REDACTED]"}`, false},
+		{"application/x-www-form-urlencoded", "secret=s", "secret=%5BREDACTED%5D", false},
+		{"text/plain", "password=hunter2", "password=[REDACTED]", false},
+		{"application/xml", "<password>hunter2</password>", "<password>[REDACTED]</password>", false},
+		{"application/soap+xml", "<password>hunter2</password>", "<password>[REDACTED]</password>", false},
+		{"image/png", "\x89PNG\r\n\x1a\n", "", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		h(httptest.NewRecorder(), req)
+	}
+
+	got := entries()
+	if len(got) != len(tests) {
+		t.Fatalf("%d entries captured, want %d", len(got), len(tests))
+	}
+	for i, tt := range tests {
+		entry := got[i]
+		if entry.Body != tt.wantBody || entry.BodyOmitted != tt.omitted {
+			t.Errorf("%s: body = %q, omitted = %v; want %q, %v", tt.contentType, entry.Body, entry.BodyOmitted, tt.wantBody, tt.omitted)
+		}
+		if tt.omitted && entry.BodyBytes != int64(len(tt.body)) {
+			t.Errorf("%s: body_bytes = %d, want %d", tt.contentType, entry.BodyBytes, len(tt.body))
+		}
+	}
+}
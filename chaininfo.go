@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// routeChains lists the middleware each route is wrapped in. It is built
+// with the routes and not changed afterwards.
+var routeChains *routeChainTable
+
+type routeChain struct {
+	Pattern    string   `json:"pattern"`
+	Methods    []string `json:"methods,omitempty"`
+	Auth       string   `json:"auth"`
+	Middleware []string `json:"middleware"`
+}
+
+// routeChainTable holds the middleware in front of the mux, which every
+// request passes through, and each route's own chain, both outermost
+// first. Routes appear in route table order.
+type routeChainTable struct {
+	Server []string     `json:"server"`
+	Routes []routeChain `json:"routes"`
+}
+
+func (t *routeChainTable) record(rt route, middleware []string) {
+	t.Routes = append(t.Routes, routeChain{
+		Pattern:    rt.pattern,
+		Methods:    rt.methods,
+		Auth:       rt.auth.String(),
+		Middleware: middleware,
+	})
+}
+
+// routeChainsHandler lists every route with the names of the middleware
+// wrapping it, outermost first.
+func routeChainsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"server":     routeChains.Server,
+		"routes":     routeChains.Routes,
+		"request_id": requestInfo(r.Context()).ID,
+		"timestamp":  formatTimestamp(time.Now()),
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRouteChainsEndpoint(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.APIToken = "api-secret"
+	config.DebugEndpoints = true
+	handler := newTestServer(t, config)
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/routes/chain", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", rec.Code)
+	}
+
+	rec := get("api-secret")
+	var body struct {
+		Server []string     `json:"server"`
+		Routes []routeChain `json:"routes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	chains := map[string]routeChain{}
+	for _, rc := range body.Routes {
+		chains[rc.Pattern] = rc
+	}
+	if len(chains) != len(routeTable(config)) {
+		t.Errorf("%d routes listed, want all %d", len(chains), len(routeTable(config)))
+	}
+
+	if want := []string{"requestInfoMiddleware", "recoveryMiddleware", "serverOptionsMiddleware"}; !slices.Equal(body.Server, want) {
+		t.Errorf("server chain = %v, want %v", body.Server, want)
+	}
+
+	info := chains["/debug/info"]
+	if info.Auth != authToken.String() || !slices.Contains(info.Middleware, "authMiddleware") {
+		t.Errorf("/debug/info (auth %s) chain = %v, want authMiddleware in it", info.Auth, info.Middleware)
+	}
+	health := chains["/health"]
+	if health.Auth != authNone.String() || slices.Contains(health.Middleware, "authMiddleware") {
+		t.Errorf("/health (auth %s) chain = %v, want no authMiddleware", health.Auth, health.Middleware)
+	}
+
+	// The names follow execution order, outermost first: the recorded
+	// chain of a route is the order setupRoutes composes the layers in,
+	// less those that don't apply to it.
+	order := []string{
+		"forwardedMiddleware", "inFlightMiddleware", "ambiguousLengthMiddleware", "connectionMiddleware",
+		"bodyDrainMiddleware", "corsMiddleware", "experimentMiddleware", "loggingMiddleware",
+		"headerLimitMiddleware", "captureMiddleware", "tenantMiddleware", "rateLimitMiddleware",
+		"compressionMiddleware", "encodedSlashMiddleware",
+		"handlerTimeoutMiddleware", "deprecationMiddleware", "quarantineMiddleware", "disabledRouteMiddleware",
+		"readyOnlyMiddleware", "startupMiddleware", "pauseMiddleware", "loadShedMiddleware",
+		"authMiddleware", "streamingMiddleware", "cacheMiddleware",
+		"methodMiddleware", "acceptMiddleware", "emptyResponseMiddleware",
+	}
+	for pattern, rc := range chains {
+		last := -1
+		for _, name := range rc.Middleware {
+			i := slices.Index(order, name)
+			if i < 0 || i <= last {
+				t.Errorf("%s chain = %v, out of execution order at %s", pattern, rc.Middleware, name)
+				break
+			}
+			last = i
+		}
+	}
+	if robots := chains["/robots.txt"].Middleware; !slices.Equal(robots, []string{"loggingMiddleware", "methodMiddleware"}) {
+		t.Errorf("/robots.txt chain = %v, want only logging and the method check", robots)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var breakerStateGauge = metrics.gauge("circuit_breaker_state",
+	"Circuit breaker state: 0 closed, 1 half-open, 2 open.", "name")
+
+// circuitBreaker guards calls to a dependency. After failureThreshold
+// consecutive failures it opens and fails calls immediately with
+// errCircuitOpen. Once resetTimeout has passed a single trial call is let
+// through (half-open); its result closes or re-opens the breaker.
+type circuitBreaker struct {
+	name             string
+	failureThreshold int
+	resetTimeout     time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trialing bool
+	now      func() time.Time
+}
+
+// newCircuitBreaker creates a breaker with the configured thresholds and
+// reports it through the health registry and metrics under name.
+func newCircuitBreaker(name string, config *Config) *circuitBreaker {
+	cb := &circuitBreaker{
+		name:             name,
+		failureThreshold: config.BreakerFailureThreshold,
+		resetTimeout:     config.BreakerResetTimeout,
+		now:              time.Now,
+	}
+	if cb.failureThreshold < 1 {
+		cb.failureThreshold = 1
+	}
+
+	breakerStateGauge.set(float64(breakerClosed), name)
+	healthChecks.register("breaker:"+name, func() error {
+		if cb.currentState() == breakerOpen {
+			return errCircuitOpen
+		}
+		return nil
+	})
+	return cb
+}
+
+// call runs fn unless the breaker is open, recording its outcome.
+func (cb *circuitBreaker) call(fn func() error) error {
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	err := fn()
+	cb.after(err)
+	return err
+}
+
+func (cb *circuitBreaker) before() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.resetTimeout {
+			return errCircuitOpen
+		}
+		cb.setState(breakerHalfOpen)
+		cb.trialing = true
+	case breakerHalfOpen:
+		if cb.trialing {
+			return errCircuitOpen
+		}
+		cb.trialing = true
+	}
+	return nil
+}
+
+func (cb *circuitBreaker) after(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		cb.trialing = false
+		if err != nil {
+			cb.open()
+			return
+		}
+		cb.failures = 0
+		cb.setState(breakerClosed)
+		return
+	}
+
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= cb.failureThreshold {
+		cb.open()
+	}
+}
+
+func (cb *circuitBreaker) open() {
+	cb.openedAt = cb.now()
+	cb.failures = 0
+	cb.setState(breakerOpen)
+}
+
+func (cb *circuitBreaker) setState(state breakerState) {
+	if cb.state == state {
+		return
+	}
+	log.Printf("Circuit breaker %s: %s -> %s", cb.name, cb.state, state)
+	cb.state = state
+	breakerStateGauge.set(float64(state), cb.name)
+}
+
+func (cb *circuitBreaker) currentState() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// retryAfter is how long until an open breaker lets a trial call through.
+func (cb *circuitBreaker) retryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != breakerOpen {
+		return 0
+	}
+	return cb.resetTimeout - cb.now().Sub(cb.openedAt)
+}
+
+// breakerOpenHandler answers a request whose dependency call was refused by
+// an open breaker, telling the client when the dependency will be retried.
+func breakerOpenHandler(w http.ResponseWriter, r *http.Request, cb *circuitBreaker) {
+	overloadHandler(w, r, http.StatusServiceUnavailable, cb.retryAfter(), "Dependency "+cb.name+" is unavailable")
+}
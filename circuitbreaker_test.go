@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBreaker returns a breaker named after the test, so its health check
+// and gauge series don't collide with other tests'.
+func testBreaker(t *testing.T, threshold int) *circuitBreaker {
+	t.Helper()
+	return newCircuitBreaker(t.Name(), &Config{BreakerFailureThreshold: threshold, BreakerResetTimeout: time.Minute})
+}
+
+var errDependency = errors.New("dependency failed")
+
+func fail() error    { return errDependency }
+func succeed() error { return nil }
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	cb := testBreaker(t, 3)
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	// Closed: failures below the threshold are passed through.
+	for i := 0; i < 2; i++ {
+		if err := cb.call(fail); err != errDependency {
+			t.Fatalf("call %d = %v, want the dependency's error", i, err)
+		}
+	}
+	if cb.currentState() != breakerClosed {
+		t.Fatalf("state = %s after 2 failures, want closed", cb.currentState())
+	}
+
+	// A success resets the count.
+	cb.call(succeed)
+	cb.call(fail)
+	cb.call(fail)
+	if cb.currentState() != breakerClosed {
+		t.Fatalf("state = %s, want closed: the success should have reset the count", cb.currentState())
+	}
+
+	// Closed -> open on the threshold-th consecutive failure.
+	cb.call(fail)
+	if cb.currentState() != breakerOpen {
+		t.Fatalf("state = %s after 3 failures, want open", cb.currentState())
+	}
+
+	// Open: calls fail fast without running.
+	ran := false
+	if err := cb.call(func() error { ran = true; return nil }); err != errCircuitOpen || ran {
+		t.Fatalf("open breaker: err = %v, ran = %v; want errCircuitOpen without running", err, ran)
+	}
+	if got := cb.retryAfter(); got != time.Minute {
+		t.Errorf("retryAfter = %v, want 1m", got)
+	}
+
+	// Open -> half-open once the reset timeout passes; the trial failing
+	// re-opens it.
+	now = now.Add(time.Minute)
+	if err := cb.call(fail); err != errDependency {
+		t.Fatalf("trial call = %v, want it to run", err)
+	}
+	if cb.currentState() != breakerOpen {
+		t.Fatalf("state = %s after a failed trial, want open", cb.currentState())
+	}
+
+	// Half-open -> closed when the trial succeeds.
+	now = now.Add(time.Minute)
+	if err := cb.call(succeed); err != nil {
+		t.Fatalf("trial call = %v, want success", err)
+	}
+	if cb.currentState() != breakerClosed {
+		t.Fatalf("state = %s after a successful trial, want closed", cb.currentState())
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	cb := testBreaker(t, 1)
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	cb.call(fail)
+	now = now.Add(time.Minute)
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- cb.call(func() error { <-release; return nil }) }()
+	waitFor(t, func() bool { return cb.currentState() == breakerHalfOpen })
+
+	if err := cb.call(succeed); err != errCircuitOpen {
+		t.Errorf("second call during the trial = %v, want errCircuitOpen", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("trial call = %v", err)
+	}
+}
+
+func TestCircuitBreakerHealthCheck(t *testing.T) {
+	cb := testBreaker(t, 1)
+	name := "breaker:" + t.Name()
+
+	checks, _ := healthChecks.run()
+	if checks[name] != "ok" {
+		t.Errorf("closed breaker check = %q, want ok", checks[name])
+	}
+	cb.call(fail)
+	checks, healthy := healthChecks.run()
+	if checks[name] != errCircuitOpen.Error() || healthy {
+		t.Errorf("open breaker check = %q (healthy %v), want %q", checks[name], healthy, errCircuitOpen)
+	}
+	if !strings.Contains(metricsOutput(t), `circuit_breaker_state{name="`+t.Name()+`"} 2`) {
+		t.Error("circuit_breaker_state doesn't report the open state")
+	}
+	// Leave the registry healthy for the tests that follow.
+	cb.mu.Lock()
+	cb.setState(breakerClosed)
+	cb.mu.Unlock()
+}
+
+func TestProxyHandlerCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	cb := testBreaker(t, 2)
+	h := proxyHandler(target, http.DefaultTransport, cb)
+	defer func() {
+		cb.mu.Lock()
+		cb.setState(breakerClosed)
+		cb.mu.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: status = %d, want the upstream's 500", i, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("open breaker: status = %d, Retry-After = %q; want 503 with Retry-After (%s)", rec.Code, rec.Header().Get("Retry-After"), body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream called %d times, want 2: the open breaker should fail fast", n)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+)
+
+func compressionMiddleware(config *Config) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if !config.CompressionEnabled {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			// An upgraded connection carries no HTTP body to compress.
+			if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{
+				ResponseWriter: w,
+				minSize:        config.CompressionMinSize,
+				status:         http.StatusOK,
+			}
+			defer gw.Close()
+
+			next(gw, r)
+		}
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows a gzip body.
+// An empty header or one naming only identity means the client wants the
+// body sent as-is.
+func acceptsGzip(header string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+
+	for _, qv := range parseQualityList(header) {
+		switch qv.value {
+		case "gzip", "x-gzip":
+			gzipQ = qv.q
+		case "*":
+			wildcardQ = qv.q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body reaches minSize. Small bodies are written uncompressed since the
+// gzip framing would cost more than it saves.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+
+	buf         []byte
+	gz          *gzip.Writer
+	decided     bool
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = status
+
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		gw.passthrough()
+	}
+}
+
+func (gw *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(p)
+		}
+		return gw.ResponseWriter.Write(p)
+	}
+
+	gw.buf = append(gw.buf, p...)
+	if len(gw.buf) >= gw.minSize {
+		if err := gw.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (gw *gzipResponseWriter) startGzip() error {
+	h := gw.ResponseWriter.Header()
+	if h.Get("Content-Encoding") != "" {
+		return gw.flushBuffered()
+	}
+
+	gw.decided = true
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	_, err := gw.gz.Write(gw.buf)
+	gw.buf = nil
+	return err
+}
+
+func (gw *gzipResponseWriter) passthrough() {
+	if gw.decided {
+		return
+	}
+	gw.decided = true
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+func (gw *gzipResponseWriter) flushBuffered() error {
+	gw.passthrough()
+	if len(gw.buf) == 0 {
+		return nil
+	}
+	_, err := gw.ResponseWriter.Write(gw.buf)
+	gw.buf = nil
+	return err
+}
+
+// Flush sends whatever has been buffered so far. A response that flushes
+// before reaching minSize is treated as a stream and left uncompressed.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.flushBuffered()
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.decided {
+		if !gw.wroteHeader {
+			gw.WriteHeader(http.StatusOK)
+		}
+		return gw.flushBuffered()
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressedHandler(body string) http.HandlerFunc {
+	config := &Config{CompressionEnabled: true, CompressionMinSize: 1024}
+	return compressionMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+	}{
+		{"identity", "identity", large},
+		{"empty", "", large},
+		{"gzip refused", "gzip;q=0, identity", large},
+		{"below min size", "gzip", `{"status":"healthy"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			compressedHandler(tt.body)(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body was altered: got %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressionApplied(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	compressedHandler(body)(rec, req)
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body {
+		t.Errorf("decoded body has %d bytes, want %d", len(decoded), len(body))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                false,
+		"identity":        false,
+		"gzip":            true,
+		"x-gzip":          true,
+		"gzip;q=0":        false,
+		"*":               true,
+		"*;q=0":           false,
+		"br, gzip;q=0.5":  true,
+		"identity, *;q=0": false,
+		"gzip;q=0, *;q=1": false,
+		"deflate, br":     false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var pipelinedConnectionsClosed = metrics.counter("pipelined_connections_closed_total",
+	"HTTP/1.x connections closed for pipelining more than MAX_PIPELINED_REQUESTS requests.")
+
+// connInfo is per-connection state, attached to every request's context
+// through http.Server.ConnContext under "connInfo".
+type connInfo struct {
+	requests atomic.Uint64
+
+	// framing is set for connections accepted through framingListener.
+	framing *framingConn
+
+	// reads is set for connections accepted through readTimingListener.
+	// responded is when the handler of the previous request returned, in
+	// Unix nanoseconds, and pipelined counts the requests that were
+	// already on the wire by then.
+	reads     *readTimingConn
+	responded atomic.Int64
+	pipelined atomic.Uint64
+}
+
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	ci := &connInfo{}
+	for c != nil {
+		switch conn := c.(type) {
+		case *tls.Conn:
+			c = conn.NetConn()
+		case *framingConn:
+			ci.framing, c = conn, conn.Conn
+		case *readTimingConn:
+			ci.reads, c = conn, conn.Conn
+		default:
+			c = nil
+		}
+	}
+	return context.WithValue(ctx, "connInfo", ci)
+}
+
+func connInfoFromContext(ctx context.Context) *connInfo {
+	ci, _ := ctx.Value("connInfo").(*connInfo)
+	return ci
+}
+
+// readTimingListener notes when each connection last received data, which
+// is how pipelined requests are told apart.
+type readTimingListener struct {
+	net.Listener
+}
+
+func (l readTimingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &readTimingConn{Conn: c}, nil
+}
+
+type readTimingConn struct {
+	net.Conn
+	lastRead atomic.Int64
+}
+
+func (c *readTimingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.lastRead.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// wasPipelined reports whether the request now starting was sent before the
+// previous one on the connection had been answered. net/http keeps a read
+// pending while a handler runs, so a client that waits for each response
+// always makes the connection receive data after the previous handler
+// returned; a pipelining client's next request arrived earlier, or was
+// already buffered and needed no read at all.
+func (ci *connInfo) wasPipelined() bool {
+	responded := ci.responded.Load()
+	return ci.reads != nil && responded != 0 && ci.reads.lastRead.Load() < responded
+}
+
+// connectionMiddleware decides the Connection header of HTTP/1.x responses.
+// A client's own Connection: close is always honoured (net/http closes the
+// connection anyway; the header makes it explicit). With maxPipelined set,
+// a connection that has pipelined more requests than that is closed after
+// the current response, dropping whatever else it queued. With maxRequests
+// set, the response to the last allowed request on a connection closes it,
+// so clients reconnect and a load balancer gets the chance to rebalance
+// them. Otherwise, with advertise set, keep-alive is spelled out for
+// upstreams that expect the header. HTTP/2 has no Connection header and is
+// skipped.
+func connectionMiddleware(advertise bool, maxRequests, maxPipelined int) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if !advertise && maxRequests <= 0 && maxPipelined <= 0 {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor != 1 {
+				next(w, r)
+				return
+			}
+
+			var served, pipelined uint64
+			ci := connInfoFromContext(r.Context())
+			if ci != nil {
+				served = ci.requests.Add(1)
+				if ci.wasPipelined() {
+					pipelined = ci.pipelined.Add(1)
+				}
+				defer ci.responded.Store(time.Now().UnixNano())
+			}
+
+			switch {
+			case r.Close:
+				w.Header().Set("Connection", "close")
+			case maxPipelined > 0 && pipelined > uint64(maxPipelined):
+				pipelinedConnectionsClosed.inc()
+				log.Printf("[%d] Closing connection %s after %d pipelined requests, above MAX_PIPELINED_REQUESTS=%d",
+					requestInfo(r.Context()).ID, r.RemoteAddr, pipelined, maxPipelined)
+				w.Header().Set("Connection", "close")
+			case maxRequests > 0 && served >= uint64(maxRequests):
+				w.Header().Set("Connection", "close")
+			case advertise:
+				w.Header().Set("Connection", "keep-alive")
+			}
+
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connectionServer serves handler behind connectionMiddleware with the
+// server's per-connection state attached, as run does.
+func connectionServer(t *testing.T, advertise bool, maxRequests, maxPipelined int) *httptest.Server {
+	t.Helper()
+	h := connectionMiddleware(advertise, maxRequests, maxPipelined)(func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(h))
+	ts.Config.ConnContext = connContext
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// sendOnOneConnection sends the requests one at a time over a single
+// connection and returns each response's Connection header, stopping once
+// the server closes the connection. ReadResponse moves a close token from
+// the header to resp.Close, so it is put back as "close".
+func sendOnOneConnection(t *testing.T, ts *httptest.Server, closeAt int, n int) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	var headers []string
+	for i := 1; i <= n; i++ {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Close = i == closeAt
+		if err := req.Write(conn); err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+		if resp.Close {
+			headers = append(headers, "close")
+			break
+		}
+		headers = append(headers, resp.Header.Get("Connection"))
+	}
+	return headers
+}
+
+func TestConnectionHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		advertise   bool
+		maxRequests int
+		closeAt     int
+		want        []string
+	}{
+		{"default", false, 0, 0, []string{"", "", ""}},
+		{"advertise keep-alive", true, 0, 0, []string{"keep-alive", "keep-alive", "keep-alive"}},
+		{"max requests", false, 2, 0, []string{"", "close"}},
+		{"max requests with keep-alive", true, 2, 0, []string{"keep-alive", "close"}},
+		{"client close", false, 0, 2, []string{"", "close"}},
+		{"client close with keep-alive", true, 0, 1, []string{"close"}},
+		{"client close before max requests", true, 3, 2, []string{"keep-alive", "close"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := connectionServer(t, tt.advertise, tt.maxRequests, 0)
+			got := sendOnOneConnection(t, ts, tt.closeAt, 3)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Connection headers = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Connection headers = %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionHeaderCountsPerConnection(t *testing.T) {
+	ts := connectionServer(t, false, 2, 0)
+	for i := range 2 {
+		if got := sendOnOneConnection(t, ts, 0, 3); len(got) != 2 || got[1] != "close" {
+			t.Errorf("connection %d: Connection headers = %q, want the second response to close it", i, got)
+		}
+	}
+}
+
+func TestConnectionHeaderSkipsHTTP2(t *testing.T) {
+	h := connectionMiddleware(true, 1, 0)(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if got := rec.Header().Get("Connection"); got != "" {
+		t.Errorf("Connection = %q on HTTP/2, want none", got)
+	}
+}
+
+// servePipelining serves the full handler on a listener from listen with
+// MAX_PIPELINED_REQUESTS set, so connections are read-timed as in run.
+func servePipelining(t *testing.T, maxPipelined int) string {
+	t.Helper()
+	config := loadConfig()
+	config.Port = "0"
+	config.MaxPipelinedRequests = maxPipelined
+	ln, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newTestServer(t, config), ConnContext: connContext}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestPipeliningAboveCapClosesConnection(t *testing.T) {
+	logs := captureLog(t)
+	addr := servePipelining(t, 3)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// All ten go out in one write. The first isn't pipelined; the fifth is
+	// the fourth pipelined one, over the cap of 3.
+	if _, err := io.WriteString(conn, strings.Repeat("GET /health HTTP/1.1\r\nHost: x\r\n\r\n", 10)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	answered := 0
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			break
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		answered++
+		if resp.Close {
+			break
+		}
+	}
+	if answered != 5 {
+		t.Errorf("%d pipelined requests answered, want 5 before the connection closed", answered)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("read after the closing response = %v, want EOF", err)
+	}
+
+	if !strings.Contains(logs.String(), "after 4 pipelined requests, above MAX_PIPELINED_REQUESTS=3") {
+		t.Errorf("closure not logged in:\n%s", logs)
+	}
+	if !strings.Contains(metricsOutput(t), "pipelined_connections_closed_total ") {
+		t.Error("metrics missing pipelined_connections_closed_total")
+	}
+}
+
+func TestPipeliningCapSparesSequentialClients(t *testing.T) {
+	captureLog(t)
+	addr := servePipelining(t, 1)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	br := bufio.NewReader(conn)
+	for i := range 10 {
+		if _, err := io.WriteString(conn, "GET /health HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.Close {
+			t.Fatalf("request %d closed the connection of a client that waits for each response", i+1)
+		}
+	}
+}
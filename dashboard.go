@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dashboardTemplate is rendered on the server, so the page works without
+// JavaScript and needs nothing but the one request.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>portServerT {{.Version}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; font-size: 0.9em; }
+th { background: #f4f4f4; }
+.bad { color: #b00; }
+.good { color: #070; }
+</style>
+</head>
+<body>
+<h1>portServerT {{.Version}}</h1>
+
+<section id="status">
+<h2>Status</h2>
+<table>
+<tr><th>State</th><td>{{.Lifecycle}}{{if .Paused}} (paused){{end}}</td></tr>
+<tr><th>Started</th><td>{{.Started}}</td></tr>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th>Goroutines</th><td>{{.Goroutines}}</td></tr>
+<tr><th>Rendered</th><td>{{.Timestamp}}</td></tr>
+</table>
+</section>
+
+<section id="health">
+<h2>Health checks</h2>
+{{if .Health}}<table>
+<tr><th>Check</th><th>Result</th></tr>
+{{range .Health}}<tr><td>{{.Name}}</td><td class="{{if eq .Result "ok"}}good{{else}}bad{{end}}">{{.Result}}</td></tr>
+{{end}}</table>{{else}}<p>No health checks registered.</p>{{end}}
+</section>
+
+<section id="requests">
+<h2>Requests served</h2>
+{{if .Requests}}<table>
+<tr><th>Method</th><th>Route</th><th>Count</th></tr>
+{{range .Requests}}<tr><td>{{.Method}}</td><td>{{.Route}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>{{else}}<p>No requests yet.</p>{{end}}
+</section>
+
+<section id="in-flight">
+<h2>In flight</h2>
+{{if .InFlight}}<table>
+<tr><th>Request ID</th><th>Method</th><th>Path</th><th>Remote address</th><th>Running for</th></tr>
+{{range .InFlight}}<tr><td>{{.ID}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.RemoteAddr}}</td><td>{{.Running}}</td></tr>
+{{end}}</table>{{else}}<p>Nothing in flight.</p>{{end}}
+</section>
+
+<section id="errors">
+<h2>Recent errors</h2>
+{{if .Errors}}<table>
+<tr><th>Time</th><th>Request ID</th><th>Status</th><th>Method</th><th>Path</th><th>Client</th><th>Error</th></tr>
+{{range .Errors}}<tr><td>{{.Timestamp}}</td><td>{{.RequestID}}</td><td class="bad">{{.Status}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.ClientIP}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{else}}<p>No recent errors.</p>{{end}}
+</section>
+
+<section id="features">
+<h2>Feature flags</h2>
+<table>
+<tr><th>Flag</th><th>Enabled</th></tr>
+{{range .Features}}<tr><td>{{.Name}}</td><td>{{.Enabled}}</td></tr>
+{{end}}</table>
+</section>
+</body>
+</html>
+`))
+
+// dashboardErrorLimit caps the recent errors shown, across all routes.
+const dashboardErrorLimit = 20
+
+type dashboardCheck struct {
+	Name   string
+	Result string
+}
+
+type dashboardRouteCount struct {
+	Method string
+	Route  string
+	Count  uint64
+}
+
+type dashboardFeature struct {
+	Name    string
+	Enabled bool
+}
+
+type dashboardRequest struct {
+	ID         uint64
+	Method     string
+	Path       string
+	RemoteAddr string
+	Running    time.Duration
+}
+
+type dashboardData struct {
+	Version    string
+	Lifecycle  string
+	Paused     bool
+	Started    string
+	Uptime     time.Duration
+	Goroutines int
+	Timestamp  string
+	Health     []dashboardCheck
+	Requests   []dashboardRouteCount
+	InFlight   []dashboardRequest
+	Errors     []errorSample
+	Features   []dashboardFeature
+}
+
+// dashboardHandler renders the same data the health, metrics and debug
+// endpoints serve as one HTML page for people rather than tooling.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	data := dashboardData{
+		Version:    version,
+		Lifecycle:  currentLifecycle().String(),
+		Paused:     acceptancePaused.Load(),
+		Started:    formatTimestamp(serverStartTime),
+		Uptime:     now.Sub(serverStartTime).Round(time.Second),
+		Goroutines: runtime.NumGoroutine(),
+		Timestamp:  formatTimestamp(now),
+	}
+
+	checks, _ := healthChecks.run()
+	for _, name := range sortedKeys(checks) {
+		data.Health = append(data.Health, dashboardCheck{Name: name, Result: checks[name]})
+	}
+
+	for _, c := range requestDuration.counts() {
+		data.Requests = append(data.Requests, dashboardRouteCount{Method: c.labelValues[0], Route: c.labelValues[1], Count: c.count})
+	}
+
+	for _, req := range inFlight.snapshot() {
+		data.InFlight = append(data.InFlight, dashboardRequest{
+			ID:         req.id,
+			Method:     req.method,
+			Path:       req.path,
+			RemoteAddr: req.remoteAddr,
+			Running:    now.Sub(req.started).Round(time.Millisecond),
+		})
+	}
+
+	for _, samples := range errorSamples.byRoute("") {
+		data.Errors = append(data.Errors, samples...)
+	}
+	sort.Slice(data.Errors, func(i, j int) bool { return data.Errors[i].RequestID > data.Errors[j].RequestID })
+	data.Errors = data.Errors[:min(len(data.Errors), dashboardErrorLimit)]
+
+	_, features := effectiveConfig(requestConfig(r.Context()))
+	for _, name := range sortedKeys(features) {
+		data.Features = append(data.Features, dashboardFeature{Name: name, Enabled: features[name]})
+	}
+
+	var buf bytes.Buffer
+	if err := dashboardTemplate.Execute(&buf, data); err != nil {
+		log.Printf("[%d] Could not render dashboard: %v", requestInfo(r.Context()).ID, err)
+		errorHandler(w, r, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType("text/html"))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Request-ID", strconv.FormatUint(requestInfo(r.Context()).ID, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
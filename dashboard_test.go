@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	captureLog(t)
+	t.Cleanup(func() { acceptancePaused.Store(false) })
+	// The check stays registered, so it has to pass once the test is done.
+	var diskFull atomic.Bool
+	diskFull.Store(true)
+	t.Cleanup(func() { diskFull.Store(false) })
+	healthChecks.register("dashboard:disk", func() error {
+		if diskFull.Load() {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+
+	config := loadConfig()
+	config.Dashboard = true
+	config.APIToken = "api-secret"
+	handler := newTestServer(t, config)
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve("/health", "")
+	acceptancePaused.Store(true)
+	if rec := serve("/%3Cscript%3E", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("paused request: status = %d, want 503", rec.Code)
+	}
+	acceptancePaused.Store(false)
+
+	if rec := serve("/dashboard", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", rec.Code)
+	}
+	rec := serve("/dashboard", "api-secret")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d, Content-Type = %q, want 200 and HTML", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	page := rec.Body.String()
+	for _, want := range []string{
+		`<section id="status">`, "<td>ready</td>",
+		`<section id="health">`, `<td>dashboard:disk</td><td class="bad">disk full</td>`,
+		`<section id="requests">`, "<td>GET</td><td>/health</td>",
+		`<section id="in-flight">`, "<td>/dashboard</td>",
+		`<section id="errors">`, "Request acceptance is paused", "<td>/&lt;script&gt;</td>",
+		`<section id="features">`, "<td>Dashboard</td><td>true</td>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard missing %q", want)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Error("request path rendered unescaped")
+	}
+}
+
+func TestDashboardDisabledByDefault(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.APIToken = "api-secret"
+	for _, rt := range routeTable(config) {
+		if rt.pattern == "/dashboard" {
+			t.Fatal("/dashboard served without DASHBOARD=true")
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// deprecation marks a route as on its way out. The route keeps working;
+// its responses tell clients when it was deprecated, when it goes away and
+// what replaces it.
+type deprecation struct {
+	since     time.Time
+	sunset    time.Time
+	successor string
+}
+
+// deprecationMiddleware sets Deprecation (RFC 9745), Sunset (RFC 8594) and
+// a successor-version Link on every response from a deprecated route.
+func deprecationMiddleware(d *deprecation) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if d == nil {
+			return next
+		}
+
+		deprecated := "@" + strconv.FormatInt(d.since.Unix(), 10)
+		var sunset string
+		if !d.sunset.IsZero() {
+			sunset = d.sunset.UTC().Format(http.TimeFormat)
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecated)
+			if sunset != "" {
+				w.Header().Set("Sunset", sunset)
+			}
+			if d.successor != "" {
+				w.Header().Add("Link", "<"+d.successor+`>; rel="successor-version"`)
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeprecationHeaders(t *testing.T) {
+	d := &deprecation{
+		since:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		sunset:    time.Date(2026, 7, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+		successor: "/v2/health",
+	}
+	called := false
+	h := deprecationMiddleware(d)(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Add("Link", `</docs>; rel="help"`)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if !called || rec.Code != http.StatusOK {
+		t.Errorf("deprecated route answered %d, want it still served", rec.Code)
+	}
+	if got := rec.Header().Get("Deprecation"); got != "@1767225600" {
+		t.Errorf("Deprecation = %q, want @1767225600", got)
+	}
+	if got := rec.Header().Get("Sunset"); got != "Wed, 01 Jul 2026 10:00:00 GMT" {
+		t.Errorf("Sunset = %q, want the HTTP date in GMT", got)
+	}
+	links := rec.Header().Values("Link")
+	if len(links) != 2 || links[0] != `</v2/health>; rel="successor-version"` {
+		t.Errorf("Link = %q, want the successor first and the handler's own kept", links)
+	}
+}
+
+func TestDeprecationOptionalFields(t *testing.T) {
+	h := deprecationMiddleware(&deprecation{since: time.Unix(1700000000, 0)})(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Deprecation"); got != "@1700000000" {
+		t.Errorf("Deprecation = %q", got)
+	}
+	for _, name := range []string{"Sunset", "Link"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("%s = %q with none configured, want it unset", name, got)
+		}
+	}
+}
+
+func TestDeprecationOnRouteTable(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	rt := route{
+		pattern:    "/old",
+		kind:       routeProbe,
+		handler:    func(w http.ResponseWriter, r *http.Request) {},
+		deprecated: &deprecation{since: time.Unix(1700000000, 0), successor: "/new"},
+	}
+	h, _ := namedChain(rt.handler, routeMiddleware(rt, config, nil, nil, nil)...)
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/old", nil))
+	if rec.Header().Get("Deprecation") == "" || rec.Header().Get("Link") != `</new>; rel="successor-version"` {
+		t.Errorf("headers = %v, want the route's deprecation", rec.Header())
+	}
+
+	rt.deprecated = nil
+	h, _ = namedChain(rt.handler, routeMiddleware(rt, config, nil, nil, nil)...)
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/old", nil))
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("Deprecation set on a route that isn't deprecated")
+	}
+}
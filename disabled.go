@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var disabledRoutes = &routeSwitch{
+	known:    make(map[string]bool),
+	disabled: make(map[string]bool),
+}
+
+// routeSwitch tracks which routes have been switched off. Only routes
+// registered as toggleable can be disabled, which keeps probe and admin
+// routes reachable whatever the configuration says.
+type routeSwitch struct {
+	mu       sync.RWMutex
+	known    map[string]bool
+	disabled map[string]bool
+}
+
+func (rs *routeSwitch) register(pattern string) {
+	rs.mu.Lock()
+	rs.known[pattern] = true
+	rs.mu.Unlock()
+}
+
+// setDisabled switches a route off or back on, reporting false if the
+// route can't be toggled.
+func (rs *routeSwitch) setDisabled(pattern string, disabled bool) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if !rs.known[pattern] {
+		return false
+	}
+	if disabled {
+		rs.disabled[pattern] = true
+	} else {
+		delete(rs.disabled, pattern)
+	}
+	return true
+}
+
+func (rs *routeSwitch) isDisabled(pattern string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.disabled[pattern]
+}
+
+func (rs *routeSwitch) list() []string {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	patterns := make([]string, 0, len(rs.disabled))
+	for pattern := range rs.disabled {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func disabledRouteMiddleware(pattern string, status int) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if disabledRoutes.isDisabled(pattern) {
+				if status == http.StatusServiceUnavailable {
+					errorHandler(w, r, status, "This endpoint is temporarily disabled")
+					return
+				}
+				notFoundHandler(w, r)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func disableRouteHandler(w http.ResponseWriter, r *http.Request) {
+	toggleRoute(w, r, true)
+}
+
+func enableRouteHandler(w http.ResponseWriter, r *http.Request) {
+	toggleRoute(w, r, false)
+}
+
+func toggleRoute(w http.ResponseWriter, r *http.Request, disable bool) {
+	pattern := r.URL.Query().Get("route")
+	if !disabledRoutes.setDisabled(pattern, disable) {
+		errorHandler(w, r, http.StatusBadRequest, "Unknown or protected route: "+pattern)
+		return
+	}
+
+	action := "enable-route"
+	if disable {
+		action = "disable-route"
+	}
+	auditLog(r, action+" "+pattern)
+
+	response := map[string]interface{}{
+		"status":          "success",
+		"route":           pattern,
+		"disabled":        disable,
+		"disabled_routes": disabledRoutes.list(),
+		"request_id":      requestInfo(r.Context()).ID,
+		"timestamp":       formatTimestamp(time.Now()),
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// applyDisabledRoutes disables the routes listed in DISABLED_ROUTES once the
+// toggleable routes are registered.
+func applyDisabledRoutes(patterns []string) {
+	for _, pattern := range patterns {
+		if !disabledRoutes.setDisabled(pattern, true) {
+			log.Printf("Ignoring DISABLED_ROUTES entry %q: unknown or protected route", pattern)
+			continue
+		}
+		log.Printf("Route %s is disabled", pattern)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// resetDisabledRoutes gives the test its own route switch.
+func resetDisabledRoutes(t *testing.T) {
+	t.Helper()
+	prev := disabledRoutes
+	disabledRoutes = &routeSwitch{known: make(map[string]bool), disabled: make(map[string]bool)}
+	t.Cleanup(func() { disabledRoutes = prev })
+}
+
+func TestDisabledRoutes(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			captureLog(t)
+			resetDisabledRoutes(t)
+
+			config := loadConfig()
+			config.AdminToken = "admin-secret"
+			config.DisabledRoutes = []string{"/", "/health", "/admin/pause"}
+			config.DisabledRouteStatus = status
+			handler := newTestServer(t, config)
+
+			serve := func(method, target string) int {
+				req := httptest.NewRequest(method, target, nil)
+				req.Header.Set("Authorization", "Bearer admin-secret")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			if got := serve(http.MethodGet, "/"); got != status {
+				t.Errorf("disabled / = %d, want %d", got, status)
+			}
+			if got := serve(http.MethodGet, "/health"); got != http.StatusOK {
+				t.Errorf("/health = %d; probe routes can't be disabled", got)
+			}
+			if got := serve(http.MethodPost, "/admin/routes/disable?route=/readyz"); got != http.StatusBadRequest {
+				t.Errorf("disabling /readyz = %d, want 400", got)
+			}
+
+			if got := serve(http.MethodPost, "/admin/routes/enable?route=/"); got != http.StatusOK {
+				t.Fatalf("enable = %d, want 200", got)
+			}
+			if got := serve(http.MethodGet, "/"); got != http.StatusOK {
+				t.Errorf("re-enabled / = %d, want 200", got)
+			}
+
+			if got := serve(http.MethodPost, "/admin/routes/disable?route=/"); got != http.StatusOK {
+				t.Fatalf("disable = %d, want 200", got)
+			}
+			if got := serve(http.MethodGet, "/"); got != status {
+				t.Errorf("/ disabled at runtime = %d, want %d", got, status)
+			}
+		})
+	}
+}
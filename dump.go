@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// dumpGoroutinesOnSIGQUIT replaces Go's default SIGQUIT behaviour, dumping
+// every goroutine's stack to stderr and exiting, with appending the dump to
+// path and carrying on, so several dumps can be taken of a hung process.
+func dumpGoroutinesOnSIGQUIT(path string) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+
+	go func() {
+		for range quit {
+			if err := writeGoroutineDump(path); err != nil {
+				log.Printf("Could not write goroutine dump to %s: %v", path, err)
+				continue
+			}
+			log.Printf("Goroutine dump written to %s", path)
+		}
+	}()
+}
+
+func writeGoroutineDump(path string) error {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "=== Goroutine dump at %s, %d goroutines ===\n%s\n",
+		formatTimestamp(time.Now()), runtime.NumGoroutine(), buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteGoroutineDumpAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goroutines.txt")
+	for range 2 {
+		if err := writeGoroutineDump(path); err != nil {
+			t.Fatalf("writeGoroutineDump: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "=== Goroutine dump at "); n != 2 {
+		t.Errorf("file holds %d dumps, want 2", n)
+	}
+	if !strings.Contains(string(data), "TestWriteGoroutineDumpAppends") {
+		t.Error("dump is missing the test goroutine's stack")
+	}
+}
+
+// TestGoroutineDumpOnSIGQUIT signals the test process itself; without the
+// handler, Go's default SIGQUIT behaviour would kill the whole test binary.
+func TestGoroutineDumpOnSIGQUIT(t *testing.T) {
+	logs := captureLog(t)
+	path := filepath.Join(t.TempDir(), "goroutines.txt")
+	dumpGoroutinesOnSIGQUIT(path)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGQUIT); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return strings.Contains(logs.String(), "Goroutine dump written to") })
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "goroutine ") {
+		t.Errorf("dump file holds no stacks: %q", data)
+	}
+}
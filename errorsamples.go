@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errorSamples keeps the most recent 5xx responses of each route, with the
+// request they answered. It is set up with the routes.
+var errorSamples *errorSampleLog
+
+type errorSample struct {
+	RequestID uint64              `json:"request_id"`
+	TraceID   string              `json:"trace_id,omitempty"`
+	Method    string              `json:"method"`
+	Route     string              `json:"route"`
+	Path      string              `json:"path"`
+	Query     string              `json:"query,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+	ClientIP  string              `json:"client_ip"`
+	Status    int                 `json:"status"`
+	Error     string              `json:"error,omitempty"`
+	Timestamp string              `json:"timestamp"`
+}
+
+// errorSampleLog holds up to size samples per route, newest last, so a
+// failing route can't push another route's samples out. Headers and the
+// query string are redacted before they are stored.
+type errorSampleLog struct {
+	size   int
+	redact *redactor
+
+	mu      sync.Mutex
+	samples map[string][]errorSample
+}
+
+func newErrorSampleLog(size int, redact *redactor) *errorSampleLog {
+	if size < 1 {
+		return nil
+	}
+	return &errorSampleLog{size: size, redact: redact, samples: make(map[string][]errorSample)}
+}
+
+func (el *errorSampleLog) record(r *http.Request, status int) {
+	if el == nil {
+		return
+	}
+
+	info := requestInfo(r.Context())
+	sample := errorSample{
+		RequestID: info.ID,
+		TraceID:   info.TraceID,
+		Method:    r.Method,
+		Route:     r.Pattern,
+		Path:      r.URL.Path,
+		Headers:   el.redact.header(r.Header),
+		ClientIP:  info.ClientIP,
+		Status:    status,
+		Error:     info.Error,
+		Timestamp: formatTimestamp(time.Now()),
+	}
+	if r.URL.RawQuery != "" {
+		sample.Query = el.redact.query(r.URL.RawQuery)
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	samples := append(el.samples[sample.Route], sample)
+	if len(samples) > el.size {
+		samples = samples[len(samples)-el.size:]
+	}
+	el.samples[sample.Route] = samples
+}
+
+// byRoute returns the samples of route, or of every route when route is
+// empty, newest first.
+func (el *errorSampleLog) byRoute(route string) map[string][]errorSample {
+	out := map[string][]errorSample{}
+	if el == nil {
+		return out
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	for pattern, samples := range el.samples {
+		if route != "" && pattern != route {
+			continue
+		}
+		newest := make([]errorSample, len(samples))
+		for i, s := range samples {
+			newest[len(samples)-1-i] = s
+		}
+		out[pattern] = newest
+	}
+	return out
+}
+
+// errorSamplesHandler lists recent 5xx responses by route, all of them or
+// those of the route query parameter.
+func errorSamplesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"samples":    errorSamples.byRoute(r.URL.Query().Get("route")),
+		"request_id": requestInfo(r.Context()).ID,
+		"timestamp":  formatTimestamp(time.Now()),
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// useErrorSamples installs el as errorSamples for the test.
+func useErrorSamples(t *testing.T, el *errorSampleLog) {
+	t.Helper()
+	saved := errorSamples
+	errorSamples = el
+	t.Cleanup(func() { errorSamples = saved })
+}
+
+func TestErrorSampleLog(t *testing.T) {
+	if el := newErrorSampleLog(0, newRedactor(nil, nil)); el != nil {
+		t.Errorf("ERROR_SAMPLE_BUFFER=0 gave %+v, want sampling off", el)
+	}
+	var off *errorSampleLog
+	off.record(httptest.NewRequest(http.MethodGet, "/", nil), 500)
+	if got := off.byRoute(""); len(got) != 0 {
+		t.Errorf("disabled log returned %v", got)
+	}
+
+	el := newErrorSampleLog(2, newRedactor(nil, nil))
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Pattern = "/"
+		el.record(withRequestInfo(req, &RequestInfo{ID: uint64(i)}), 500)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Pattern = "/health"
+	el.record(withRequestInfo(req, &RequestInfo{ID: 9}), 503)
+
+	ids := func(samples []errorSample) []uint64 {
+		var out []uint64
+		for _, s := range samples {
+			out = append(out, s.RequestID)
+		}
+		return out
+	}
+	all := el.byRoute("")
+	if got := ids(all["/"]); !slices.Equal(got, []uint64{3, 2}) {
+		t.Errorf("/ samples = %v, want the newest two, 3 then 2", got)
+	}
+	if got := ids(all["/health"]); !slices.Equal(got, []uint64{9}) {
+		t.Errorf("/health samples = %v, want 9; a busy route must not evict another's", got)
+	}
+	if got := el.byRoute("/health"); len(got) != 1 || got["/health"] == nil {
+		t.Errorf("byRoute(/health) = %v, want only /health", got)
+	}
+}
+
+func TestRecoveryRecordsErrorSamples(t *testing.T) {
+	captureLog(t)
+	useErrorSamples(t, newErrorSampleLog(10, newRedactor(defaultRedactHeaders, defaultRedactFields)))
+
+	serve := func(h http.HandlerFunc) {
+		req := httptest.NewRequest(http.MethodPost, "/orders?token=abc&page=2", nil)
+		req.Pattern = "/orders"
+		req.Header.Set("Authorization", "Bearer api-secret")
+		req.Header.Set("Cookie", "session=s3cret")
+		req.Header.Set("User-Agent", "probe/1.0")
+		req = withRequestInfo(req, &RequestInfo{ID: 42, TraceID: "trace-1", ClientIP: "192.0.2.10", config: loadConfig()})
+		recoveryMiddleware(h)(httptest.NewRecorder(), req)
+	}
+	serve(func(w http.ResponseWriter, r *http.Request) {
+		errorHandler(w, r, http.StatusInternalServerError, "Database unavailable")
+	})
+	serve(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	serve(func(w http.ResponseWriter, r *http.Request) {
+		errorHandler(w, r, http.StatusNotFound, "Not found")
+	})
+
+	samples := errorSamples.byRoute("/orders")["/orders"]
+	if len(samples) != 2 {
+		t.Fatalf("%d samples, want the 500 and the panic but not the 404: %+v", len(samples), samples)
+	}
+	if got := samples[0].Error; got != "panic: boom" {
+		t.Errorf("panic sample error = %q, want %q", got, "panic: boom")
+	}
+
+	s := samples[1]
+	if s.Status != 500 || s.Error != "Database unavailable" || s.Method != http.MethodPost || s.Path != "/orders" {
+		t.Errorf("sample = %+v, want the POST /orders 500 with its error", s)
+	}
+	if s.RequestID != 42 || s.TraceID != "trace-1" || s.ClientIP != "192.0.2.10" {
+		t.Errorf("request context = %d, %q, %q, want 42, trace-1, 192.0.2.10", s.RequestID, s.TraceID, s.ClientIP)
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if got := s.Headers[name]; !slices.Equal(got, []string{redacted}) {
+			t.Errorf("%s = %q, want it redacted", name, got)
+		}
+	}
+	if got := s.Headers["User-Agent"]; !slices.Equal(got, []string{"probe/1.0"}) {
+		t.Errorf("User-Agent = %q, want it kept", got)
+	}
+	if want := "page=2&token=" + "%5BREDACTED%5D"; s.Query != want {
+		t.Errorf("query = %q, want %q", s.Query, want)
+	}
+}
+
+func TestErrorSamplesEndpoint(t *testing.T) {
+	captureLog(t)
+	t.Cleanup(func() { acceptancePaused.Store(false) })
+	config := loadConfig()
+	config.DebugEndpoints = true
+	config.APIToken = "api-secret"
+	handler := newTestServer(t, config)
+
+	// A paused server answers application routes with a 503.
+	acceptancePaused.Store(true)
+	req := httptest.NewRequest(http.MethodGet, "/?password=hunter2", nil)
+	req.Header.Set("Cookie", "session=s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("paused request: status = %d, want 503", rec.Code)
+	}
+	acceptancePaused.Store(false)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("/debug/errors", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status = %d, want 401", rec.Code)
+	}
+
+	rec = get("/debug/errors?route=/", "api-secret")
+	var body struct {
+		Samples map[string][]errorSample `json:"samples"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("status %d, body %s: %v", rec.Code, rec.Body, err)
+	}
+	samples := body.Samples["/"]
+	if len(body.Samples) != 1 || len(samples) != 1 {
+		t.Fatalf("samples = %+v, want one for /", body.Samples)
+	}
+	s := samples[0]
+	if s.Status != 503 || s.Error != "Request acceptance is paused" || s.ClientIP != "192.0.2.1" || s.RequestID == 0 {
+		t.Errorf("sample = %+v, want the paused 503 with its client and request ID", s)
+	}
+	if got := s.Headers["Cookie"]; !slices.Equal(got, []string{redacted}) {
+		t.Errorf("Cookie = %q, want it redacted", got)
+	}
+	if want := fmt.Sprintf("password=%s", "%5BREDACTED%5D"); s.Query != want {
+		t.Errorf("query = %q, want %q", s.Query, want)
+	}
+}
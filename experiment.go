@@ -0,0 +1,100 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var experimentRequests = metrics.counter("experiment_requests_total",
+	"Requests served, by experiment variant and status code.", "variant", "code")
+
+type experimentVariant struct {
+	name   string
+	weight int
+}
+
+// experiment splits traffic between variants in proportion to their weights.
+// Assignment hashes a key taken from the request, so the same client always
+// lands in the same variant for as long as the weights stay the same.
+type experiment struct {
+	variants []experimentVariant
+	total    int
+	key      string
+}
+
+// newExperiment parses "name:weight" entries. It returns nil, leaving
+// experiments off, when spec is empty or any entry is invalid.
+func newExperiment(spec []string, key string) *experiment {
+	if len(spec) == 0 {
+		return nil
+	}
+
+	switch key {
+	case "client_ip", "request_id":
+	default:
+		log.Printf("Invalid value for EXPERIMENT_KEY: %q, using client_ip", key)
+		key = "client_ip"
+	}
+
+	e := &experiment{key: key}
+	for _, entry := range spec {
+		name, weightStr, ok := strings.Cut(entry, ":")
+		weight, err := strconv.Atoi(strings.TrimSpace(weightStr))
+		name = strings.TrimSpace(name)
+		if !ok || err != nil || weight < 0 || name == "" {
+			log.Printf("Invalid EXPERIMENT_VARIANTS entry %q, experiments disabled", entry)
+			return nil
+		}
+		e.variants = append(e.variants, experimentVariant{name: name, weight: weight})
+		e.total += weight
+	}
+	if e.total == 0 {
+		log.Printf("EXPERIMENT_VARIANTS has no positive weight, experiments disabled")
+		return nil
+	}
+	return e
+}
+
+// bucketKey is the value hashed to pick a variant. With the request_id key a
+// caller-supplied X-Request-ID keeps a multi-request flow in one variant;
+// requests without one fall back to the client IP.
+func (e *experiment) bucketKey(r *http.Request) string {
+	if e.key == "request_id" {
+		if id := r.Header.Get("X-Request-ID"); id != "" {
+			return id
+		}
+	}
+	return requestInfo(r.Context()).ClientIP
+}
+
+func (e *experiment) assign(r *http.Request) string {
+	h := fnv.New32a()
+	h.Write([]byte(e.bucketKey(r)))
+	point := int(h.Sum32() % uint32(e.total))
+
+	for _, v := range e.variants {
+		if point < v.weight {
+			return v.name
+		}
+		point -= v.weight
+	}
+	return e.variants[len(e.variants)-1].name
+}
+
+// experimentMiddleware records the assigned variant on the request's
+// RequestInfo for handlers and the request log to branch on.
+func experimentMiddleware(e *experiment) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if e == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			requestInfo(r.Context()).Variant = e.assign(r)
+			next(w, r)
+		}
+	}
+}
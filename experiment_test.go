@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func experimentRequest(clientIP, requestID string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if requestID != "" {
+		r.Header.Set("X-Request-ID", requestID)
+	}
+	return withRequestInfo(r, &RequestInfo{ClientIP: clientIP})
+}
+
+func TestExperimentWeights(t *testing.T) {
+	e := newExperiment([]string{"control:80", "treatment:15", "holdout:5"}, "client_ip")
+
+	const clients = 20000
+	counts := map[string]int{}
+	for i := range clients {
+		counts[e.assign(experimentRequest(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), ""))]++
+	}
+	for name, weight := range map[string]float64{"control": 0.80, "treatment": 0.15, "holdout": 0.05} {
+		if got := float64(counts[name]) / clients; math.Abs(got-weight) > 0.02 {
+			t.Errorf("%s got %.3f of clients, want about %.2f", name, got, weight)
+		}
+	}
+}
+
+func TestExperimentStickiness(t *testing.T) {
+	byIP := newExperiment([]string{"a:50", "b:50"}, "client_ip")
+	byID := newExperiment([]string{"a:50", "b:50"}, "request_id")
+
+	for i := range 50 {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		first := byIP.assign(experimentRequest(ip, ""))
+		for range 20 {
+			if got := byIP.assign(experimentRequest(ip, fmt.Sprint(i))); got != first {
+				t.Fatalf("client %s moved from %s to %s", ip, first, got)
+			}
+		}
+
+		flow := fmt.Sprintf("flow-%d", i)
+		first = byID.assign(experimentRequest("198.51.100.1", flow))
+		if got := byID.assign(experimentRequest("198.51.100.2", flow)); got != first {
+			t.Fatalf("request ID %s moved from %s to %s across clients", flow, first, got)
+		}
+	}
+}
+
+func TestNewExperimentRejectsInvalidSpecs(t *testing.T) {
+	captureLog(t)
+	for _, spec := range [][]string{nil, {"a"}, {"a:x"}, {"a:-1"}, {":5"}, {"a:0", "b:0"}} {
+		if e := newExperiment(spec, "client_ip"); e != nil {
+			t.Errorf("newExperiment(%q) = %+v, want experiments off", spec, e)
+		}
+	}
+	if e := newExperiment([]string{"a:1"}, "cookie"); e == nil || e.key != "client_ip" {
+		t.Errorf("unknown key not replaced by client_ip: %+v", e)
+	}
+}
+
+func TestExperimentMiddlewareVariantInLogsAndMetrics(t *testing.T) {
+	logs := captureLog(t)
+	e := newExperiment([]string{"only:1"}, "client_ip")
+
+	var seen string
+	h := experimentMiddleware(e)(loggingMiddleware(&Config{})(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestInfo(r.Context()).Variant
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	h(httptest.NewRecorder(), experimentRequest("203.0.113.9", ""))
+
+	if seen != "only" {
+		t.Errorf("handler saw variant %q, want only", seen)
+	}
+	if !strings.Contains(logs.String(), "| Variant: only") {
+		t.Errorf("variant missing from the request log:\n%s", logs)
+	}
+	if !strings.Contains(metricsOutput(t), `experiment_requests_total{variant="only",code="418"} `) {
+		t.Error("experiment_requests_total has no series for the variant")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the peers whose Forwarded and X-Forwarded-* headers are
+// believed. Requests from anyone else are taken at face value.
+type trustedProxies []netip.Prefix
+
+// parseAddrOrPrefix reads an address or CIDR into a prefix, a bare address
+// becoming a prefix of just itself.
+func parseAddrOrPrefix(value string) (netip.Prefix, bool) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return prefix.Masked(), true
+}
+
+// parseTrustedProxies reads addresses and CIDRs. Invalid entries are logged
+// and skipped.
+func parseTrustedProxies(spec []string) trustedProxies {
+	var proxies trustedProxies
+	for _, entry := range spec {
+		prefix, ok := parseAddrOrPrefix(strings.TrimSpace(entry))
+		if !ok {
+			log.Printf("Invalid TRUSTED_PROXIES entry %q, ignoring it", entry)
+			continue
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies
+}
+
+// trusts reports whether node, an address or a Forwarded node identifier,
+// is a trusted proxy. Obfuscated identifiers and "unknown" never are.
+func (tp trustedProxies) trusts(node string) bool {
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedElement is one proxy hop from a Forwarded header.
+type forwardedElement struct {
+	forNode string
+	proto   string
+	host    string
+}
+
+// parseForwarded splits Forwarded header values (RFC 7239) into their
+// elements, in the order the hops were added. Parameter values may be tokens
+// or quoted strings; a malformed header yields nothing, so it is ignored as
+// a whole rather than half believed.
+func parseForwarded(values []string) ([]forwardedElement, bool) {
+	var elements []forwardedElement
+	for _, value := range values {
+		for _, raw := range splitQuoted(value, ',') {
+			if strings.TrimSpace(raw) == "" {
+				continue
+			}
+			var el forwardedElement
+			for _, pair := range splitQuoted(raw, ';') {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					return nil, false
+				}
+				val, ok = unquoteForwarded(val)
+				if !ok {
+					return nil, false
+				}
+				switch strings.ToLower(key) {
+				case "for":
+					el.forNode = forwardedNodeAddr(val)
+				case "proto":
+					el.proto = strings.ToLower(val)
+				case "host":
+					el.host = val
+				}
+			}
+			elements = append(elements, el)
+		}
+	}
+	return elements, len(elements) > 0
+}
+
+// splitQuoted splits s on sep, except where sep is inside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteForwarded(v string) (string, bool) {
+	if !strings.HasPrefix(v, `"`) {
+		return v, v != "" && !strings.ContainsAny(v, `" `)
+	}
+	if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+		return "", false
+	}
+
+	var b strings.Builder
+	inner := v[1 : len(v)-1]
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+		}
+		b.WriteByte(inner[i])
+	}
+	return b.String(), true
+}
+
+// forwardedNodeAddr strips the port from a node identifier such as
+// "192.0.2.43:47011" or "[2001:db8::17]:4711", leaving obfuscated
+// identifiers ("_hidden") and "unknown" as they are.
+func forwardedNodeAddr(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// forwardedMiddleware works out the original client, scheme and host of a
+// request that came through trusted proxies. Forwarded takes precedence
+// over X-Forwarded-For, -Proto and -Host. Hops are walked from the nearest
+// one back; the client is the first hop that isn't itself a trusted proxy,
+// so entries a client prepends itself are never reached. The client ends
+// up in RequestInfo.ClientIP, the scheme in r.URL.Scheme and the host in
+// r.Host.
+func forwardedMiddleware(proxies trustedProxies) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if len(proxies) == 0 {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !proxies.trusts(clientIP(r)) {
+				next(w, r)
+				return
+			}
+
+			var hop forwardedElement
+			if elements, ok := parseForwarded(r.Header.Values("Forwarded")); ok {
+				hop = elements[0]
+				for i := len(elements) - 1; i >= 0; i-- {
+					if !proxies.trusts(elements[i].forNode) {
+						hop = elements[i]
+						break
+					}
+				}
+			} else {
+				if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+					nodes := strings.Split(strings.Join(xff, ","), ",")
+					hop.forNode = strings.TrimSpace(nodes[0])
+					for i := len(nodes) - 1; i >= 0; i-- {
+						if node := strings.TrimSpace(nodes[i]); !proxies.trusts(node) {
+							hop.forNode = node
+							break
+						}
+					}
+				}
+				hop.proto = strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")))
+				hop.host = strings.TrimSpace(r.Header.Get("X-Forwarded-Host"))
+			}
+
+			if hop.forNode != "" {
+				requestInfo(r.Context()).ClientIP = hop.forNode
+			}
+			if hop.proto == "http" || hop.proto == "https" {
+				r.URL.Scheme = hop.proto
+			}
+			if hop.host != "" {
+				r.Host = hop.host
+			}
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	logs := captureLog(t)
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 ", "2001:db8::/32", "not-an-ip"})
+	if len(proxies) != 3 {
+		t.Fatalf("proxies = %v, want 3 entries", proxies)
+	}
+	for node, want := range map[string]bool{
+		"10.9.8.7":         true,
+		"192.0.2.1":        true,
+		"192.0.2.2":        false,
+		"::ffff:10.0.0.1":  true,
+		"2001:db8::17":     true,
+		"_hidden":          false,
+		"unknown":          false,
+		"198.51.100.7:443": false,
+	} {
+		if got := proxies.trusts(node); got != want {
+			t.Errorf("trusts(%q) = %v, want %v", node, got, want)
+		}
+	}
+	if !strings.Contains(logs.String(), `Invalid TRUSTED_PROXIES entry "not-an-ip"`) {
+		t.Errorf("invalid entry not logged in:\n%s", logs)
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []forwardedElement
+	}{
+		{"single element", []string{"for=192.0.2.60;proto=HTTPS;host=example.com"},
+			[]forwardedElement{{"192.0.2.60", "https", "example.com"}}},
+		{"multiple elements", []string{"for=192.0.2.43, for=198.51.100.17;proto=http"},
+			[]forwardedElement{{"192.0.2.43", "", ""}, {"198.51.100.17", "http", ""}}},
+		{"multiple header lines", []string{"for=192.0.2.43", "For=10.0.0.1"},
+			[]forwardedElement{{"192.0.2.43", "", ""}, {"10.0.0.1", "", ""}}},
+		{"quoted IPv6 with port", []string{`for="[2001:db8:cafe::17]:4711"`},
+			[]forwardedElement{{"2001:db8:cafe::17", "", ""}}},
+		{"quoted IPv4 with port", []string{`for="192.0.2.43:47011";host="a.example,b"`},
+			[]forwardedElement{{"192.0.2.43", "", "a.example,b"}}},
+		{"escaped quote", []string{`for=_x;host="ex\"ample"`},
+			[]forwardedElement{{"_x", "", `ex"ample`}}},
+		{"obfuscated and unknown", []string{"for=_hidden, for=unknown"},
+			[]forwardedElement{{"_hidden", "", ""}, {"unknown", "", ""}}},
+		{"missing value", []string{"for"}, nil},
+		{"unterminated quote", []string{`for="192.0.2.43`}, nil},
+		{"empty", []string{""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseForwarded(tt.values)
+			if ok != (tt.want != nil) {
+				t.Fatalf("ok = %v, want %v (elements %v)", ok, tt.want != nil, got)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("elements = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("element %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedMiddleware(t *testing.T) {
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		client     string
+		scheme     string
+		host       string
+	}{
+		{"single element", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=192.0.2.60;proto=https;host=example.com"},
+			"192.0.2.60", "https", "example.com"},
+		{"walks back past trusted hops", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=203.0.113.1, for=192.0.2.60, for=10.0.0.2"},
+			"192.0.2.60", "", "internal"},
+		{"quoted identifier", "10.0.0.1:1234",
+			map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`},
+			"2001:db8:cafe::17", "", "internal"},
+		{"obfuscated identifier", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=192.0.2.60, for=_gateway"},
+			"_gateway", "", "internal"},
+		{"all hops trusted", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=10.0.0.3, for=10.0.0.2"},
+			"10.0.0.3", "", "internal"},
+		{"takes precedence over X-Forwarded", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=192.0.2.60;proto=http", "X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "other.example"},
+			"192.0.2.60", "http", "internal"},
+		{"X-Forwarded fallback", "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.1, 198.51.100.1, 10.0.0.2", "X-Forwarded-Proto": "HTTPS", "X-Forwarded-Host": "other.example"},
+			"198.51.100.1", "https", "other.example"},
+		{"malformed Forwarded falls back", "10.0.0.1:1234",
+			map[string]string{"Forwarded": `for="192.0.2.60`, "X-Forwarded-For": "198.51.100.1"},
+			"198.51.100.1", "", "internal"},
+		{"unknown proto ignored", "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=192.0.2.60;proto=gopher"},
+			"192.0.2.60", "", "internal"},
+		{"untrusted peer", "203.0.113.9:1234",
+			map[string]string{"Forwarded": "for=192.0.2.60;proto=https;host=example.com"},
+			"", "", "internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var client, scheme, host string
+			h := forwardedMiddleware(proxies)(func(w http.ResponseWriter, r *http.Request) {
+				client = requestInfo(r.Context()).ClientIP
+				scheme, host = r.URL.Scheme, r.Host
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Host = "internal"
+			req.URL.Scheme = ""
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			h(httptest.NewRecorder(), withRequestInfo(req, &RequestInfo{}))
+
+			if client != tt.client || scheme != tt.scheme || host != tt.host {
+				t.Errorf("client, scheme, host = %q, %q, %q, want %q, %q, %q",
+					client, scheme, host, tt.client, tt.scheme, tt.host)
+			}
+		})
+	}
+}
+
+func TestForwardedMiddlewareWithoutTrustedProxies(t *testing.T) {
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) { called = true }
+	h := forwardedMiddleware(nil)(next)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Forwarded", "for=192.0.2.60;host=forwarded.example")
+	h(httptest.NewRecorder(), req)
+	if !called {
+		t.Fatal("handler not called")
+	}
+	if req.Host == "forwarded.example" {
+		t.Error("Forwarded host believed with no trusted proxies")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+)
+
+var goroutineCount = metrics.gauge("goroutines",
+	"Goroutines in the process, sampled by the goroutine monitor.")
+
+// goroutineMonitor samples the goroutine count on the scheduler. A count
+// that only goes up across a window of samples suggests a leak even while
+// it is still far from any absolute threshold.
+type goroutineMonitor struct {
+	threshold int
+	window    int
+
+	samples []int
+	warned  bool
+	count   func() int
+}
+
+// newGoroutineMonitor takes a window in samples; fewer than two leaves the
+// growth check off, as does a threshold of zero for the threshold check.
+func newGoroutineMonitor(threshold, window int) *goroutineMonitor {
+	return &goroutineMonitor{threshold: threshold, window: window, count: runtime.NumGoroutine}
+}
+
+// sample runs on the scheduler, which never overlaps runs of the same task,
+// so it needs no locking.
+func (m *goroutineMonitor) sample(context.Context) error {
+	n := m.count()
+	goroutineCount.set(float64(n))
+
+	if m.threshold > 0 && n > m.threshold {
+		log.Printf("WARNING - %d goroutines, above GOROUTINE_WARN_THRESHOLD=%d", n, m.threshold)
+	}
+
+	if m.window < 2 {
+		return nil
+	}
+	m.samples = append(m.samples, n)
+	if len(m.samples) > m.window {
+		m.samples = m.samples[1:]
+	}
+	if len(m.samples) < m.window {
+		return nil
+	}
+
+	growing := true
+	for i := 1; i < len(m.samples); i++ {
+		if m.samples[i] <= m.samples[i-1] {
+			growing = false
+			break
+		}
+	}
+	// Warn once per run of growth rather than on every sample of it.
+	if growing && !m.warned {
+		log.Printf("WARNING - Goroutines grew on each of the last %d samples, from %d to %d; possible leak",
+			len(m.samples), m.samples[0], n)
+	}
+	m.warned = growing
+	return nil
+}
+
+// watchGoroutines puts a goroutine monitor on the scheduler.
+func watchGoroutines(interval time.Duration, threshold int, window time.Duration) {
+	samples := 0
+	if interval > 0 {
+		samples = int(window / interval)
+	}
+	monitor := newGoroutineMonitor(threshold, samples)
+	scheduler.every("goroutine-monitor", interval, monitor.sample)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// leak starts n goroutines that block until the test ends.
+func leak(t *testing.T, n int) {
+	t.Helper()
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	for range n {
+		go func() { <-release }()
+	}
+}
+
+func TestGoroutineMonitorThreshold(t *testing.T) {
+	logs := captureLog(t)
+	m := newGoroutineMonitor(runtime.NumGoroutine()+10, 0)
+
+	m.sample(context.Background())
+	if strings.Contains(logs.String(), "WARNING") {
+		t.Fatalf("warned below the threshold:\n%s", logs)
+	}
+
+	leak(t, 20)
+	m.sample(context.Background())
+	if !strings.Contains(logs.String(), fmt.Sprintf("goroutines, above GOROUTINE_WARN_THRESHOLD=%d", m.threshold)) {
+		t.Errorf("no threshold warning after leaking 20 goroutines:\n%s", logs)
+	}
+	m.count = func() int { return 1234 }
+	m.sample(context.Background())
+	if !strings.Contains(metricsOutput(t), "goroutines 1234\n") {
+		t.Error("goroutines gauge doesn't report the sampled count")
+	}
+}
+
+func TestGoroutineMonitorGrowth(t *testing.T) {
+	logs := captureLog(t)
+	m := newGoroutineMonitor(0, 4)
+
+	for range 4 {
+		leak(t, 5)
+		m.sample(context.Background())
+	}
+	if got := strings.Count(logs.String(), "possible leak"); got != 1 {
+		t.Fatalf("%d growth warnings after 4 growing samples, want 1:\n%s", got, logs)
+	}
+
+	// Still growing: the same run of growth isn't reported again.
+	leak(t, 5)
+	m.sample(context.Background())
+	if got := strings.Count(logs.String(), "possible leak"); got != 1 {
+		t.Errorf("%d growth warnings during one run of growth, want 1", got)
+	}
+
+	// A flat sample ends the run, and new growth is reported afresh.
+	m.sample(context.Background())
+	for range 3 {
+		leak(t, 5)
+		m.sample(context.Background())
+	}
+	if got := strings.Count(logs.String(), "possible leak"); got != 2 {
+		t.Errorf("%d growth warnings after growth resumed, want 2:\n%s", got, logs)
+	}
+}
+
+func TestGoroutineMonitorSmallWindow(t *testing.T) {
+	logs := captureLog(t)
+	counts := []int{10, 20, 30}
+	m := newGoroutineMonitor(0, 2)
+	m.count = func() int {
+		n := counts[0]
+		counts = counts[1:]
+		return n
+	}
+	for range 3 {
+		m.sample(context.Background())
+	}
+	if !strings.Contains(logs.String(), "grew on each of the last 2 samples, from 10 to 20") {
+		t.Errorf("window of 2 didn't report growth:\n%s", logs)
+	}
+
+	m = newGoroutineMonitor(0, 1)
+	m.count = func() int { return 1 }
+	m.sample(context.Background())
+	if len(m.samples) != 0 {
+		t.Error("a window under 2 samples still kept samples")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+var errHeadersTooLarge = errors.New("response headers exceed the configured limit")
+
+// headerLimitMiddleware refuses to send response headers larger than limit.
+// Handlers that copy request input into a header could otherwise send
+// something no proxy or client will accept; the oversized response is
+// replaced by a 500 instead. A limit of 0 turns the check off.
+func headerLimitMiddleware(limit int) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if limit <= 0 {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(&headerLimitWriter{ResponseWriter: w, r: r, limit: limit}, r)
+		}
+	}
+}
+
+// headerSize approximates the wire size of h, counting ": " and CRLF for
+// every value.
+func headerSize(h http.Header) int {
+	size := 0
+	for key, values := range h {
+		for _, value := range values {
+			size += len(key) + len(value) + 4
+		}
+	}
+	return size
+}
+
+type headerLimitWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	limit int
+
+	checked  bool
+	rejected bool
+}
+
+// check runs once, just before the headers would go out.
+func (hw *headerLimitWriter) check() bool {
+	if hw.checked {
+		return !hw.rejected
+	}
+	hw.checked = true
+
+	h := hw.ResponseWriter.Header()
+	size := headerSize(h)
+	if size <= hw.limit {
+		return true
+	}
+
+	largest, largestSize := "", 0
+	for key, values := range h {
+		n := 0
+		for _, value := range values {
+			n += len(value)
+		}
+		if n > largestSize {
+			largest, largestSize = key, n
+		}
+	}
+	log.Printf("[%v] Response headers for %s %s are %d bytes, over the %d byte limit (largest: %s, %d bytes)",
+		requestInfo(hw.r.Context()).ID, hw.r.Method, hw.r.URL.Path, size, hw.limit, largest, largestSize)
+
+	hw.rejected = true
+	clear(h)
+	errorHandler(hw.ResponseWriter, hw.r, http.StatusInternalServerError, "Internal server error")
+	return false
+}
+
+func (hw *headerLimitWriter) WriteHeader(status int) {
+	if status < 200 {
+		hw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if hw.check() {
+		hw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (hw *headerLimitWriter) Write(p []byte) (int, error) {
+	if !hw.check() {
+		return 0, errHeadersTooLarge
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+func (hw *headerLimitWriter) Flush() {
+	if hw.check() {
+		http.NewResponseController(hw.ResponseWriter).Flush()
+	}
+}
+
+func (hw *headerLimitWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
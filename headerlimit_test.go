@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderLimitRejectsOversizedHeaders(t *testing.T) {
+	logs := captureLog(t)
+	var writeErr error
+	h := headerLimitMiddleware(1024)(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", strings.Repeat("a", 2000))
+		w.Header().Set("X-Small", "ok")
+		_, writeErr = w.Write([]byte("body"))
+	})
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/echo", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if rec.Header().Get("X-Echo") != "" || rec.Header().Get("X-Small") != "" {
+		t.Error("the oversized response's headers were sent")
+	}
+	if strings.Contains(rec.Body.String(), "body") {
+		t.Error("the handler's body was sent after the headers were rejected")
+	}
+	if !errors.Is(writeErr, errHeadersTooLarge) {
+		t.Errorf("handler's Write returned %v, want errHeadersTooLarge", writeErr)
+	}
+	if !strings.Contains(logs.String(), "over the 1024 byte limit (largest: X-Echo, 2000 bytes)") {
+		t.Errorf("rejection not logged with the largest header:\n%s", logs)
+	}
+}
+
+func TestHeaderLimitPassesSmallHeaders(t *testing.T) {
+	for _, limit := range []int{0, 1024} {
+		h := headerLimitMiddleware(limit)(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Small", "ok")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("body"))
+		})
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusAccepted || rec.Header().Get("X-Small") != "ok" || rec.Body.String() != "body" {
+			t.Errorf("limit %d: got %d %v %q", limit, rec.Code, rec.Header(), rec.Body)
+		}
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	if got := headerSize(http.Header{"Ab": {"cd", "e"}}); got != 2+2+4+2+1+4 {
+		t.Errorf("headerSize = %d, want 15", got)
+	}
+}
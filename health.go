@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var healthChecks = &healthRegistry{checks: make(map[string]func() error)}
+
+// healthRegistry collects named checks that are reported by the health
+// endpoints. A check returns nil while its component is healthy.
+type healthRegistry struct {
+	mu     sync.Mutex
+	checks map[string]func() error
+
+	// sorted is checks ordered by name, rebuilt on every register so
+	// probes can walk it without taking mu or copying.
+	sorted atomic.Pointer[[]namedCheck]
+}
+
+type namedCheck struct {
+	name  string
+	check func() error
+}
+
+func (reg *healthRegistry) register(name string, check func() error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.checks[name] = check
+	sorted := make([]namedCheck, 0, len(reg.checks))
+	for name, check := range reg.checks {
+		sorted = append(sorted, namedCheck{name, check})
+	}
+	slices.SortFunc(sorted, func(a, b namedCheck) int { return strings.Compare(a.name, b.name) })
+	reg.sorted.Store(&sorted)
+}
+
+func (reg *healthRegistry) snapshot() []namedCheck {
+	if sorted := reg.sorted.Load(); sorted != nil {
+		return *sorted
+	}
+	return nil
+}
+
+// run executes every check and returns each one's result along with whether
+// all of them passed.
+func (reg *healthRegistry) run() (map[string]string, bool) {
+	checks := reg.snapshot()
+	results := make(map[string]string, len(checks))
+	healthy := true
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			results[c.name] = err.Error()
+			healthy = false
+			continue
+		}
+		results[c.name] = "ok"
+	}
+	return results, healthy
+}
+
+// matches executes every check and reports whether each one still gives
+// its result in results, stopping at the first that doesn't. Unlike run it
+// builds nothing, so a probe whose answer hasn't changed costs no
+// allocations.
+func (reg *healthRegistry) matches(results map[string]string) bool {
+	checks := reg.snapshot()
+	if len(checks) != len(results) {
+		return false
+	}
+	for _, c := range checks {
+		want, ok := results[c.name]
+		if !ok {
+			return false
+		}
+		if err := c.check(); err != nil {
+			if err.Error() != want {
+				return false
+			}
+		} else if want != "ok" {
+			return false
+		}
+	}
+	return true
+}
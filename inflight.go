@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var requestsInFlight = metrics.gauge("http_requests_in_flight",
+	"Requests currently being served.")
+
+var inFlight = &inFlightRequests{requests: make(map[*inFlightRequest]struct{})}
+
+type inFlightRequest struct {
+	id         uint64
+	method     string
+	path       string
+	remoteAddr string
+	started    time.Time
+}
+
+// inFlightRequests tracks the requests being served, so shutdown can tell
+// how many are left and which ones they are.
+type inFlightRequests struct {
+	mu       sync.Mutex
+	requests map[*inFlightRequest]struct{}
+}
+
+func (f *inFlightRequests) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// snapshot returns the requests in flight, oldest first.
+func (f *inFlightRequests) snapshot() []inFlightRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make([]inFlightRequest, 0, len(f.requests))
+	for req := range f.requests {
+		requests = append(requests, *req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].started.Before(requests[j].started) })
+	return requests
+}
+
+func inFlightMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &inFlightRequest{
+			id:         requestInfo(r.Context()).ID,
+			method:     r.Method,
+			path:       r.URL.Path,
+			remoteAddr: r.RemoteAddr,
+			started:    time.Now(),
+		}
+
+		inFlight.mu.Lock()
+		inFlight.requests[req] = struct{}{}
+		requestsInFlight.set(float64(len(inFlight.requests)))
+		inFlight.mu.Unlock()
+
+		defer func() {
+			inFlight.mu.Lock()
+			delete(inFlight.requests, req)
+			requestsInFlight.set(float64(len(inFlight.requests)))
+			inFlight.mu.Unlock()
+		}()
+
+		next(w, r)
+	}
+}
+
+// drainForcePollInterval is how often shutdown checks the number of
+// requests left in flight against DRAIN_FORCE_BELOW.
+const drainForcePollInterval = 100 * time.Millisecond
+
+// forceCloseBelow closes srv, cutting off whatever is still being served,
+// once fewer than below requests are in flight, typically long polls that
+// would otherwise hold shutdown up for the whole timeout. It logs each
+// request it cuts off. A below of zero or less leaves shutdown alone.
+func forceCloseBelow(ctx context.Context, stop <-chan struct{}, srv *http.Server, below int) {
+	if below <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(drainForcePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		remaining := inFlight.snapshot()
+		if len(remaining) == 0 || len(remaining) >= below {
+			continue
+		}
+
+		log.Printf("%d request(s) left in flight, below DRAIN_FORCE_BELOW=%d; force closing", len(remaining), below)
+		for _, req := range remaining {
+			log.Printf("[%d] Force closing connection %s - Method: %s | Path: %s | Running for: %v",
+				req.id, req.remoteAddr, req.method, req.path, time.Since(req.started).Round(time.Millisecond))
+		}
+		srv.Close()
+		return
+	}
+}
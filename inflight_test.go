@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainTestServer serves /fast, which answers after a short delay, and /poll,
+// which holds on until the connection goes, behind inFlightMiddleware.
+func drainTestServer(t *testing.T) (*http.Server, string, chan struct{}) {
+	t.Helper()
+	polling := make(chan struct{}, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", inFlightMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+	mux.HandleFunc("/poll", inFlightMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		polling <- struct{}{}
+		<-r.Context().Done()
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return srv, "http://" + ln.Addr().String(), polling
+}
+
+func TestForceCloseBelow(t *testing.T) {
+	logs := captureLog(t)
+	srv, url, polling := drainTestServer(t)
+
+	type result struct {
+		path string
+		err  error
+	}
+	results := make(chan result, 4)
+	get := func(path string) {
+		resp, err := http.Get(url + path)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		results <- result{path, err}
+	}
+	go get("/poll")
+	<-polling
+	for range 3 {
+		go get("/fast")
+	}
+	waitFor(t, func() bool { return inFlight.count() == 4 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stop := make(chan struct{})
+	forced := make(chan struct{})
+	go func() {
+		forceCloseBelow(ctx, stop, srv, 2)
+		close(forced)
+	}()
+
+	start := time.Now()
+	srv.Shutdown(ctx)
+	close(stop)
+	<-forced
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("shutdown took %v, want the long poll cut off once the fast requests finished", elapsed)
+	}
+
+	for range 4 {
+		r := <-results
+		switch {
+		case r.path == "/fast" && r.err != nil:
+			t.Errorf("fast request failed: %v", r.err)
+		case r.path == "/poll" && r.err == nil:
+			t.Error("long poll completed instead of being cut off")
+		}
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, "1 request(s) left in flight, below DRAIN_FORCE_BELOW=2; force closing") {
+		t.Errorf("force close not logged:\n%s", out)
+	}
+	if !strings.Contains(out, "| Path: /poll |") || strings.Contains(out, "| Path: /fast |") {
+		t.Errorf("log should name only the long poll as force closed:\n%s", out)
+	}
+}
+
+func TestForceCloseBelowDisabled(t *testing.T) {
+	captureLog(t)
+	srv, url, polling := drainTestServer(t)
+	go func() {
+		if resp, err := http.Get(url + "/poll"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-polling
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	forceCloseBelow(ctx, nil, srv, 0)
+	if err := srv.Shutdown(ctx); err == nil {
+		t.Error("shutdown finished with a long poll open and DRAIN_FORCE_BELOW unset, want it to wait out the timeout")
+	}
+}
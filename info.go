@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// version is the release the binary was built as, set with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
+// secretConfigFields are redacted in diagnostics, which only show whether
+// they are set.
+var secretConfigFields = []string{"APIToken", "AdminToken"}
+
+func buildInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"version":    version,
+		"go_version": runtime.Version(),
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info["module"] = bi.Main.Path
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info["revision"] = setting.Value
+		case "vcs.time":
+			info["revision_time"] = setting.Value
+		case "vcs.modified":
+			info["modified"] = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// effectiveConfig lists every Config field by name, durations written out
+// and secrets redacted. The boolean fields are the feature flags, returned
+// separately as well.
+func effectiveConfig(config *Config) (map[string]interface{}, map[string]bool) {
+	values := map[string]interface{}{}
+	features := map[string]bool{}
+
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, field := v.Type().Field(i).Name, v.Field(i).Interface()
+		switch value := field.(type) {
+		case string:
+			if value != "" && slices.Contains(secretConfigFields, name) {
+				field = redacted
+			}
+		case time.Duration:
+			field = value.String()
+		case bool:
+			features[name] = value
+		}
+		values[name] = field
+	}
+	return values, features
+}
+
+func runtimeStats() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"num_cpu":    runtime.NumCPU(),
+		"memory": map[string]interface{}{
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"sys_bytes":         mem.Sys,
+			"total_alloc_bytes": mem.TotalAlloc,
+		},
+		"gc": map[string]interface{}{
+			"cycles":         mem.NumGC,
+			"pause_total_ms": float64(mem.PauseTotalNs) / 1e6,
+		},
+	}
+	if mem.LastGC > 0 {
+		stats["gc"].(map[string]interface{})["last"] = formatTimestamp(time.Unix(0, int64(mem.LastGC)))
+	}
+	return stats
+}
+
+// infoHandler puts what is usually looked up first in an incident into one
+// document: what is running, how it is configured, and how it is doing.
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	config, features := effectiveConfig(requestConfig(r.Context()))
+	uptime := time.Since(serverStartTime)
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"build":   buildInfo(),
+		"config":  config,
+		"runtime": runtimeStats(),
+		"uptime": map[string]interface{}{
+			"started":   formatTimestamp(serverStartTime),
+			"uptime":    uptime.String(),
+			"uptime_ms": uptime.Milliseconds(),
+		},
+		"features": features,
+		"state": map[string]interface{}{
+			"lifecycle":       currentLifecycle().String(),
+			"paused":          acceptancePaused.Load(),
+			"disabled_routes": disabledRoutes.list(),
+			"quarantined":     len(quarantine.list()),
+			"websockets":      webSockets.count(),
+		},
+		"request_id": requestInfo(r.Context()).ID,
+		"timestamp":  formatTimestamp(time.Now()),
+	})
+}
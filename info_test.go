@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInfoEndpoint(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.DebugEndpoints = true
+	config.APIToken = "api-secret"
+	config.AdminToken = "admin-secret"
+	config.HandlerTimeout = 0
+	handler := newTestServer(t, config)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/info", nil)
+	req.Header.Set("Authorization", "Bearer api-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "api-secret") || strings.Contains(rec.Body.String(), "admin-secret") {
+		t.Errorf("a token leaked into the document: %s", rec.Body)
+	}
+
+	var doc struct {
+		Build    map[string]interface{} `json:"build"`
+		Config   map[string]interface{} `json:"config"`
+		Runtime  map[string]interface{} `json:"runtime"`
+		Uptime   map[string]interface{} `json:"uptime"`
+		Features map[string]bool        `json:"features"`
+		State    map[string]interface{} `json:"state"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Build["version"] != version || doc.Build["go_version"] != runtime.Version() {
+		t.Errorf("build = %v", doc.Build)
+	}
+	if doc.Config["APIToken"] != redacted || doc.Config["AdminToken"] != redacted {
+		t.Errorf("tokens = %v, %v; want them redacted", doc.Config["APIToken"], doc.Config["AdminToken"])
+	}
+	if doc.Config["Port"] != config.Port || doc.Config["HandlerTimeout"] != "0s" {
+		t.Errorf("config Port = %v, HandlerTimeout = %v", doc.Config["Port"], doc.Config["HandlerTimeout"])
+	}
+	for _, key := range []string{"goroutines", "gomaxprocs", "num_cpu", "memory", "gc"} {
+		if _, ok := doc.Runtime[key]; !ok {
+			t.Errorf("runtime is missing %s", key)
+		}
+	}
+	for _, key := range []string{"started", "uptime", "uptime_ms"} {
+		if _, ok := doc.Uptime[key]; !ok {
+			t.Errorf("uptime is missing %s", key)
+		}
+	}
+	if on, ok := doc.Features["DebugEndpoints"]; !ok || !on {
+		t.Errorf("features = %v, want DebugEndpoints on", doc.Features)
+	}
+	if _, ok := doc.Config["DebugEndpoints"]; !ok {
+		t.Error("flags are missing from config")
+	}
+	if doc.State["lifecycle"] != "ready" || doc.State["paused"] != false {
+		t.Errorf("state = %v", doc.State)
+	}
+}
+
+func TestInfoEndpointGated(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.APIToken = "api-secret"
+	handler := newTestServer(t, config)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/info", nil)
+	req.Header.Set("Authorization", "Bearer api-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), `"build"`) {
+		t.Errorf("served /debug/info without DEBUG_ENDPOINTS: %s", rec.Body)
+	}
+
+	config.DebugEndpoints = true
+	handler = newTestServer(t, config)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d without a token, want 401", rec.Code)
+	}
+}
+
+func TestEffectiveConfigLeavesEmptySecrets(t *testing.T) {
+	values, _ := effectiveConfig(&Config{})
+	if values["APIToken"] != "" {
+		t.Errorf("unset APIToken = %v, want it shown as empty", values["APIToken"])
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencyWindowSize is how many of a route's most recent request
+	// durations are kept.
+	latencyWindowSize = 512
+
+	// adaptiveTimeoutMinSamples is how many durations a route needs before
+	// its p99 is trusted to set a deadline.
+	adaptiveTimeoutMinSamples = 50
+
+	// latencyRecomputeEvery is how many durations a route records between
+	// updates of its cached p99, once it has adaptiveTimeoutMinSamples.
+	latencyRecomputeEvery = 32
+)
+
+// routeLatencies holds the recent request durations the logging middleware
+// records, by route pattern.
+var routeLatencies = &latencyWindows{}
+
+type latencyWindows struct {
+	windows sync.Map // route pattern -> *latencyWindow
+}
+
+// latencyWindow is a ring of the last latencyWindowSize durations. Its p99
+// is kept up to date as durations are recorded, so the requests that need
+// it read it without taking the lock or sorting the window.
+type latencyWindow struct {
+	mu       sync.Mutex
+	samples  [latencyWindowSize]time.Duration
+	next     int
+	n        int
+	recorded int
+
+	p99     atomic.Int64
+	p99Over atomic.Int64
+}
+
+func (l *latencyWindows) record(route string, d time.Duration) {
+	if route == "" {
+		return
+	}
+
+	v, ok := l.windows.Load(route)
+	if !ok {
+		v, _ = l.windows.LoadOrStore(route, &latencyWindow{})
+	}
+	w := v.(*latencyWindow)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+	w.n = min(w.n+1, latencyWindowSize)
+	w.recorded++
+
+	// A short window is cheap to sort on every duration; a full one only
+	// lets the p99 lag by latencyRecomputeEvery durations.
+	if w.n <= adaptiveTimeoutMinSamples || w.recorded%latencyRecomputeEvery == 0 {
+		w.p99.Store(int64(percentileOf(slices.Clone(w.samples[:w.n]), 0.99)))
+		w.p99Over.Store(int64(w.n))
+	}
+}
+
+// p99 returns route's cached p99 and how many durations it was taken over.
+func (l *latencyWindows) p99(route string) (time.Duration, int) {
+	v, ok := l.windows.Load(route)
+	if !ok {
+		return 0, 0
+	}
+	w := v.(*latencyWindow)
+	return time.Duration(w.p99.Load()), int(w.p99Over.Load())
+}
+
+// percentileOf sorts samples, which must not be empty, and returns their
+// p-th percentile.
+func percentileOf(samples []time.Duration, p float64) time.Duration {
+	slices.Sort(samples)
+	i := int(float64(len(samples))*p+0.5) - 1
+	return samples[max(0, min(i, len(samples)-1))]
+}
+
+// adaptiveTimeout sets a route's handler deadline to a multiple of its
+// recent p99 latency, clamped to [min, max], so normal variance is
+// tolerated while a genuine hang is still cut off. Until a route has
+// adaptiveTimeoutMinSamples durations it gets fallback.
+type adaptiveTimeout struct {
+	multiplier float64
+	min        time.Duration
+	max        time.Duration
+	fallback   time.Duration
+}
+
+// newAdaptiveTimeout returns nil, leaving timeouts static, unless
+// ADAPTIVE_TIMEOUT_MULTIPLIER is set.
+func newAdaptiveTimeout(config *Config) *adaptiveTimeout {
+	if config.AdaptiveTimeoutMultiplier <= 0 {
+		return nil
+	}
+
+	a := &adaptiveTimeout{
+		multiplier: config.AdaptiveTimeoutMultiplier,
+		min:        config.AdaptiveTimeoutMin,
+		max:        config.AdaptiveTimeoutMax,
+		fallback:   config.HandlerTimeout,
+	}
+	if a.max < a.min {
+		log.Printf("ADAPTIVE_TIMEOUT_MAX %v is below ADAPTIVE_TIMEOUT_MIN %v, using %v for both", a.max, a.min, a.min)
+		a.max = a.min
+	}
+	if a.fallback <= 0 {
+		a.fallback = a.max
+	}
+	return a
+}
+
+func (a *adaptiveTimeout) deadline(route string) time.Duration {
+	p99, n := routeLatencies.p99(route)
+	if n < adaptiveTimeoutMinSamples {
+		return a.fallback
+	}
+	return max(a.min, min(time.Duration(float64(p99)*a.multiplier), a.max))
+}
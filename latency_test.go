@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freshLatencies swaps in an empty routeLatencies for the test.
+func freshLatencies(t *testing.T) {
+	t.Helper()
+	saved := routeLatencies
+	routeLatencies = &latencyWindows{}
+	t.Cleanup(func() { routeLatencies = saved })
+}
+
+func TestLatencyP99(t *testing.T) {
+	freshLatencies(t)
+	if d, n := routeLatencies.p99("/none"); d != 0 || n != 0 {
+		t.Errorf("p99 of an unseen route = %v over %d, want 0 over 0", d, n)
+	}
+
+	for i := 1; i <= adaptiveTimeoutMinSamples; i++ {
+		routeLatencies.record("/", time.Duration(i)*time.Millisecond)
+	}
+	routeLatencies.record("", time.Hour)
+	if d, n := routeLatencies.p99("/"); d != 50*time.Millisecond || n != adaptiveTimeoutMinSamples {
+		t.Errorf("p99 = %v over %d, want 50ms over %d", d, n, adaptiveTimeoutMinSamples)
+	}
+
+	// Past adaptiveTimeoutMinSamples the cached p99 is only brought up to
+	// date every latencyRecomputeEvery durations.
+	for range latencyWindowSize {
+		routeLatencies.record("/", time.Second)
+	}
+	if d, n := routeLatencies.p99("/"); d != time.Second || n != latencyWindowSize {
+		t.Errorf("p99 = %v over %d after the window turned over, want 1s over %d", d, n, latencyWindowSize)
+	}
+	for i := range latencyRecomputeEvery {
+		if d, _ := routeLatencies.p99("/"); d != time.Second {
+			t.Fatalf("p99 = %v after %d fast durations, want the cached 1s", d, i)
+		}
+		routeLatencies.record("/", time.Millisecond)
+	}
+	for range latencyWindowSize {
+		routeLatencies.record("/", time.Millisecond)
+	}
+	if d, _ := routeLatencies.p99("/"); d != time.Millisecond {
+		t.Errorf("p99 = %v once the window is all 1ms, want 1ms", d)
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{{0.99, 99 * time.Millisecond}, {0.5, 50 * time.Millisecond}, {0.001, time.Millisecond}, {1, 100 * time.Millisecond}} {
+		if got := percentileOf(slices.Clone(samples), tt.p); got != tt.want {
+			t.Errorf("percentileOf(1..100ms, %v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkAdaptiveDeadline(b *testing.B) {
+	saved := routeLatencies
+	routeLatencies = &latencyWindows{}
+	b.Cleanup(func() { routeLatencies = saved })
+	a := &adaptiveTimeout{multiplier: 3, min: time.Millisecond, max: time.Second, fallback: time.Second}
+	for i := range latencyWindowSize {
+		routeLatencies.record("/", time.Duration(i)*time.Microsecond)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.deadline("/")
+			routeLatencies.record("/", 100*time.Microsecond)
+		}
+	})
+}
+
+func TestNewAdaptiveTimeout(t *testing.T) {
+	logs := captureLog(t)
+	config := loadConfig()
+	if a := newAdaptiveTimeout(config); a != nil {
+		t.Errorf("adaptive timeout = %+v by default, want static timeouts", a)
+	}
+
+	config.AdaptiveTimeoutMultiplier = 3
+	config.AdaptiveTimeoutMin = 2 * time.Second
+	config.AdaptiveTimeoutMax = time.Second
+	a := newAdaptiveTimeout(config)
+	if a.min != 2*time.Second || a.max != 2*time.Second || a.fallback != 2*time.Second {
+		t.Errorf("min, max, fallback = %v, %v, %v, want 2s for all", a.min, a.max, a.fallback)
+	}
+	if !strings.Contains(logs.String(), "ADAPTIVE_TIMEOUT_MAX 1s is below ADAPTIVE_TIMEOUT_MIN 2s") {
+		t.Errorf("inverted clamp not logged in:\n%s", logs)
+	}
+
+	config.HandlerTimeout = 5 * time.Second
+	config.AdaptiveTimeoutMax = 10 * time.Second
+	if a := newAdaptiveTimeout(config); a.fallback != 5*time.Second {
+		t.Errorf("fallback = %v, want HANDLER_TIMEOUT's 5s", a.fallback)
+	}
+}
+
+func TestAdaptiveTimeoutGrowsWithLatency(t *testing.T) {
+	freshLatencies(t)
+	a := &adaptiveTimeout{multiplier: 4, min: 100 * time.Millisecond, max: 2 * time.Second, fallback: time.Second}
+
+	for range adaptiveTimeoutMinSamples - 1 {
+		routeLatencies.record("/", 10*time.Millisecond)
+	}
+	if d := a.deadline("/"); d != time.Second {
+		t.Errorf("deadline = %v before %d samples, want the 1s fallback", d, adaptiveTimeoutMinSamples)
+	}
+
+	// 4 × 10ms is below the clamp.
+	routeLatencies.record("/", 10*time.Millisecond)
+	if d := a.deadline("/"); d != 100*time.Millisecond {
+		t.Errorf("deadline = %v for a 10ms p99, want the 100ms minimum", d)
+	}
+
+	prev := a.deadline("/")
+	for _, latency := range []time.Duration{50 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 2 * time.Second} {
+		for range latencyWindowSize {
+			routeLatencies.record("/", latency)
+		}
+		d := a.deadline("/")
+		if want := min(4*latency, a.max); d != want {
+			t.Errorf("deadline = %v for a %v p99, want %v", d, latency, want)
+		}
+		if d < prev {
+			t.Errorf("deadline shrank from %v to %v as latency rose", prev, d)
+		}
+		prev = d
+	}
+}
+
+func TestAdaptiveTimeoutHeader(t *testing.T) {
+	captureLog(t)
+	freshLatencies(t)
+	config := loadConfig()
+	config.AdaptiveTimeoutMultiplier = 3
+	config.AdaptiveTimeoutMin = 100 * time.Millisecond
+	config.AdaptiveTimeoutMax = 2 * time.Second
+	config.RouteTimeouts = []string{"/livez=1500ms"}
+	handler := newTestServer(t, config)
+
+	timeout := func(path string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Header().Get("X-Timeout")
+	}
+
+	if got := timeout("/health"); got != "2" {
+		t.Errorf("X-Timeout = %q before any samples, want the max, 2", got)
+	}
+	// The logging middleware feeds the route's window; fast probes settle
+	// on the minimum.
+	for range adaptiveTimeoutMinSamples {
+		timeout("/health")
+	}
+	if got := timeout("/health"); got != "0.1" {
+		t.Errorf("X-Timeout = %q for a fast route, want the minimum, 0.1", got)
+	}
+
+	for range latencyWindowSize {
+		routeLatencies.record("/health", 250*time.Millisecond)
+	}
+	if got := timeout("/health"); got != "0.75" {
+		t.Errorf("X-Timeout = %q for a 250ms p99, want 0.75", got)
+	}
+	if got := timeout("/livez"); got != "1.5" {
+		t.Errorf("X-Timeout = %q for a ROUTE_TIMEOUTS route, want its static 1.5", got)
+	}
+}
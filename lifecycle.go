@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// lifecycleState tracks where the server is between binding its listener
+// and exiting. Requests can arrive as soon as the listener is bound, well
+// before the server is ready for them.
+type lifecycleState int32
+
+const (
+	stateStarting lifecycleState = iota
+	stateReady
+	stateDraining
+)
+
+func (s lifecycleState) String() string {
+	switch s {
+	case stateReady:
+		return "ready"
+	case stateDraining:
+		return "draining"
+	}
+	return "starting"
+}
+
+var lifecycle atomic.Int32
+
+func currentLifecycle() lifecycleState {
+	return lifecycleState(lifecycle.Load())
+}
+
+func setLifecycle(s lifecycleState) {
+	lifecycle.Store(int32(s))
+	if s == stateReady {
+		rateLimits.startRamp()
+	}
+}
+
+// warmupPollInterval is how often warm-up re-runs the health checks while
+// waiting for them to pass.
+const warmupPollInterval = 100 * time.Millisecond
+
+// warmUp blocks until every registered health check passes, so the server
+// only reports ready once its dependencies are. It gives up early if stop
+// is closed.
+func warmUp(stop <-chan struct{}) bool {
+	ticker := time.NewTicker(warmupPollInterval)
+	defer ticker.Stop()
+
+	logged := false
+	for {
+		checks, healthy := healthChecks.run()
+		if healthy {
+			return true
+		}
+		if !logged {
+			log.Printf("Waiting for health checks before accepting traffic: %v", checks)
+			logged = true
+		}
+
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// startupMiddleware turns requests away with a 503 until warm-up is done.
+func startupMiddleware(retryAfter time.Duration) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if currentLifecycle() == stateStarting {
+				overloadHandler(w, r, http.StatusServiceUnavailable, retryAfter, "Server is starting up")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// readyOnlyMiddleware extends startupMiddleware to draining as well, for a
+// route a load balancer uses as its health check: the route answers 503
+// whenever /readyz would, and the node drops out of rotation as soon as
+// shutdown begins.
+func readyOnlyMiddleware(retryAfter time.Duration) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if state := currentLifecycle(); state != stateReady {
+				overloadHandler(w, r, http.StatusServiceUnavailable, retryAfter, "Server is "+state.String())
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// livezHandler answers as long as the process is serving requests at all.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":     "alive",
+		"timestamp":  formatTimestamp(time.Now()),
+		"request_id": requestInfo(r.Context()).ID,
+	})
+}
+
+// readyzHandler answers 200 only between warm-up and the start of shutdown,
+// so load balancers stop sending traffic as soon as draining begins.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	state := currentLifecycle()
+	code := http.StatusOK
+	if state != stateReady {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, r, code, map[string]interface{}{
+		"status":     state.String(),
+		"timestamp":  formatTimestamp(time.Now()),
+		"request_id": requestInfo(r.Context()).ID,
+	})
+}
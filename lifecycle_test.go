@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var (
+	serverBinaryOnce sync.Once
+	serverBinary     string
+	serverBinaryErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if serverBinary != "" {
+		os.RemoveAll(filepath.Dir(serverBinary))
+	}
+	os.Exit(code)
+}
+
+// buildServer compiles the server once per test run.
+func buildServer(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs the server binary")
+	}
+	serverBinaryOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "portserver-test")
+		if err != nil {
+			serverBinaryErr = err
+			return
+		}
+		serverBinary = filepath.Join(dir, "portServerT")
+		out, err := exec.Command("go", "build", "-o", serverBinary, ".").CombinedOutput()
+		if err != nil {
+			serverBinaryErr = errors.New(string(out))
+		}
+	})
+	if serverBinaryErr != nil {
+		t.Fatalf("building the server: %v", serverBinaryErr)
+	}
+	return serverBinary
+}
+
+// serverProcess is the server binary running in a subprocess.
+type serverProcess struct {
+	t         *testing.T
+	cmd       *exec.Cmd
+	url       string
+	readyFile string
+	logs      *logBuffer
+	client    *http.Client
+	exited    chan struct{}
+	err       error
+}
+
+// startServer runs the binary with env on a free port and waits for it to
+// write its ready file. Only PATH and HOME are inherited from the test.
+func startServer(t *testing.T, env ...string) *serverProcess {
+	t.Helper()
+	bin := buildServer(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	p := &serverProcess{
+		t:         t,
+		url:       "http://127.0.0.1:" + port,
+		readyFile: filepath.Join(t.TempDir(), "ready"),
+		logs:      &logBuffer{},
+		// No keep-alives: an idle pooled connection would hold up the
+		// drain until the server's idle timeout closes it.
+		client: &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+		exited: make(chan struct{}),
+	}
+	p.cmd = exec.Command(bin)
+	p.cmd.Env = append([]string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME"),
+		"PORT=" + port, "READY_FILE=" + p.readyFile}, env...)
+	p.cmd.Stdout, p.cmd.Stderr = p.logs, p.logs
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.exited)
+	}()
+	t.Cleanup(func() {
+		p.cmd.Process.Kill()
+		<-p.exited
+		if t.Failed() {
+			t.Logf("server output:\n%s", p.logs)
+		}
+	})
+
+	deadline := time.After(10 * time.Second)
+	for {
+		if _, err := os.Stat(p.readyFile); err == nil {
+			return p
+		}
+		select {
+		case <-p.exited:
+			t.Fatalf("server exited before it was ready: %v\n%s", p.err, p.logs)
+		case <-deadline:
+			t.Fatalf("server not ready after 10s:\n%s", p.logs)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func (p *serverProcess) get(path string, header ...string) *http.Response {
+	p.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, p.url+path, nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp
+}
+
+// signal sends sig and returns the exit code once the process is gone.
+func (p *serverProcess) signal(sig os.Signal, timeout time.Duration) int {
+	p.t.Helper()
+	if err := p.cmd.Process.Signal(sig); err != nil {
+		p.t.Fatal(err)
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(timeout):
+		p.t.Fatalf("server still running %v after %v", timeout, sig)
+	}
+	return p.cmd.ProcessState.ExitCode()
+}
+
+func TestServerLifecycle(t *testing.T) {
+	p := startServer(t, "API_TOKEN=secret", "DEBUG_ENDPOINTS=true")
+
+	for _, path := range []string{"/", "/health", "/readyz"} {
+		resp := p.get(path)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+	if ready, err := os.ReadFile(p.readyFile); err != nil || len(bytes.TrimSpace(ready)) == 0 {
+		t.Errorf("ready file = %q, %v; want a timestamp", ready, err)
+	}
+
+	// A stream open when shutdown begins is told to end, instead of
+	// holding the drain up until the shutdown timeout.
+	stream := p.get("/debug/events", "Authorization", "Bearer secret")
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("GET /debug/events = %d", stream.StatusCode)
+	}
+	events := make(chan string, 16)
+	go func() {
+		sc := bufio.NewScanner(stream.Body)
+		for sc.Scan() {
+			events <- sc.Text()
+		}
+		close(events)
+	}()
+
+	start := time.Now()
+	if code := p.signal(syscall.SIGTERM, 10*time.Second); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("drain took %v with a stream open", elapsed)
+	}
+
+	var got []string
+	for line := range events {
+		got = append(got, line)
+	}
+	if !strings.Contains(strings.Join(got, "\n"), "event: shutdown") {
+		t.Errorf("open stream got %q, want a shutdown event", got)
+	}
+	if _, err := os.Stat(p.readyFile); !os.IsNotExist(err) {
+		t.Errorf("ready file still present after drain: %v", err)
+	}
+	logs := p.logs.String()
+	for _, want := range []string{"Server is ready", "Received shutdown signal: terminated", "Server stopped successfully"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("server output is missing %q", want)
+		}
+	}
+}
+
+func TestServerRejectsInvalidConfig(t *testing.T) {
+	bin := buildServer(t)
+	cmd := exec.Command(bin)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "PORT=0", "PROXY_FALLBACK_URL=ftp://example.com"}
+	out, err := cmd.CombinedOutput()
+	if cmd.ProcessState == nil || cmd.ProcessState.ExitCode() == 0 {
+		t.Fatalf("server started with an invalid PROXY_FALLBACK_URL: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Invalid PROXY_FALLBACK_URL") {
+		t.Errorf("no reason given for refusing to start:\n%s", out)
+	}
+}
+
+func TestRequestsDuringStartup(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.StartupRetryAfter = 3 * time.Second
+	handler := newTestServer(t, config)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	setLifecycle(stateStarting)
+	rec := serve("/")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" {
+		t.Errorf("GET / while starting = %d with Retry-After %q, want 503 and 3", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("/livez"); rec.Code != http.StatusOK {
+		t.Errorf("GET /livez while starting = %d, want 200", rec.Code)
+	}
+	rec = serve("/readyz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"status":"starting"`) {
+		t.Errorf("GET /readyz while starting = %d %s, want its own 503", rec.Code, rec.Body)
+	}
+
+	setLifecycle(stateReady)
+	for _, path := range []string{"/", "/livez", "/readyz"} {
+		if rec := serve(path); rec.Code != http.StatusOK {
+			t.Errorf("GET %s once ready = %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestRootHealthcheckFollowsReadiness(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.RootIsHealthcheck = true
+	handler := newTestServer(t, config)
+
+	for _, tt := range []struct {
+		state lifecycleState
+		want  int
+	}{{stateStarting, 503}, {stateReady, 200}, {stateDraining, 503}} {
+		setLifecycle(tt.state)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET / while %s = %d, want %d", tt.state, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRootHealthcheckDuringDrain(t *testing.T) {
+	captureLog(t)
+	serve := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	config := loadConfig()
+	config.RootIsHealthcheck = true
+	config.StartupRetryAfter = 2 * time.Second
+	handler := newTestServer(t, config)
+	setLifecycle(stateDraining)
+
+	rec := serve(handler, "/")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("GET / while draining = %d with Retry-After %q, want 503 and 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if body := rec.Body.String(); strings.Contains(body, "working fine") || !strings.Contains(body, "Server is draining") {
+		t.Errorf("GET / while draining body = %s, want the draining error", body)
+	}
+	if rec := serve(handler, "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("GET /livez while draining = %d, want 200", rec.Code)
+	}
+
+	// Without the option / keeps answering until the listener closes.
+	handler = newTestServer(t, loadConfig())
+	setLifecycle(stateDraining)
+	if rec := serve(handler, "/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "working fine") {
+		t.Errorf("GET / while draining without ROOT_IS_HEALTHCHECK = %d %s, want the usual response", rec.Code, rec.Body)
+	}
+}
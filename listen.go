@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// listen opens the server's TCP listener. net.ListenConfig's Control hook
+// runs before listen(2), so it can't choose the backlog; instead the backlog
+// is applied afterwards by calling listen(2) again on the bound socket,
+// which the platforms that support it treat as an update.
+func listen(config *Config) (net.Listener, error) {
+	ln, err := bind(config.Port, config.BindRetries, config.BindRetryDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ListenBacklog > 0 {
+		if err := setListenBacklog(ln, config.ListenBacklog); err != nil {
+			log.Printf("Could not set listen backlog to %d: %v", config.ListenBacklog, err)
+		} else {
+			log.Printf("Listen backlog set to %d", config.ListenBacklog)
+		}
+	}
+	if config.MaxPipelinedRequests > 0 {
+		ln = readTimingListener{ln}
+	}
+	if config.RejectAmbiguousLength && !config.TLSEnabled() {
+		ln = framingListener{ln}
+	}
+	return ln, nil
+}
+
+// bind listens on port, retrying up to retries times while the address is
+// in use, as it can be for a moment when a restarted server's predecessor
+// still holds it. The delay doubles after every attempt.
+func bind(port string, retries int, delay time.Duration) (net.Listener, error) {
+	for attempt := 0; ; attempt++ {
+		ln, err := net.Listen("tcp", ":"+port)
+		if err == nil {
+			if attempt > 0 {
+				log.Printf("Bound port %s after %d retries", port, attempt)
+			}
+			return ln, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("port %s is in use: %w", port, err)
+		}
+
+		log.Printf("Port %s is in use, retrying in %v (attempt %d of %d)", port, delay, attempt+1, retries)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// holdPort binds a free port the way bind does and returns it with its
+// listener.
+func holdPort(t *testing.T) (string, net.Listener) {
+	t.Helper()
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port, ln
+}
+
+func TestBindRetriesUntilPortIsReleased(t *testing.T) {
+	logs := captureLog(t)
+	port, held := holdPort(t)
+	time.AfterFunc(150*time.Millisecond, func() { held.Close() })
+
+	ln, err := bind(port, 5, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	ln.Close()
+
+	out := logs.String()
+	if !strings.Contains(out, "Port "+port+" is in use, retrying in 50ms (attempt 1 of 5)") ||
+		!strings.Contains(out, "retrying in 100ms (attempt 2 of 5)") {
+		t.Errorf("retries with a doubling delay not logged in:\n%s", out)
+	}
+	if !strings.Contains(out, "Bound port "+port+" after ") {
+		t.Errorf("eventual bind not logged in:\n%s", out)
+	}
+}
+
+func TestBindGivesUpWhenRetriesRunOut(t *testing.T) {
+	logs := captureLog(t)
+	port, _ := holdPort(t)
+
+	_, err := bind(port, 2, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "port "+port+" is in use") || !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("bind = %v, want a port in use error", err)
+	}
+	if n := strings.Count(logs.String(), "is in use, retrying"); n != 2 {
+		t.Errorf("%d retries logged, want 2", n)
+	}
+}
+
+func TestBindWithoutRetries(t *testing.T) {
+	logs := captureLog(t)
+	port, _ := holdPort(t)
+
+	if _, err := bind(port, 0, time.Hour); err == nil || !strings.Contains(err.Error(), "is in use") {
+		t.Fatalf("bind = %v, want a port in use error at once", err)
+	}
+	if strings.Contains(logs.String(), "retrying") {
+		t.Errorf("retried with BIND_RETRIES=0:\n%s", logs)
+	}
+
+	if _, err := bind("not-a-port", 3, time.Hour); err == nil || strings.Contains(err.Error(), "is in use") {
+		t.Errorf("bind on an invalid port = %v, want its own error without retrying", err)
+	}
+}
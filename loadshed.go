@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	loadShedFraction = metrics.gauge("load_shed_fraction",
+		"Fraction of application requests currently being shed.")
+	loadShedRequests = metrics.counter("load_shed_requests_total",
+		"Application requests rejected by adaptive load shedding, by priority.", "priority")
+)
+
+const (
+	// loadShedMinRequests keeps a handful of requests in a quiet window from
+	// looking like a high error rate.
+	loadShedMinRequests = 20
+	loadShedRetryAfter  = time.Second
+)
+
+// loadShedder tracks the 5xx rate over a rolling window of one-second
+// buckets. While that rate is above the threshold it sheds a share of
+// requests that grows with how far over the threshold it is, up to
+// maxFraction, taking pressure off whatever is failing. Shed requests never
+// reach the handler, so they don't count towards the error rate and
+// shedding relaxes on its own once the errors stop.
+type loadShedder struct {
+	threshold   float64
+	maxFraction float64
+
+	mu      sync.Mutex
+	buckets []shedBucket
+	now     func() time.Time
+}
+
+type shedBucket struct {
+	second int64
+	total  int
+	errors int
+}
+
+// newLoadShedder returns nil, leaving shedding off, when threshold is not
+// between 0 and 1.
+func newLoadShedder(threshold float64, window time.Duration, maxFraction float64) *loadShedder {
+	if threshold <= 0 || threshold >= 1 {
+		return nil
+	}
+
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	loadShedFraction.set(0)
+	return &loadShedder{
+		threshold:   threshold,
+		maxFraction: min(max(maxFraction, 0), 1),
+		buckets:     make([]shedBucket, seconds),
+		now:         time.Now,
+	}
+}
+
+func (s *loadShedder) bucket(second int64) *shedBucket {
+	b := &s.buckets[second%int64(len(s.buckets))]
+	if b.second != second {
+		*b = shedBucket{second: second}
+	}
+	return b
+}
+
+func (s *loadShedder) record(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(s.now().Unix())
+	b.total++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+// fraction returns the share of requests to shed right now.
+func (s *loadShedder) fraction() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := s.now().Unix() - int64(len(s.buckets)) + 1
+	total, errors := 0, 0
+	for _, b := range s.buckets {
+		if b.second >= oldest {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	if total < loadShedMinRequests {
+		return 0
+	}
+
+	rate := float64(errors) / float64(total)
+	if rate <= s.threshold {
+		return 0
+	}
+	return min(s.maxFraction, (rate-s.threshold)/(1-s.threshold))
+}
+
+// loadShedMiddleware sheds requests at a rate scaled to their priority, see
+// requestPriority.shedFraction.
+func loadShedMiddleware(s *loadShedder, header string, route requestPriority) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if s == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			fraction := s.fraction()
+			loadShedFraction.set(fraction)
+			priority := priorityOf(r, header, route)
+			if shed := priority.shedFraction(fraction); shed > 0 && rand.Float64() < shed {
+				loadShedRequests.inc(priority.String())
+				overloadHandler(w, r, http.StatusServiceUnavailable, loadShedRetryAfter, "Server is shedding load")
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			next(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			s.record(sw.status)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for windowed counters.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestLoadShedderFraction(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	s := newLoadShedder(0.2, 10*time.Second, 0.5)
+	s.now = clock.now
+
+	for range loadShedMinRequests - 1 {
+		s.record(http.StatusInternalServerError)
+	}
+	if got := s.fraction(); got != 0 {
+		t.Errorf("fraction = %v below the minimum request count, want 0", got)
+	}
+
+	// 10 errors in 40 requests is a 25% error rate: 0.05 over a 0.2
+	// threshold, out of 0.8 of headroom.
+	s = newLoadShedder(0.2, 10*time.Second, 0.5)
+	s.now = clock.now
+	for i := range 40 {
+		status := http.StatusOK
+		if i%4 == 0 {
+			status = http.StatusBadGateway
+		}
+		s.record(status)
+		clock.advance(100 * time.Millisecond)
+	}
+	if got, want := s.fraction(), 0.05/0.8; math.Abs(got-want) > 1e-9 {
+		t.Errorf("fraction = %v, want %v", got, want)
+	}
+
+	for range 100 {
+		s.record(http.StatusInternalServerError)
+	}
+	if got := s.fraction(); got != 0.5 {
+		t.Errorf("fraction = %v, want it capped at LOAD_SHED_MAX_FRACTION 0.5", got)
+	}
+
+	clock.advance(10 * time.Second)
+	if got := s.fraction(); got != 0 {
+		t.Errorf("fraction = %v once the window has passed, want 0", got)
+	}
+}
+
+func TestNewLoadShedderOff(t *testing.T) {
+	for _, threshold := range []float64{0, -1, 1, 2} {
+		if s := newLoadShedder(threshold, time.Minute, 0.9); s != nil {
+			t.Errorf("threshold %v enabled shedding", threshold)
+		}
+	}
+}
+
+func TestLoadShedMiddlewareEngagesAndRelaxes(t *testing.T) {
+	captureLog(t)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	s := newLoadShedder(0.1, 5*time.Second, 0.9)
+	s.now = clock.now
+
+	failing := true
+	h := loadShedMiddleware(s, "", priorityNormal)(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	serve := func(n int) (shed int) {
+		for range n {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code == http.StatusServiceUnavailable {
+				shed++
+			}
+		}
+		return shed
+	}
+
+	if shed := serve(loadShedMinRequests); shed != 0 {
+		t.Fatalf("%d requests shed before any error was seen", shed)
+	}
+	if shed := serve(1000); shed < 800 || shed > 980 {
+		t.Errorf("%d of 1000 requests shed at a 100%% error rate, want about 900", shed)
+	}
+	if !strings.Contains(metricsOutput(t), "load_shed_fraction 0.9\n") {
+		t.Error("load_shed_fraction doesn't report 0.9")
+	}
+
+	failing = false
+	clock.advance(5 * time.Second)
+	if shed := serve(200); shed != 0 {
+		t.Errorf("%d requests shed after the errors stopped", shed)
+	}
+	if !strings.Contains(metricsOutput(t), "load_shed_fraction 0\n") {
+		t.Error("load_shed_fraction didn't drop back to 0")
+	}
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync/atomic"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -16,6 +18,11 @@ import (
 var (
 	requestIDCounter uint64
 	serverStartTime  = time.Now()
+	
+	requestDuration = metrics.histogram("http_request_duration_seconds",
+		"Time taken to serve HTTP requests.", defaultDurationBuckets, "method", "route")
+	requestsTotal = metrics.counter("http_requests_total",
+		"HTTP requests served, by status code.", "method", "route", "code")
 )
 
 type Config struct {
@@ -24,6 +31,174 @@ type Config struct {
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
 	ShutdownTimeout time.Duration
+
+	// StreamIdleTimeout bounds how long a streaming response may go without
+	// a successful write. Zero leaves streams without a deadline.
+	StreamIdleTimeout time.Duration
+
+	// TaskDrainTimeout is how long shutdown waits for a scheduled task
+	// that is partway through a run before cancelling it.
+	TaskDrainTimeout time.Duration
+
+	// DrainForceBelow ends shutdown early, force-closing what is left, once
+	// fewer than this many requests are still in flight.
+	DrainForceBelow int
+
+	// HandlerTimeout is the deadline given to each handler, advertised in
+	// X-Timeout. RouteTimeouts overrides it per route pattern.
+	HandlerTimeout time.Duration
+	RouteTimeouts  []string
+
+	// AdaptiveTimeoutMultiplier, when set, makes each route's handler
+	// deadline this multiple of its recent p99 latency, clamped to
+	// AdaptiveTimeoutMin and AdaptiveTimeoutMax.
+	AdaptiveTimeoutMultiplier float64
+	AdaptiveTimeoutMin        time.Duration
+	AdaptiveTimeoutMax        time.Duration
+
+	// AllowEncodedSlashes lets "%2F" through in path segments. It is off by
+	// default because a decoded slash can route a request to a handler its
+	// raw path was never meant to reach.
+	AllowEncodedSlashes bool
+
+	// RejectAmbiguousLength turns away requests carrying both
+	// Content-Length and Transfer-Encoding, a request smuggling vector.
+	RejectAmbiguousLength bool
+
+	CompressionEnabled bool
+	CompressionMinSize int
+
+	WebSocketEcho bool
+
+	// ProxyFallbackURL, when set, is an upstream that requests matching no
+	// other route are forwarded to.
+	ProxyFallbackURL string
+
+	TLSCertFile   string
+	TLSKeyFile    string
+	LogTLSDetails bool
+
+	CORSAllowedOrigins []string
+
+	LogExtractHeaders []string
+	AccessLogBuffer   int
+
+	SlowRequestThreshold time.Duration
+	SlowRequestBuffer    int
+	SlowRequestLimit     int
+
+	// ErrorSampleBuffer is how many 5xx responses are kept per route for
+	// /debug/errors.
+	ErrorSampleBuffer int
+
+	// RequestStartHeader names the header a load balancer stamps with the
+	// time it received the request, used to log queue time.
+	RequestStartHeader string
+
+	StrictAccept bool
+
+	BodyDrainLimit int64
+	MaxBodyBytes   int64
+
+	TimestampPrecision  string
+	ResponseCharset     string
+	ResponseHeaderLimit int
+	EmptyResponseStatus int
+
+	ResponseCacheTTL     time.Duration
+	ResponseCacheSize    int
+	ResponseCacheExclude []string
+
+	RateLimitRPS      float64
+	RateLimitBurst    int
+	RateLimitTenants  []string
+	TenantHeader      string
+	TrustedProxies    []string
+	SlowStartDuration time.Duration
+
+	QuarantineViolations int
+	QuarantineWindow     time.Duration
+	QuarantineTTL        time.Duration
+
+	BreakerFailureThreshold int
+	BreakerResetTimeout     time.Duration
+
+	RoutePriorities []string
+	PriorityHeader  string
+
+	LoadShedThreshold   float64
+	LoadShedWindow      time.Duration
+	LoadShedMaxFraction float64
+
+	APIToken        string
+	AdminToken      string
+	PauseRetryAfter time.Duration
+
+	StartupRetryAfter time.Duration
+	RootIsHealthcheck bool
+
+	DisabledRoutes      []string
+	DisabledRouteStatus int
+
+	ExperimentVariants []string
+	ExperimentKey      string
+
+	HealthProbeSources []string
+	HealthDefaultDepth string
+	HealthFastPath     bool
+
+	MetricsEnabled bool
+	TracingEnabled bool
+	DebugEndpoints bool
+	Dashboard      bool
+
+	// StatsDAddr, when set, is the host:port of a StatsD agent the metrics
+	// are pushed to over UDP every StatsDFlushInterval.
+	StatsDAddr          string
+	StatsDPrefix        string
+	StatsDFlushInterval time.Duration
+
+	GoroutineSampleInterval time.Duration
+	GoroutineWarnThreshold  int
+	GoroutineGrowthWindow   time.Duration
+
+	RobotsTxt       string
+	RobotsTxtFile   string
+	SecurityTxt     string
+	SecurityTxtFile string
+
+	ListenBacklog  int
+	ReadyFile      string
+	ExitWithParent bool
+
+	// BindRetries is how many more times binding the port is tried while
+	// it is in use, BindRetryDelay apart and doubling.
+	BindRetries    int
+	BindRetryDelay time.Duration
+
+	GoroutineDumpFile string
+
+	KeepAliveHeader          bool
+	MaxRequestsPerConnection int
+	MaxPipelinedRequests     int
+
+	// RedirectCorrelation carries a redirect's request ID to the request
+	// that follows it, as a "query" parameter or a "cookie" named
+	// RedirectCorrelationName.
+	RedirectCorrelation     string
+	RedirectCorrelationName string
+
+	RedactHeaders []string
+	RedactFields  []string
+
+	CaptureFile       string
+	CaptureSampleRate float64
+	CaptureMaxBytes   int64
+	CaptureBodyTypes  []string
+
+	H2CEnabled                bool
+	HTTP2MaxConcurrentStreams int
+	HTTP2MaxReadFrameSize     int
 }
 
 func loadConfig() *Config {
@@ -36,38 +211,325 @@ func loadConfig() *Config {
 		Port:            port,
 		ReadTimeout:     15 * time.Second,
 		WriteTimeout:    15 * time.Second,
-		IdleTimeout:     60 * time.Second,
+		IdleTimeout:     getEnvDuration("IDLE_TIMEOUT", 60*time.Second),
 		ShutdownTimeout: 30 * time.Second,
+
+		StreamIdleTimeout: getEnvDuration("STREAM_IDLE_TIMEOUT", 60*time.Second),
+
+		TaskDrainTimeout: getEnvDuration("TASK_DRAIN_TIMEOUT", 10*time.Second),
+
+		DrainForceBelow: getEnvInt("DRAIN_FORCE_BELOW", 0),
+
+		HandlerTimeout: getEnvDuration("HANDLER_TIMEOUT", 0),
+		RouteTimeouts:  getEnvList("ROUTE_TIMEOUTS"),
+
+		AdaptiveTimeoutMultiplier: getEnvFloat("ADAPTIVE_TIMEOUT_MULTIPLIER", 0),
+		AdaptiveTimeoutMin:        getEnvDuration("ADAPTIVE_TIMEOUT_MIN", time.Second),
+		AdaptiveTimeoutMax:        getEnvDuration("ADAPTIVE_TIMEOUT_MAX", 15*time.Second),
+
+		AllowEncodedSlashes: getEnvBool("ALLOW_ENCODED_SLASHES", false),
+
+		RejectAmbiguousLength: getEnvBool("REJECT_AMBIGUOUS_LENGTH", true),
+
+		CompressionEnabled: getEnvBool("COMPRESSION_ENABLED", true),
+		CompressionMinSize: getEnvInt("COMPRESSION_MIN_SIZE", 1024),
+
+		WebSocketEcho: getEnvBool("WEBSOCKET_ECHO", false),
+
+		ProxyFallbackURL: os.Getenv("PROXY_FALLBACK_URL"),
+
+		TLSCertFile:   os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:    os.Getenv("TLS_KEY_FILE"),
+		LogTLSDetails: getEnvBool("LOG_TLS_DETAILS", false),
+
+		CORSAllowedOrigins: getEnvListDefault("CORS_ALLOWED_ORIGINS", []string{"*"}),
+
+		LogExtractHeaders: getEnvList("LOG_EXTRACT_HEADERS"),
+		AccessLogBuffer:   getEnvInt("ACCESS_LOG_BUFFER", 1024),
+
+		SlowRequestThreshold: getEnvDuration("SLOW_REQUEST_THRESHOLD", time.Second),
+		SlowRequestBuffer:    getEnvInt("SLOW_REQUEST_BUFFER", 100),
+		SlowRequestLimit:     getEnvInt("SLOW_REQUEST_LIMIT", 20),
+
+		ErrorSampleBuffer: getEnvInt("ERROR_SAMPLE_BUFFER", 10),
+
+		RequestStartHeader: getEnvString("REQUEST_START_HEADER", "X-Request-Start"),
+
+		StrictAccept: getEnvBool("STRICT_ACCEPT", false),
+
+		BodyDrainLimit: int64(getEnvInt("BODY_DRAIN_LIMIT", 64<<10)),
+		MaxBodyBytes:   int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+
+		TimestampPrecision:  os.Getenv("TIMESTAMP_PRECISION"),
+		ResponseCharset:     getEnvString("RESPONSE_CHARSET", "utf-8"),
+		ResponseHeaderLimit: getEnvInt("RESPONSE_HEADER_LIMIT", 32<<10),
+		EmptyResponseStatus: getEnvInt("EMPTY_RESPONSE_STATUS", http.StatusNoContent),
+
+		ResponseCacheTTL:     getEnvDuration("RESPONSE_CACHE_TTL", 0),
+		ResponseCacheSize:    getEnvInt("RESPONSE_CACHE_SIZE", 256),
+		ResponseCacheExclude: getEnvListDefault("RESPONSE_CACHE_EXCLUDE", []string{"/health", "/healthz", "/livez", "/readyz", "/metrics"}),
+
+		RateLimitRPS:      getEnvFloat("RATE_LIMIT_RPS", 0),
+		RateLimitBurst:    getEnvInt("RATE_LIMIT_BURST", 0),
+		RateLimitTenants:  getEnvList("RATE_LIMIT_TENANTS"),
+		TenantHeader:      os.Getenv("TENANT_HEADER"),
+		TrustedProxies:    getEnvList("TRUSTED_PROXIES"),
+		SlowStartDuration: getEnvDuration("SLOW_START_DURATION", 0),
+
+		QuarantineViolations: getEnvInt("QUARANTINE_VIOLATIONS", 0),
+		QuarantineWindow:     getEnvDuration("QUARANTINE_WINDOW", time.Minute),
+		QuarantineTTL:        getEnvDuration("QUARANTINE_TTL", 10*time.Minute),
+
+		BreakerFailureThreshold: getEnvInt("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5),
+		BreakerResetTimeout:     getEnvDuration("CIRCUIT_BREAKER_RESET_TIMEOUT", 30*time.Second),
+
+		RoutePriorities: getEnvList("ROUTE_PRIORITIES"),
+		PriorityHeader:  os.Getenv("PRIORITY_HEADER"),
+
+		LoadShedThreshold:   getEnvFloat("LOAD_SHED_THRESHOLD", 0),
+		LoadShedWindow:      getEnvDuration("LOAD_SHED_WINDOW", 30*time.Second),
+		LoadShedMaxFraction: getEnvFloat("LOAD_SHED_MAX_FRACTION", 0.9),
+
+		APIToken:        os.Getenv("API_TOKEN"),
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
+		PauseRetryAfter: getEnvDuration("PAUSE_RETRY_AFTER", 5*time.Second),
+
+		StartupRetryAfter: getEnvDuration("STARTUP_RETRY_AFTER", time.Second),
+		RootIsHealthcheck: getEnvBool("ROOT_IS_HEALTHCHECK", false),
+
+		DisabledRoutes:      getEnvList("DISABLED_ROUTES"),
+		DisabledRouteStatus: getEnvInt("DISABLED_ROUTE_STATUS", http.StatusNotFound),
+
+		ExperimentVariants: getEnvList("EXPERIMENT_VARIANTS"),
+		ExperimentKey:      getEnvString("EXPERIMENT_KEY", "client_ip"),
+
+		HealthProbeSources: getEnvList("HEALTH_PROBE_SOURCES"),
+		HealthDefaultDepth: getEnvString("HEALTH_DEFAULT_DEPTH", "deep"),
+		HealthFastPath:     getEnvBool("HEALTH_FAST_PATH", false),
+
+		MetricsEnabled: getEnvBool("METRICS_ENABLED", true),
+		TracingEnabled: getEnvBool("TRACING_ENABLED", false),
+		DebugEndpoints: getEnvBool("DEBUG_ENDPOINTS", false),
+		Dashboard:      getEnvBool("DASHBOARD", false),
+
+		StatsDAddr:          os.Getenv("STATSD_ADDR"),
+		StatsDPrefix:        getEnvString("STATSD_PREFIX", "portserver."),
+		StatsDFlushInterval: getEnvDuration("STATSD_FLUSH_INTERVAL", 10*time.Second),
+
+		GoroutineSampleInterval: getEnvDuration("GOROUTINE_SAMPLE_INTERVAL", 30*time.Second),
+		GoroutineWarnThreshold:  getEnvInt("GOROUTINE_WARN_THRESHOLD", 0),
+		GoroutineGrowthWindow:   getEnvDuration("GOROUTINE_GROWTH_WINDOW", 0),
+
+		RobotsTxt:       os.Getenv("ROBOTS_TXT"),
+		RobotsTxtFile:   os.Getenv("ROBOTS_TXT_FILE"),
+		SecurityTxt:     os.Getenv("SECURITY_TXT"),
+		SecurityTxtFile: os.Getenv("SECURITY_TXT_FILE"),
+
+		ListenBacklog:  getEnvInt("LISTEN_BACKLOG", 0),
+		ReadyFile:      os.Getenv("READY_FILE"),
+		ExitWithParent: getEnvBool("EXIT_WITH_PARENT", false),
+
+		BindRetries:    getEnvInt("BIND_RETRIES", 0),
+		BindRetryDelay: getEnvDuration("BIND_RETRY_DELAY", 500*time.Millisecond),
+
+		GoroutineDumpFile: os.Getenv("GOROUTINE_DUMP_FILE"),
+
+		KeepAliveHeader:          getEnvBool("KEEP_ALIVE_HEADER", false),
+		MaxRequestsPerConnection: getEnvInt("MAX_REQUESTS_PER_CONNECTION", 0),
+		MaxPipelinedRequests:     getEnvInt("MAX_PIPELINED_REQUESTS", 0),
+
+		RedirectCorrelation:     os.Getenv("REDIRECT_CORRELATION"),
+		RedirectCorrelationName: getEnvString("REDIRECT_CORRELATION_NAME", "redirected_from"),
+
+		RedactHeaders: getEnvListDefault("REDACT_HEADERS", defaultRedactHeaders),
+		RedactFields:  getEnvListDefault("REDACT_FIELDS", defaultRedactFields),
+
+		CaptureFile:       os.Getenv("CAPTURE_FILE"),
+		CaptureSampleRate: getEnvFloat("CAPTURE_SAMPLE_RATE", 0.01),
+		CaptureMaxBytes:   int64(getEnvInt("CAPTURE_MAX_BYTES", 100<<20)),
+		CaptureBodyTypes: getEnvListDefault("CAPTURE_BODY_TYPES", []string{
+			"application/json", "application/xml", "application/x-www-form-urlencoded", "text/*", "+json", "+xml",
+		}),
+
+		H2CEnabled:                getEnvBool("H2C_ENABLED", false),
+		HTTP2MaxConcurrentStreams: getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 100),
+		HTTP2MaxReadFrameSize:     getEnvInt("HTTP2_MAX_READ_FRAME_SIZE", 16<<10),
 	}
 }
 
-func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		
-		requestID := atomic.AddUint64(&requestIDCounter, 1)
-		
-		log.Printf("[%d] Incoming request - Method: %s | Path: %s | RemoteAddr: %s | User-Agent: %s",
-			requestID,
-			r.Method,
-			r.URL.Path,
-			r.RemoteAddr,
-			r.UserAgent(),
-		)
-		
-		ctx := context.WithValue(r.Context(), "requestID", requestID)
-		r = r.WithContext(ctx)
-		
-		next(w, r)
-		
-		duration := time.Since(start)
-		log.Printf("[%d] Request completed - Duration: %v", requestID, duration)
+// getEnvString returns fallback only when key is unset, so an explicitly
+// empty value can switch a default off.
+func getEnvString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default %d", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvListDefault(key string, fallback []string) []string {
+	if _, ok := os.LookupEnv(key); !ok {
+		return fallback
 	}
+	return getEnvList(key)
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func loggingMiddleware(config *Config) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			
+			info := requestInfo(r.Context())
+			
+			var clientField, traceField, variantField, redirectField string
+			if info.ClientIP != "" && info.ClientIP != clientIP(r) {
+				clientField = " | Client: " + info.ClientIP
+			}
+			if info.TraceID != "" {
+				traceField = " | TraceID: " + info.TraceID
+			}
+			if info.Variant != "" {
+				variantField = " | Variant: " + info.Variant
+			}
+			if info.RedirectedFrom != 0 {
+				redirectField = " | RedirectedFrom: " + strconv.FormatUint(info.RedirectedFrom, 10)
+			}
+			
+			accessLog.printf("[%d] Incoming request - Method: %s | Path: %s | RemoteAddr: %s%s | User-Agent: %s%s%s%s%s",
+				info.ID,
+				r.Method,
+				r.URL.Path,
+				r.RemoteAddr,
+				clientField,
+				r.UserAgent(),
+				traceField,
+				variantField,
+				redirectField,
+				extractedHeaderFields(r, config.LogExtractHeaders),
+			)
+			
+			sw := &statusWriter{ResponseWriter: w}
+			next(sw, r)
+			
+			duration := time.Since(start)
+			requestDuration.observe(duration.Seconds(), info.TraceID, r.Method, r.Pattern)
+			routeLatencies.record(r.Pattern, duration)
+			requestsTotal.inc(r.Method, r.Pattern, strconv.Itoa(sw.status))
+			if config.SlowRequestThreshold > 0 && duration >= config.SlowRequestThreshold {
+				recordSlowRequest(r, info.ID, sw.status, start, duration)
+			}
+			if info.Variant != "" {
+				experimentRequests.inc(info.Variant, strconv.Itoa(sw.status))
+			}
+			accessLog.printf("[%d] Request completed - Status: %d | Duration: %v%s",
+				info.ID,
+				sw.status,
+				duration,
+				queueTimeField(r, config.RequestStartHeader, start),
+			)
+		}
+	}
+}
+
+// maxExtractedHeaderLen caps how much of a client-supplied header value ends
+// up in a log line.
+const maxExtractedHeaderLen = 128
+
+func extractedHeaderFields(r *http.Request, names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if len(value) > maxExtractedHeaderLen {
+			value = value[:maxExtractedHeaderLen]
+		}
+		fmt.Fprintf(&b, " | %s: %q", name, value)
+	}
+	return b.String()
 }
 
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		origins := requestConfig(r.Context()).CORSAllowedOrigins
+		if slices.Contains(origins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); origin != "" && slices.Contains(origins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		
@@ -80,114 +542,493 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func encodedSlashMiddleware(allow bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !allow && strings.Contains(strings.ToUpper(r.URL.EscapedPath()), "%2F") {
+				log.Printf("[%v] Rejected encoded slash in path: %s", requestInfo(r.Context()).ID, r.URL.EscapedPath())
+				errorHandler(w, r, http.StatusBadRequest, "Encoded slashes are not allowed in the request path")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
 func mainHandler(w http.ResponseWriter, r *http.Request) {
-	requestID := r.Context().Value("requestID").(uint64)
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Request-ID", fmt.Sprintf("%d", requestID))
+	requestID := requestInfo(r.Context()).ID
+	body, err := readBody(r, requestConfig(r.Context()).MaxBodyBytes)
+	if err != nil {
+		bodyErrorHandler(w, r, err)
+		return
+	}
 	
 	response := map[string]interface{}{
 		"status":     "success",
 		"message":    "Port 10001 is working fine",
-		"timestamp":  time.Now().Format(time.RFC3339),
+		"timestamp":  formatTimestamp(time.Now()),
 		"request_id": requestID,
 		"path":       r.URL.Path,
 		"method":     r.Method,
 	}
+	if body != nil {
+		response["body_bytes"] = len(body)
+	}
 	
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	uptime := time.Since(serverStartTime)
-	
-	health := map[string]interface{}{
-		"status":     "healthy",
-		"uptime":     uptime.String(),
-		"uptime_ms":  uptime.Milliseconds(),
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"request_id": r.Context().Value("requestID"),
+// healthHandler reports liveness and, for probe sources that get a deep
+// check, the result of every registered health check.
+func healthHandler(probes *probeSources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uptime := time.Since(serverStartTime)
+		depth := probes.depthFor(r)
+		
+		var checks map[string]string
+		healthy := true
+		if depth == healthDeep {
+			checks, healthy = healthChecks.run()
+		}
+		
+		status, code := "healthy", http.StatusOK
+		if !healthy {
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+		
+		health := map[string]interface{}{
+			"status":     status,
+			"depth":      depth,
+			"uptime":     uptime.String(),
+			"uptime_ms":  uptime.Milliseconds(),
+			"timestamp":  formatTimestamp(time.Now()),
+			"request_id": requestInfo(r.Context()).ID,
+		}
+		if len(checks) > 0 {
+			health["checks"] = checks
+		}
+		
+		writeJSON(w, r, code, health)
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(health)
 }
 
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
-	requestID := r.Context().Value("requestID")
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Request-ID", fmt.Sprintf("%d", requestID))
+	requestID := requestInfo(r.Context()).ID
 	
 	response := map[string]interface{}{
 		"status":     "error",
 		"message":    "Resource not found",
 		"path":       r.URL.Path,
 		"request_id": requestID,
-		"timestamp":  time.Now().Format(time.RFC3339),
+		"timestamp":  formatTimestamp(time.Now()),
+	}
+	
+	writeJSON(w, r, http.StatusNotFound, response)
+}
+
+func errorHandler(w http.ResponseWriter, r *http.Request, status int, message string) {
+	info := requestInfo(r.Context())
+	if status >= 500 {
+		info.Error = message
 	}
+	requestID := info.ID
 	
-	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(response)
+	response := map[string]interface{}{
+		"status":     "error",
+		"message":    message,
+		"path":       r.URL.Path,
+		"request_id": requestID,
+		"timestamp":  formatTimestamp(time.Now()),
+	}
+	
+	writeJSON(w, r, status, response)
+}
+
+// overloadHandler answers requests the server is turning away (429 or 503).
+// Alongside Retry-After it tells clients when a retry can't cause duplicate
+// side effects, so they don't need an Idempotency-Key to retry safely.
+func overloadHandler(w http.ResponseWriter, r *http.Request, status int, retryAfter time.Duration, message string) {
+	seconds := int(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	
+	if isIdempotentMethod(r.Method) {
+		w.Header().Set("X-Idempotent-Retry", "safe")
+	}
+	
+	errorHandler(w, r, status, message)
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// routeMiddleware returns the per-route layers for rt, outermost first. They
+// run inside the server-wide chain, once the mux has matched the route.
+func routeMiddleware(rt route, config *Config, cache *responseCache, shedder *loadShedder, priorities map[string]requestPriority) []namedMiddleware {
+	var mws []namedMiddleware
+	if rt.timeout > 0 || rt.adaptive != nil {
+		mws = append(mws, namedMiddleware{"handlerTimeoutMiddleware", handlerTimeoutMiddleware(rt.timeout, rt.adaptive)})
+	}
+	if rt.deprecated != nil {
+		mws = append(mws, namedMiddleware{"deprecationMiddleware", deprecationMiddleware(rt.deprecated)})
+	}
+	if rt.kind != routeProbe && rt.kind != routeAdmin {
+		disabledRoutes.register(rt.pattern)
+		mws = append(mws,
+			namedMiddleware{"quarantineMiddleware", quarantineMiddleware(quarantine)},
+			namedMiddleware{"disabledRouteMiddleware", disabledRouteMiddleware(rt.pattern, config.DisabledRouteStatus)},
+		)
+	}
+	if rt.kind == routeApplication {
+		if rt.pattern == "/" && config.RootIsHealthcheck {
+			mws = append(mws, namedMiddleware{"readyOnlyMiddleware", readyOnlyMiddleware(config.StartupRetryAfter)})
+		}
+		mws = append(mws,
+			namedMiddleware{"startupMiddleware", startupMiddleware(config.StartupRetryAfter)},
+			namedMiddleware{"pauseMiddleware", pauseMiddleware(config.PauseRetryAfter)},
+			namedMiddleware{"loadShedMiddleware", loadShedMiddleware(shedder, config.PriorityHeader, priorities[rt.pattern])},
+		)
+	}
+	if rt.auth != authNone {
+		mws = append(mws, namedMiddleware{"authMiddleware", authMiddleware(rt.auth, config)})
+	}
+	if rt.streaming {
+		mws = append(mws, namedMiddleware{"streamingMiddleware", streamingMiddleware(config.StreamIdleTimeout)})
+	} else if !slices.Contains(config.ResponseCacheExclude, rt.pattern) {
+		mws = append(mws, namedMiddleware{"cacheMiddleware", cacheMiddleware(cache)})
+	}
+	if len(rt.methods) > 0 {
+		mws = append(mws, namedMiddleware{"methodMiddleware", methodMiddleware(rt.methods)})
+	}
+	return append(mws,
+		namedMiddleware{"acceptMiddleware", acceptMiddleware(config.StrictAccept, rt.mediaType)},
+		namedMiddleware{"emptyResponseMiddleware", emptyResponseMiddleware(config.EmptyResponseStatus)},
+	)
 }
 
-func setupRoutes() *http.ServeMux {
+func setupRoutes(config *Config) http.HandlerFunc {
 	mux := http.NewServeMux()
 	
-	mux.HandleFunc("/", corsMiddleware(loggingMiddleware(mainHandler)))
-	mux.HandleFunc("/health", corsMiddleware(loggingMiddleware(healthHandler)))
-	mux.HandleFunc("/healthz", corsMiddleware(loggingMiddleware(healthHandler)))
+	slowRequests = newSlowRequestLog(config.SlowRequestBuffer)
+	errorSamples = newErrorSampleLog(config.ErrorSampleBuffer, newRedactor(config.RedactHeaders, config.RedactFields))
+	chains := &routeChainTable{}
+	logging := loggingMiddleware(config)
+	pathGuard := encodedSlashMiddleware(config.AllowEncodedSlashes)
+	compress := compressionMiddleware(config)
+	
+	rateLimits = nil
+	if config.RateLimitRPS > 0 {
+		rateLimits = newRateLimiter(config.RateLimitRPS, config.RateLimitBurst, config.SlowStartDuration,
+			parseTenantRateLimits(config.RateLimitTenants))
+	}
+	
+	quarantine = newQuarantineList(config.QuarantineViolations, config.QuarantineWindow, config.QuarantineTTL)
+	scheduler.every("quarantine-purge", time.Minute, func(context.Context) error {
+		quarantine.purge()
+		return nil
+	})
+	
+	drainBody, drainBodyEarly := bodyDrainMiddleware(config.BodyDrainLimit, false), bodyDrainMiddleware(config.BodyDrainLimit, true)
+	
+	captures = nil
+	if config.CaptureFile != "" {
+		rc, err := newRequestCapture(config, newRedactor(config.RedactHeaders, config.RedactFields))
+		if err != nil {
+			log.Printf("Request capture disabled: %v", err)
+		} else {
+			captures = rc
+			log.Printf("Capturing %.2f%% of requests to %s", config.CaptureSampleRate*100, config.CaptureFile)
+		}
+	}
+	captureRequests := captureMiddleware(captures)
 	
-	return mux
+	// Order matters: the request ID is assigned and panics are recovered
+	// ahead of the mux (see the end of setupRoutes), so every layer can log
+	// the ID and none can take the connection down, and neither can
+	// redirects the mux sends itself. CORS answers preflights before auth
+	// and rate limiting can reject them, and logging captures the status
+	// after compression has had its say. Experiment assignment comes before
+	// logging so the variant makes it into the log. The unread body is
+	// drained before the headers go out only on routes whose handlers never
+	// read it.
+	proxies := parseTrustedProxies(config.TrustedProxies)
+	forwarded := forwardedMiddleware(proxies)
+	connection := connectionMiddleware(config.KeepAliveHeader, config.MaxRequestsPerConnection, config.MaxPipelinedRequests)
+	experiments := experimentMiddleware(newExperiment(config.ExperimentVariants, config.ExperimentKey))
+	headerLimit := headerLimitMiddleware(config.ResponseHeaderLimit)
+	tenants := tenantMiddleware(config.TenantHeader, proxies)
+	priorities := parseRoutePriorities(config.RoutePriorities)
+	serverChain := func(rt route) []namedMiddleware {
+		drain := drainBody
+		if ignoresBody(rt) {
+			drain = drainBodyEarly
+		}
+		mws := []namedMiddleware{
+			{"forwardedMiddleware", forwarded},
+			{"inFlightMiddleware", inFlightMiddleware},
+			{"ambiguousLengthMiddleware", ambiguousLengthMiddleware},
+			{"connectionMiddleware", connection},
+			{"bodyDrainMiddleware", drain},
+			{"corsMiddleware", corsMiddleware},
+			{"experimentMiddleware", experiments},
+			{"loggingMiddleware", logging},
+			{"headerLimitMiddleware", headerLimit},
+			{"captureMiddleware", captureRequests},
+			{"tenantMiddleware", tenants},
+		}
+		// Probes and admin endpoints must answer while clients are being
+		// throttled, or an overload would also hide the server's state.
+		if rt.kind != routeProbe && rt.kind != routeAdmin {
+			mws = append(mws, namedMiddleware{"rateLimitMiddleware", rateLimitMiddleware(rateLimits, config.PriorityHeader, priorities[rt.pattern])})
+		}
+		return append(mws,
+			namedMiddleware{"compressionMiddleware", compress},
+			namedMiddleware{"encodedSlashMiddleware", pathGuard},
+		)
+	}
+	
+	var cache *responseCache
+	if config.ResponseCacheTTL > 0 {
+		cache = newResponseCache(config.ResponseCacheTTL, config.ResponseCacheSize)
+		scheduler.every("response-cache-purge", config.ResponseCacheTTL, func(context.Context) error {
+			cache.purgeExpired()
+			return nil
+		})
+	}
+	shedder := newLoadShedder(config.LoadShedThreshold, config.LoadShedWindow, config.LoadShedMaxFraction)
+	
+	plainChain := []namedMiddleware{{"loggingMiddleware", logging}}
+	
+	routes := routeTable(config)
+	for _, rt := range routes {
+		if rt.auth == authUnset {
+			log.Fatalf("Route %s does not declare an auth level", rt.pattern)
+		}
+		
+		mws := slices.Clone(plainChain)
+		if len(rt.methods) > 0 {
+			mws = append(mws, namedMiddleware{"methodMiddleware", methodMiddleware(rt.methods)})
+		}
+		if !rt.plain {
+			mws = append(serverChain(rt), routeMiddleware(rt, config, cache, shedder, priorities)...)
+		}
+		
+		handler, names := namedChain(rt.handler, mws...)
+		chains.record(rt, names)
+		mux.HandleFunc(rt.pattern, handler)
+	}
+	applyDisabledRoutes(config.DisabledRoutes)
+	
+	server := []namedMiddleware{
+		{"requestInfoMiddleware", requestInfoMiddleware(config)},
+		{"recoveryMiddleware", recoveryMiddleware},
+		{"serverOptionsMiddleware", serverOptionsMiddleware(serverMethods(routes))},
+	}
+	if config.RedirectCorrelation != "" {
+		server = append(server, namedMiddleware{"redirectCorrelationMiddleware", redirectCorrelationMiddleware(config.RedirectCorrelation, config.RedirectCorrelationName)})
+	}
+	handler, names := namedChain(mux.ServeHTTP, server...)
+	chains.Server = names
+	routeChains = chains
+	return handler
 }
 
 func main() {
+	envFileErr := applyEnvFile(os.Getenv("ENV_FILE"))
 	config := loadConfig()
 	
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+	timestampLayout = timestampLayoutFor(config.TimestampPrecision)
+	responseCharset = config.ResponseCharset
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(timestampWriter{out: os.Stderr})
+	accessLog = newAccessLogger(config.AccessLogBuffer)
+	if envFileErr != nil {
+		log.Fatalf("Could not read ENV_FILE: %v", envFileErr)
+	}
 	
-	router := setupRoutes()
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	
-	srv := &http.Server{
-		Addr:         ":" + config.Port,
-		Handler:      router,
-		ReadTimeout:  config.ReadTimeout,
-		WriteTimeout: config.WriteTimeout,
-		IdleTimeout:  config.IdleTimeout,
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	
+	if config.GoroutineDumpFile != "" {
+		dumpGoroutinesOnSIGQUIT(config.GoroutineDumpFile)
+	}
+	
+	if config.ExitWithParent {
+		if err := exitWithParent(); err != nil {
+			log.Printf("Could not tie shutdown to the parent process: %v", err)
+		}
+	}
+	
+	err := run(config, shutdown, reload)
+	accessLog.close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped successfully")
+}
+
+// run takes the server through its whole lifecycle: route setup, bind, serve
+// (reloading config whenever reload delivers a signal) and, once shutdown
+// delivers a signal, a graceful drain. It returns nil only
+// when every connection finished within ShutdownTimeout, which makes the
+// process exit status a reliable signal for anything supervising it.
+func run(config *Config, shutdown, reload <-chan os.Signal) error {
+	currentConfig.Store(config)
+	srv := newServer(config, setupRoutes(config))
+	
+	if config.LogTLSDetails {
+		srv.ConnState = tlsConnLogger()
+	}
+	srv.RegisterOnShutdown(func() { close(stopStreams) })
+	
+	if config.TLSEnabled() {
+		if err := certificates.load(config.TLSCertFile, config.TLSKeyFile); err != nil {
+			return fmt.Errorf("Server failed to start: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certificates.getCertificate}
+	}
+	
+	ln, err := listen(config)
+	if err != nil {
+		return fmt.Errorf("Server failed to start: %w", err)
 	}
 	
 	serverErrors := make(chan error, 1)
+	watchGoroutines(config.GoroutineSampleInterval, config.GoroutineWarnThreshold, config.GoroutineGrowthWindow)
+	startStatsd(config.StatsDAddr, config.StatsDPrefix, config.StatsDFlushInterval)
+	scheduler.start()
 	
 	go func() {
-		log.Printf("Starting web server on http://localhost:%s ...", config.Port)
+		scheme := "http"
+		if config.TLSEnabled() {
+			scheme = "https"
+		}
+		log.Printf("Starting web server on %s://localhost:%s ...", scheme, config.Port)
 		log.Printf("Server configuration - ReadTimeout: %v | WriteTimeout: %v | IdleTimeout: %v",
 			config.ReadTimeout, config.WriteTimeout, config.IdleTimeout)
-		serverErrors <- srv.ListenAndServe()
+		if config.H2CEnabled {
+			log.Printf("HTTP/2 cleartext enabled - MaxConcurrentStreams: %d | MaxReadFrameSize: %d",
+				config.HTTP2MaxConcurrentStreams, config.HTTP2MaxReadFrameSize)
+		}
+		if config.TLSEnabled() {
+			serverErrors <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		serverErrors <- srv.Serve(ln)
 	}()
 	
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
-	
-	select {
-	case err := <-serverErrors:
-		if err != nil {
-			log.Fatalf("Server failed to start: %v", err)
+	// The listener is bound, so requests are already arriving; application
+	// routes turn them away until warm-up says the server is ready.
+	stopWarmup := make(chan struct{})
+	warmedUp := make(chan struct{})
+	go func() {
+		defer close(warmedUp)
+		if warmUp(stopWarmup) {
+			setLifecycle(stateReady)
+			writeReadyFile(config.ReadyFile)
+			log.Println("Server is ready")
 		}
-		
-	case sig := <-shutdown:
-		log.Printf("Received shutdown signal: %v", sig)
-		
-		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
-		defer cancel()
-		
-		log.Println("Attempting graceful shutdown...")
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Could not gracefully shutdown the server: %v", err)
-			srv.Close()
+	}()
+	
+	var serveErr error
+	for waiting := true; waiting; {
+		select {
+		case serveErr = <-serverErrors:
+			waiting = false
+		case sig := <-shutdown:
+			log.Printf("Received shutdown signal: %v", sig)
+			waiting = false
+		case sig := <-reload:
+			log.Printf("Received reload signal: %v", sig)
+			if _, err := reloadConfig(); err != nil {
+				log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+			}
 		}
-		
-		log.Println("Server stopped successfully")
 	}
-}
\ No newline at end of file
+	
+	close(stopWarmup)
+	<-warmedUp
+	setLifecycle(stateDraining)
+	removeReadyFile(config.ReadyFile)
+	
+	if serveErr != nil {
+		return fmt.Errorf("Server failed to start: %w", serveErr)
+	}
+	
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	
+	log.Println("Attempting graceful shutdown...")
+	
+	wsDrained := make(chan struct{})
+	go func() {
+		webSockets.drain(ctx)
+		close(wsDrained)
+	}()
+	
+	tasksDrained := make(chan struct{})
+	go func() {
+		scheduler.shutdown(ctx, config.TaskDrainTimeout)
+		close(tasksDrained)
+	}()
+	
+	stopForcing := make(chan struct{})
+	forcing := make(chan struct{})
+	go func() {
+		defer close(forcing)
+		forceCloseBelow(ctx, stopForcing, srv, config.DrainForceBelow)
+	}()
+	
+	shutdownErr := srv.Shutdown(ctx)
+	if shutdownErr != nil {
+		srv.Close()
+	}
+	close(stopForcing)
+	<-forcing
+	<-wsDrained
+	<-tasksDrained
+	
+	if shutdownErr != nil {
+		return fmt.Errorf("Could not gracefully shutdown the server: %w", shutdownErr)
+	}
+	return nil
+}
+
+// newServer applies the connection-level settings: timeouts, HTTP/2
+// limits and, when enabled, h2c.
+func newServer(config *Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:         ":" + config.Port,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+		ConnContext:  connContext,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: config.HTTP2MaxConcurrentStreams,
+			MaxReadFrameSize:     config.HTTP2MaxReadFrameSize,
+		},
+
+		// OPTIONS * is answered by serverOptionsMiddleware instead.
+		DisableGeneralOptionsHandler: true,
+	}
+	
+	if config.H2CEnabled {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEncodedSlashMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow bool
+		path  string
+		want  int
+	}{
+		{"rejected by default", false, "/a%2Fb", http.StatusBadRequest},
+		{"lower case rejected", false, "/a%2fb", http.StatusBadRequest},
+		{"plain slash passes", false, "/a/b", http.StatusOK},
+		{"allowed when opted in", true, "/a%2Fb", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := encodedSlashMiddleware(tt.allow)(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s: status = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodedSlashRoutesAsOneSegment(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a/b", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("a/b")) })
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("root " + r.URL.Path)) })
+	h := encodedSlashMiddleware(true)(mux.ServeHTTP)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/a%2Fb", nil))
+	if got, want := rec.Body.String(), "root /a/b"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestOverloadHandlerRetryHints(t *testing.T) {
+	tests := []struct {
+		method   string
+		wantSafe bool
+	}{
+		{http.MethodGet, true},
+		{http.MethodHead, true},
+		{http.MethodPut, true},
+		{http.MethodDelete, true},
+		{http.MethodPost, false},
+		{http.MethodPatch, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			overloadHandler(rec, httptest.NewRequest(tt.method, "/", nil), http.StatusServiceUnavailable, 2500*time.Millisecond, "busy")
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "3" {
+				t.Errorf("Retry-After = %q, want 3", got)
+			}
+			got := rec.Header().Get("X-Idempotent-Retry")
+			if tt.wantSafe && got != "safe" {
+				t.Errorf("X-Idempotent-Retry = %q, want safe", got)
+			}
+			if !tt.wantSafe && got != "" {
+				t.Errorf("X-Idempotent-Retry = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestOverloadHandlerRetryAfterFloor(t *testing.T) {
+	rec := httptest.NewRecorder()
+	overloadHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusTooManyRequests, 100*time.Millisecond, "slow down")
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer(&Config{IdleTimeout: 100 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	start := time.Now()
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("idle connection read returned %v, want EOF", err)
+	}
+	if idle := time.Since(start); idle > 2*time.Second {
+		t.Errorf("idle connection closed after %v, want about 100ms", idle)
+	}
+}
+
+func TestServerHTTP2MaxConcurrentStreams(t *testing.T) {
+	const maxStreams = 2
+
+	// Streams are counted per connection: once one is full the client
+	// is free to dial another.
+	var mu sync.Mutex
+	active, peak := map[string]int{}, 0
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active[r.RemoteAddr]++
+		peak = max(peak, active[r.RemoteAddr])
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		active[r.RemoteAddr]--
+		mu.Unlock()
+	})
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer(&Config{H2CEnabled: true, HTTP2MaxConcurrentStreams: maxStreams, HTTP2MaxReadFrameSize: 16 << 10}, handler)
+	ts.Start()
+	defer ts.Close()
+
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+
+	const requests = 6
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(ts.URL)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp.ProtoMajor != 2 {
+				errs <- fmt.Errorf("served over %s, want HTTP/2", resp.Proto)
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, n := range active {
+			total += n
+		}
+		return total == requests
+	})
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if peak != maxStreams {
+		t.Errorf("peak concurrent streams on one connection = %d, want %d", peak, maxStreams)
+	}
+}
+
+// logBuffer collects log output written from any goroutine.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *logBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *logBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+// captureLog redirects the standard logger, which access lines also go to
+// in tests, for the rest of the test.
+func captureLog(t testing.TB) *logBuffer {
+	t.Helper()
+	lb := &logBuffer{}
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(lb)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return lb
+}
+
+func TestLoggingExtractsHeaders(t *testing.T) {
+	logs := captureLog(t)
+
+	config := &Config{LogExtractHeaders: []string{"X-Tenant-ID", "X-Client-Version", "X-Absent"}}
+	h := loggingMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("X-Client-Version", "2.1.0\nInjected: line")
+	h(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	for _, want := range []string{`| X-Tenant-ID: "acme"`, `| X-Client-Version: "2.1.0\nInjected: line"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "X-Absent") {
+		t.Error("a header the request didn't send was logged")
+	}
+}
+
+func TestExtractedHeaderFieldsCapsLength(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Long", strings.Repeat("a", 1000))
+
+	got := extractedHeaderFields(req, []string{"X-Long"})
+	if want := ` | X-Long: "` + strings.Repeat("a", maxExtractedHeaderLen) + `"`; got != want {
+		t.Errorf("extractedHeaderFields = %q (%d bytes), want the value capped at %d bytes", got, len(got), maxExtractedHeaderLen)
+	}
+}
+
+// metricsOutput renders the metrics registry as /metrics serves it.
+func metricsOutput(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Body.String()
+}
+
+// newTestServer builds the full handler setupRoutes returns, with config as
+// the active configuration and the server marked ready.
+func newTestServer(t testing.TB, config *Config) http.Handler {
+	t.Helper()
+	prev, state := currentConfig.Load(), currentLifecycle()
+	currentConfig.Store(config)
+	setLifecycle(stateReady)
+	t.Cleanup(func() {
+		currentConfig.Store(prev)
+		setLifecycle(state)
+	})
+	return setupRoutes(config)
+}
+
+// withRequestInfo returns r carrying info, as requestInfoMiddleware would.
+func withRequestInfo(r *http.Request, info *RequestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "requestInfo", info))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metrics = &metricsRegistry{}
+
+// metricsRegistry holds the server's metrics and renders them in the
+// Prometheus text exposition format, or as OpenMetrics (which can carry
+// exemplars) when the scraper asks for it.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families []metricFamily
+}
+
+type metricFamily interface {
+	familyName() string
+	writeTo(w io.Writer, openMetrics bool)
+}
+
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func (reg *metricsRegistry) add(family metricFamily) metricFamily {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, existing := range reg.families {
+		if existing.familyName() == family.familyName() {
+			return existing
+		}
+	}
+	reg.families = append(reg.families, family)
+	return family
+}
+
+func (reg *metricsRegistry) register(kind, name, help string, labels []string) *metricVec {
+	return reg.add(&metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}).(*metricVec)
+}
+
+func (reg *metricsRegistry) counter(name, help string, labels ...string) *metricVec {
+	return reg.register("counter", name, help, labels)
+}
+
+func (reg *metricsRegistry) gauge(name, help string, labels ...string) *metricVec {
+	return reg.register("gauge", name, help, labels)
+}
+
+func (m *metricVec) with(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	m.with(labelValues).value += delta
+	m.mu.Unlock()
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	m.with(labelValues).value = value
+	m.mu.Unlock()
+}
+
+func (m *metricVec) familyName() string {
+	return m.name
+}
+
+func (m *metricVec) writeTo(w io.Writer, openMetrics bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// OpenMetrics names a counter family without the _total suffix its
+	// samples carry.
+	family := m.name
+	if openMetrics && m.kind == "counter" {
+		family = strings.TrimSuffix(family, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", family, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", family, m.kind)
+
+	for _, key := range sortedKeys(m.series) {
+		s := m.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues), formatMetricValue(s.value))
+	}
+}
+
+// defaultDurationBuckets are upper bounds in seconds for request latency.
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	exemplars   []*exemplar
+	count       uint64
+	sum         float64
+}
+
+// exemplar links a single observation to the trace it was recorded in.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+func (reg *metricsRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return reg.add(&histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}).(*histogramVec)
+}
+
+// observe records value. When traceID is set it becomes the exemplar of the
+// bucket the value falls into, replacing that bucket's previous one.
+func (h *histogramVec) observe(value float64, traceID string, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+			exemplars:   make([]*exemplar, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+
+	statsd.observe(h.name, h.labels, labelValues, value)
+
+	i := sort.SearchFloat64s(h.buckets, value)
+	s.counts[i]++
+	s.count++
+	s.sum += value
+	if traceID != "" {
+		s.exemplars[i] = &exemplar{traceID: traceID, value: value, at: time.Now()}
+	}
+}
+
+// histogramCount is how many observations one series of a histogram holds.
+type histogramCount struct {
+	labelValues []string
+	count       uint64
+}
+
+// counts returns the observation count of every series, ordered by label
+// values.
+func (h *histogramVec) counts() []histogramCount {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make([]histogramCount, 0, len(h.series))
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		counts = append(counts, histogramCount{labelValues: s.labelValues, count: s.count})
+	}
+	return counts
+}
+
+func (h *histogramVec) familyName() string {
+	return h.name
+}
+
+func (h *histogramVec) writeTo(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+
+	names := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		values := append(append([]string(nil), s.labelValues...), "")
+
+		var cumulative uint64
+		for i := range s.counts {
+			cumulative += s.counts[i]
+			values[len(values)-1] = "+Inf"
+			if i < len(h.buckets) {
+				values[len(values)-1] = formatMetricValue(h.buckets[i])
+			}
+
+			fmt.Fprintf(w, "%s_bucket%s %d", h.name, formatLabels(names, values), cumulative)
+			if ex := s.exemplars[i]; openMetrics && ex != nil {
+				fmt.Fprintf(w, " # {trace_id=\"%s\"} %s %.3f",
+					escapeLabelValue(ex.traceID), formatMetricValue(ex.value), float64(ex.at.UnixMilli())/1000)
+			}
+			fmt.Fprintln(w)
+		}
+
+		labels := formatLabels(h.labels, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escapeLabelValue(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.mu.Lock()
+	families := append([]metricFamily(nil), metrics.families...)
+	metrics.mu.Unlock()
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	for _, family := range families {
+		family.writeTo(w, openMetrics)
+	}
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogramExemplars(t *testing.T) {
+	h := (&metricsRegistry{}).histogram("test_seconds", "Test.", []float64{0.1, 1})
+	h.observe(0.05, "")
+	h.observe(0.5, "4bf92f3577b34da6a3ce929d0e0e4736")
+	h.observe(5, "")
+
+	var om bytes.Buffer
+	h.writeTo(&om, true)
+	lines := strings.Split(om.String(), "\n")
+	for _, tt := range []struct {
+		bucket   string
+		exemplar bool
+	}{{`le="0.1"`, false}, {`le="1"`, true}, {`le="+Inf"`, false}} {
+		var line string
+		for _, l := range lines {
+			if strings.Contains(l, tt.bucket) {
+				line = l
+			}
+		}
+		if got := strings.Contains(line, ` # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.5 `); got != tt.exemplar {
+			t.Errorf("bucket %s: exemplar = %v, want %v in %q", tt.bucket, got, tt.exemplar, line)
+		}
+	}
+
+	var text bytes.Buffer
+	h.writeTo(&text, false)
+	if strings.Contains(text.String(), "trace_id") {
+		t.Errorf("Prometheus text format carries exemplars:\n%s", text.String())
+	}
+}
+
+func TestRequestDurationExemplarFromTraceparent(t *testing.T) {
+	captureLog(t)
+	const traceID = "0af7651916cd43dd8448eb211c80319c"
+
+	config := &Config{TracingEnabled: true}
+	h := requestInfoMiddleware(config)(loggingMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-b7ad6b7169203331-01")
+	h(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	metricsHandler(rec, scrape)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var found bool
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "http_request_duration_seconds_bucket") && strings.Contains(line, `{trace_id="`+traceID+`"}`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no duration bucket carries the request's trace ID:\n%s", rec.Body)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Error("OpenMetrics output doesn't end with # EOF")
+	}
+	if strings.Contains(metricsOutput(t), traceID) {
+		t.Error("Prometheus text output carries exemplars")
+	}
+}
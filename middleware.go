@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// middleware wraps a handler with one layer of cross-cutting behaviour.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// chain wraps h in mws so that the first middleware is the outermost: it sees
+// the request first and the response last.
+func chain(h http.HandlerFunc, mws ...middleware) http.HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// namedMiddleware is a middleware with the name /debug/routes/chain lists it
+// under, given where the chains are put together.
+type namedMiddleware struct {
+	name string
+	mw   middleware
+}
+
+// namedChain is chain for named middleware that also returns their names,
+// outermost first. Callers leave out layers that don't apply to a route,
+// so the names are the route's chain as it is listed.
+func namedChain(h http.HandlerFunc, mws ...namedMiddleware) (http.HandlerFunc, []string) {
+	names := make([]string, len(mws))
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i].mw(h)
+		names[i] = mws[i].name
+	}
+	return h, names
+}
+
+// recoveryMiddleware turns a panicking handler into a 500 so one bad request
+// doesn't take the connection down with it. A handler that panics after it
+// has started its response has the connection aborted instead. It wraps
+// the mux, second only to requestInfoMiddleware, so the 500 it sends still
+// carries the request ID, and it catches panics from every other middleware
+// too.
+//
+// Sitting where it sees every final status, it also keeps the 5xx samples
+// for /debug/errors. The mux sets r.Pattern on the request it is handed,
+// so the samples are still filed by route.
+func recoveryMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			abort := false
+			if err != nil {
+				log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				if sw.status == 0 {
+					errorHandler(sw, r, http.StatusInternalServerError, "Internal server error")
+				} else {
+					// The status, and maybe part of the body, has gone out
+					// already, so a 500 can't be sent. Abort the response
+					// so the client sees it broken rather than cut off
+					// and looking complete.
+					abort = true
+				}
+				requestInfo(r.Context()).Error = fmt.Sprintf("panic: %v", err)
+			}
+			if sw.status >= 500 {
+				errorSamples.record(r, sw.status)
+			}
+			if abort {
+				panic(http.ErrAbortHandler)
+			}
+		}()
+
+		next(sw, r)
+	}
+}
+
+// statusWriter records the final status code sent to the client. A status of
+// 0 means nothing has been written yet; informational 1xx responses don't
+// count. A hijacked connection is recorded as 101, the status a WebSocket
+// upgrade sends on it.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 && status >= 200 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil {
+		sw.hijacked = true
+		if sw.status == 0 {
+			sw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, brw, err
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// emptyResponseMiddleware catches handlers that return without writing
+// anything, which would otherwise reach the client as a bare 200. It logs
+// the bug and sends status instead: a plain 204, or an error body for
+// anything else. It wraps the handler directly so nothing else has had a
+// chance to write on the handler's behalf.
+func emptyResponseMiddleware(status int) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			next(sw, r)
+			if sw.status != 0 || sw.hijacked {
+				return
+			}
+			// A handler that gave up on its deadline is answered by
+			// handlerTimeoutMiddleware.
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				return
+			}
+
+			log.Printf("[%v] WARNING - Handler for %s %s returned without writing a response, sending %d",
+				requestInfo(r.Context()).ID, r.Method, r.URL.Path, status)
+			if status == http.StatusNoContent {
+				w.WriteHeader(status)
+				return
+			}
+			errorHandler(w, r, status, "Handler returned no response")
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" in")
+				next(w, r)
+				order = append(order, name+" out")
+			}
+		}
+	}
+	h := chain(func(w http.ResponseWriter, r *http.Request) { order = append(order, "handler") }, mw("a"), mw("b"))
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := []string{"a in", "b in", "handler", "b out", "a out"}
+	if !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+func TestNamedChainRecordsTheLayers(t *testing.T) {
+	var order []string
+	// Layers from the same constructor are told apart by the names they
+	// are given, not by their code.
+	mw := func(name string) middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) { order = append(order, name); next(w, r) }
+		}
+	}
+
+	h, names := namedChain(func(w http.ResponseWriter, r *http.Request) {},
+		namedMiddleware{"a", mw("a")}, namedMiddleware{"b", mw("b")}, namedMiddleware{"c", mw("c")})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !slices.Equal(names, order) {
+		t.Errorf("names = %v, want the order the layers ran in, %v", names, order)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	logs := captureLog(t)
+	h := recoveryMiddleware(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if !strings.Contains(logs.String(), "Panic serving GET /: boom") {
+		t.Errorf("panic not logged:\n%s", logs)
+	}
+}
+
+func TestRecoveryMiddlewareAbortsAStartedResponse(t *testing.T) {
+	logs := captureLog(t)
+	srv := httptest.NewServer(recoveryMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		panic("boom")
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Errorf("body read cleanly as %q, want the response cut off", body)
+	}
+	if strings.Contains(string(body), "Internal server error") {
+		t.Errorf("500 written after the response started: %q", body)
+	}
+	if !strings.Contains(logs.String(), "Panic serving GET /: boom") {
+		t.Errorf("panic not logged:\n%s", logs)
+	}
+}
+
+// chainPosition returns where name sits in a recorded chain, or -1.
+func chainPosition(names []string, name string) int {
+	return slices.Index(names, name)
+}
+
+// TestMiddlewareOrderInvariants checks the order setupRoutes composes the
+// middleware in, first as recorded for /debug/routes/chain and then by
+// what each ordering makes observable.
+func TestMiddlewareOrderInvariants(t *testing.T) {
+	logs := captureLog(t)
+	config := loadConfig()
+	config.APIToken = "api-secret"
+	config.DebugEndpoints = true
+	config.CompressionMinSize = 0
+	config.RateLimitRPS = 0.001
+	config.RateLimitBurst = 1
+	handler := newTestServer(t, config)
+
+	t.Run("recovery is outermost", func(t *testing.T) {
+		server := routeChains.Server
+		if len(server) < 2 || server[0] != "requestInfoMiddleware" || server[1] != "recoveryMiddleware" {
+			t.Errorf("server chain = %v, want request IDs then recovery ahead of everything", server)
+		}
+		for _, rc := range routeChains.Routes {
+			if slices.Contains(rc.Middleware, "recoveryMiddleware") {
+				t.Errorf("%s recovers panics again inside the route: %v", rc.Pattern, rc.Middleware)
+			}
+		}
+	})
+
+	var info []string
+	for _, rc := range routeChains.Routes {
+		if rc.Pattern == "/debug/info" {
+			info = rc.Middleware
+		}
+	}
+	for _, tt := range []struct{ outer, inner string }{
+		{"corsMiddleware", "authMiddleware"},
+		{"corsMiddleware", "rateLimitMiddleware"},
+		{"loggingMiddleware", "compressionMiddleware"},
+		{"loggingMiddleware", "rateLimitMiddleware"},
+		{"loggingMiddleware", "authMiddleware"},
+	} {
+		o, i := chainPosition(info, tt.outer), chainPosition(info, tt.inner)
+		if o < 0 || i < 0 || o > i {
+			t.Errorf("%s should wrap %s in %v", tt.outer, tt.inner, info)
+		}
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	completed := func(rec *httptest.ResponseRecorder) bool {
+		return strings.Contains(logs.String(), fmt.Sprintf("] Request completed - Status: %d ", rec.Code))
+	}
+
+	t.Run("CORS preflight before auth and rate limiting", func(t *testing.T) {
+		for range 3 {
+			req := httptest.NewRequest(http.MethodOptions, "/debug/info", nil)
+			req.Header.Set("Origin", "https://app.example")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			rec := serve(req)
+			if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+				t.Fatalf("preflight = %d with ACAO %q, want 200 answered by CORS", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+			}
+		}
+	})
+
+	t.Run("logging records auth and rate limit rejections", func(t *testing.T) {
+		rec := serve(httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+		if rec.Code != http.StatusUnauthorized || !completed(rec) {
+			t.Errorf("unauthenticated request = %d; logged: %v", rec.Code, completed(rec))
+		}
+		rec = serve(httptest.NewRequest(http.MethodGet, "/debug/info", nil))
+		if rec.Code != http.StatusTooManyRequests || !completed(rec) {
+			t.Errorf("over the limit = %d; logged: %v", rec.Code, completed(rec))
+		}
+	})
+
+	t.Run("compression inside logging's status capture", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.RemoteAddr = "192.0.2.50:1234"
+		rec := serve(req)
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+		}
+		if !completed(rec) {
+			t.Errorf("compressed %d response not logged with its status", rec.Code)
+		}
+	})
+}
+
+func TestEmptyResponseMiddleware(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	for _, status := range []int{http.StatusNoContent, http.StatusInternalServerError} {
+		logs := captureLog(t)
+		rec := httptest.NewRecorder()
+		emptyResponseMiddleware(status)(noop)(rec, withRequestInfo(httptest.NewRequest(http.MethodGet, "/quiet", nil), &RequestInfo{ID: 42}))
+
+		if rec.Code != status {
+			t.Errorf("status = %d, want %d", rec.Code, status)
+		}
+		if status == http.StatusNoContent && rec.Body.Len() != 0 {
+			t.Errorf("204 carries a body: %q", rec.Body)
+		}
+		if status != http.StatusNoContent && !strings.Contains(rec.Body.String(), "Handler returned no response") {
+			t.Errorf("error body = %q", rec.Body)
+		}
+		if want := fmt.Sprintf("[42] WARNING - Handler for GET /quiet returned without writing a response, sending %d", status); !strings.Contains(logs.String(), want) {
+			t.Errorf("no warning with the request ID:\n%s", logs)
+		}
+	}
+}
+
+func TestEmptyResponseMiddlewareLeavesWrittenResponses(t *testing.T) {
+	logs := captureLog(t)
+	for name, h := range map[string]http.HandlerFunc{
+		"header": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) },
+		"body":   func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+	} {
+		rec := httptest.NewRecorder()
+		emptyResponseMiddleware(http.StatusNoContent)(h)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code == http.StatusNoContent {
+			t.Errorf("%s: a written response was replaced", name)
+		}
+	}
+	if logs.String() != "" {
+		t.Errorf("warned about handlers that wrote a response:\n%s", logs)
+	}
+}
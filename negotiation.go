@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityList splits a header such as Accept or Accept-Encoding into
+// its lower-cased values and their q weights. A missing weight is 1 and a
+// malformed one is treated as 0.
+func parseQualityList(header string) []qualityValue {
+	var list []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, raw, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		list = append(list, qualityValue{value: value, q: q})
+	}
+	return list
+}
+
+// acceptsMediaType reports whether an Accept header allows mediaType. The
+// most specific matching range decides, so "*/*, application/json;q=0"
+// rejects JSON.
+func acceptsMediaType(accept, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	typ, _, _ := strings.Cut(mediaType, "/")
+	bestSpecificity, bestQ := -1, 0.0
+
+	for _, qv := range parseQualityList(accept) {
+		specificity := -1
+		switch {
+		case qv.value == mediaType:
+			specificity = 2
+		case qv.value == typ+"/*":
+			specificity = 1
+		case qv.value == "*/*":
+			specificity = 0
+		}
+		if specificity > bestSpecificity {
+			bestSpecificity, bestQ = specificity, qv.q
+		}
+	}
+	return bestSpecificity >= 0 && bestQ > 0
+}
+
+// acceptMiddleware checks the Accept header against the media type the
+// handler produces. In strict mode an unsupported Accept gets 406; otherwise
+// the handler runs and the client gets mediaType regardless.
+func acceptMiddleware(strict bool, mediaType string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		// A route without a media type, such as the fallback proxy, serves
+		// whatever its source sends.
+		if !strict || mediaType == "" {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !acceptsMediaType(r.Header.Get("Accept"), mediaType) {
+				notAcceptableHandler(w, r, []string{mediaType})
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func notAcceptableHandler(w http.ResponseWriter, r *http.Request, supported []string) {
+	requestID := requestInfo(r.Context()).ID
+
+	response := map[string]interface{}{
+		"status":          "error",
+		"message":         "None of the requested media types are supported",
+		"supported_types": supported,
+		"path":            r.URL.Path,
+		"request_id":      requestID,
+		"timestamp":       formatTimestamp(time.Now()),
+	}
+
+	writeJSON(w, r, http.StatusNotAcceptable, response)
+}
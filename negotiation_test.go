@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		accept string
+		want   int
+	}{
+		{"supported type", true, "application/json", http.StatusOK},
+		{"wildcard", true, "*/*", http.StatusOK},
+		{"type wildcard", true, "application/*", http.StatusOK},
+		{"no Accept header", true, "", http.StatusOK},
+		{"unsupported type, strict", true, "application/xml", http.StatusNotAcceptable},
+		{"refused type, strict", true, "*/*, application/json;q=0", http.StatusNotAcceptable},
+		{"unsupported type, lenient", false, "application/xml", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := acceptMiddleware(tt.strict, "application/json")(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, r, http.StatusOK, map[string]string{"status": "success"})
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want JSON either way", ct)
+			}
+			if tt.want != http.StatusNotAcceptable {
+				return
+			}
+			var body struct {
+				SupportedTypes []string `json:"supported_types"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.SupportedTypes) != 1 || body.SupportedTypes[0] != "application/json" {
+				t.Errorf("supported_types = %q, want [application/json]", body.SupportedTypes)
+			}
+		})
+	}
+}
+
+func TestAcceptMiddlewareWithoutMediaType(t *testing.T) {
+	h := acceptMiddleware(true, "")(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "image/png")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want a route without a media type to skip the check", rec.Code)
+	}
+}
+
+func TestParseQualityList(t *testing.T) {
+	got := parseQualityList("Text/HTML;q=0.5, application/json , */*;q=bogus,,")
+	want := []qualityValue{{"text/html", 0.5}, {"application/json", 1}, {"*/*", 0}}
+	if len(got) != len(want) {
+		t.Fatalf("parseQualityList = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
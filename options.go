@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// openRouteMethods stand in for a route that accepts any method when the
+// server's methods are listed; they are the ones CORS allows.
+var openRouteMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// serverMethods lists every method some route accepts, sorted, with
+// OPTIONS last as methodMiddleware lists it.
+func serverMethods(routes []route) []string {
+	var methods []string
+	for _, rt := range routes {
+		if len(rt.methods) == 0 {
+			methods = append(methods, openRouteMethods...)
+			continue
+		}
+		methods = append(methods, rt.methods...)
+	}
+	slices.Sort(methods)
+	methods = slices.Compact(methods)
+	methods = slices.DeleteFunc(methods, func(m string) bool { return m == http.MethodOptions })
+	return append(methods, http.MethodOptions)
+}
+
+// serverOptionsMiddleware answers OPTIONS *, which asks about the server as
+// a whole rather than any path, with a 200 and an Allow header listing
+// methods. The request never reaches the mux, which would otherwise treat
+// "*" as a path and redirect it. It needs http.Server's own handling of
+// OPTIONS * turned off, which answers with a bare 200 before any handler
+// runs.
+func serverOptionsMiddleware(methods []string) middleware {
+	allow := strings.Join(methods, ", ")
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions || r.RequestURI != "*" {
+				next(w, r)
+				return
+			}
+
+			accessLog.printf("[%d] Server-wide OPTIONS - RemoteAddr: %s | Allow: %s", requestInfo(r.Context()).ID, r.RemoteAddr, allow)
+			w.Header().Set("Allow", allow)
+			w.Header().Set("Content-Length", "0")
+			// corsMiddleware adds its headers and answers OPTIONS itself.
+			corsMiddleware(next)(w, r)
+		}
+	}
+}
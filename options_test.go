@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerMethods(t *testing.T) {
+	routes := []route{
+		{pattern: "/a", methods: []string{http.MethodGet, http.MethodOptions}},
+		{pattern: "/b", methods: []string{http.MethodPost, http.MethodGet}},
+	}
+	if got, want := serverMethods(routes), []string{"GET", "POST", "OPTIONS"}; !slices.Equal(got, want) {
+		t.Errorf("methods = %q, want %q", got, want)
+	}
+
+	routes = append(routes, route{pattern: "/any"})
+	if got, want := serverMethods(routes), []string{"DELETE", "GET", "POST", "PUT", "OPTIONS"}; !slices.Equal(got, want) {
+		t.Errorf("methods with an open route = %q, want %q", got, want)
+	}
+}
+
+func TestServerWideOptions(t *testing.T) {
+	logs := captureLog(t)
+	config := loadConfig()
+	handler := newTestServer(t, config)
+	want := strings.Join(serverMethods(routeTable(config)), ", ")
+
+	// http.Server answers OPTIONS * itself unless told not to, as run does.
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config.DisableGeneralOptionsHandler = true
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("status = %d, body = %q, want an empty 200", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Allow"); got != want {
+		t.Errorf("Allow = %q, want %q", got, want)
+	}
+	if !strings.HasSuffix(want, ", OPTIONS") || !strings.Contains(want, "GET") {
+		t.Errorf("Allow = %q, want GET among the methods and OPTIONS last", want)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the CORS headers too", got)
+	}
+	if !strings.Contains(logs.String(), "Server-wide OPTIONS - RemoteAddr: ") {
+		t.Errorf("server-wide OPTIONS not logged in:\n%s", logs)
+	}
+}
+
+func TestOptionsOnPathIsNotServerWide(t *testing.T) {
+	logs := captureLog(t)
+	handler := newTestServer(t, loadConfig())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/health", nil))
+	if got := rec.Header().Get("Allow"); got != "" || strings.Contains(logs.String(), "Server-wide OPTIONS") {
+		t.Errorf("OPTIONS /health answered as server-wide, Allow %q", got)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitWithParent asks the kernel to send SIGTERM when the parent process
+// exits, so a supervised server shuts down through the usual signal path
+// instead of living on as an orphan.
+func exitWithParent() error {
+	ppid := os.Getppid()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_PDEATHSIG, uintptr(syscall.SIGTERM), 0); errno != 0 {
+		return errno
+	}
+
+	// The parent may have died before the prctl took effect, in which case
+	// we have already been reparented and no signal is coming.
+	if os.Getppid() != ppid {
+		return syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}
+	return nil
+}
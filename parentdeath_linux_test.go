@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestExitWithParentHelper is not a test itself: run with PDEATH_HELPER
+// set, it plays the parent or the child process of TestExitWithParent.
+func TestExitWithParentHelper(t *testing.T) {
+	dir := os.Getenv("PDEATH_DIR")
+	switch os.Getenv("PDEATH_HELPER") {
+	case "parent":
+		child := exec.Command(os.Args[0], "-test.run=^TestExitWithParentHelper$")
+		child.Env = append(os.Environ(), "PDEATH_HELPER=child")
+		if err := child.Start(); err != nil {
+			os.Exit(2)
+		}
+		// Exit as soon as the child has asked to be told, without waiting
+		// for it.
+		waitForFile(filepath.Join(dir, "ready"), 5*time.Second)
+		os.Exit(0)
+	case "child":
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM)
+		if err := exitWithParent(); err != nil {
+			os.Exit(2)
+		}
+		os.WriteFile(filepath.Join(dir, "ready"), nil, 0o600)
+		select {
+		case <-sigs:
+			os.WriteFile(filepath.Join(dir, "terminated"), nil, 0o600)
+		case <-time.After(10 * time.Second):
+		}
+		os.Exit(0)
+	}
+}
+
+func waitForFile(path string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestExitWithParent(t *testing.T) {
+	dir := t.TempDir()
+	parent := exec.Command(os.Args[0], "-test.run=^TestExitWithParentHelper$")
+	parent.Env = append(os.Environ(), "PDEATH_HELPER=parent", "PDEATH_DIR="+dir)
+	if out, err := parent.CombinedOutput(); err != nil {
+		t.Fatalf("parent process failed: %v\n%s", err, out)
+	}
+
+	if !waitForFile(filepath.Join(dir, "ready"), time.Second) {
+		t.Fatal("child never set up its parent death signal")
+	}
+	if !waitForFile(filepath.Join(dir, "terminated"), 5*time.Second) {
+		t.Error("child was not sent SIGTERM when its parent exited")
+	}
+}
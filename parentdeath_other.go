@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func exitWithParent() error {
+	return errors.New("exiting with the parent process is only supported on Linux")
+}
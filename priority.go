@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requestPriority decides how readily load shedding turns a request away;
+// high-priority requests are not rate limited either. Probe and admin
+// routes are never shed or rate limited at all, whatever their priority.
+type requestPriority int
+
+const (
+	priorityNormal requestPriority = iota
+	priorityHigh
+	priorityLow
+)
+
+func (p requestPriority) String() string {
+	switch p {
+	case priorityHigh:
+		return "high"
+	case priorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+func parsePriority(s string) (requestPriority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high":
+		return priorityHigh, true
+	case "normal":
+		return priorityNormal, true
+	case "low":
+		return priorityLow, true
+	}
+	return priorityNormal, false
+}
+
+// shedFraction scales the shedder's current fraction to a priority: high
+// priority requests are never shed and low priority ones are shed at twice
+// the rate, so they go first.
+func (p requestPriority) shedFraction(fraction float64) float64 {
+	switch p {
+	case priorityHigh:
+		return 0
+	case priorityLow:
+		return min(1, 2*fraction)
+	}
+	return fraction
+}
+
+// parseRoutePriorities reads "pattern=priority" entries. Invalid entries
+// are logged and skipped.
+func parseRoutePriorities(spec []string) map[string]requestPriority {
+	priorities := make(map[string]requestPriority, len(spec))
+	for _, entry := range spec {
+		pattern, value, ok := strings.Cut(entry, "=")
+		p, valid := parsePriority(value)
+		if !ok || pattern == "" || !valid {
+			log.Printf("Invalid ROUTE_PRIORITIES entry %q, ignoring it", entry)
+			continue
+		}
+		priorities[pattern] = p
+	}
+	return priorities
+}
+
+// priorityOf returns the priority named in r's header, falling back to the
+// route's own. The header is trusted as-is, so it should be set by a
+// gateway and stripped from client requests.
+func priorityOf(r *http.Request, header string, route requestPriority) requestPriority {
+	if header == "" {
+		return route
+	}
+	if p, ok := parsePriority(r.Header.Get(header)); ok {
+		return p
+	}
+	return route
+}
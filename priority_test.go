@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRoutePriorities(t *testing.T) {
+	logs := captureLog(t)
+	got := parseRoutePriorities([]string{"/=low", "/admin/pause=HIGH", "/x= normal ", "/bad=urgent", "=high", "nothing"})
+	want := map[string]requestPriority{"/": priorityLow, "/admin/pause": priorityHigh, "/x": priorityNormal}
+	if len(got) != len(want) {
+		t.Fatalf("priorities = %v, want %v", got, want)
+	}
+	for pattern, p := range want {
+		if got[pattern] != p {
+			t.Errorf("%s = %v, want %v", pattern, got[pattern], p)
+		}
+	}
+	if n := strings.Count(logs.String(), "Invalid ROUTE_PRIORITIES entry"); n != 3 {
+		t.Errorf("%d invalid entries logged, want 3", n)
+	}
+}
+
+func TestPriorityShedFraction(t *testing.T) {
+	tests := []struct {
+		p        requestPriority
+		fraction float64
+		want     float64
+	}{
+		{priorityHigh, 0.9, 0},
+		{priorityNormal, 0.3, 0.3},
+		{priorityLow, 0.3, 0.6},
+		{priorityLow, 0.9, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.p.shedFraction(tt.fraction); got != tt.want {
+			t.Errorf("%v.shedFraction(%v) = %v, want %v", tt.p, tt.fraction, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Priority", "High")
+	if got := priorityOf(req, "X-Priority", priorityLow); got != priorityHigh {
+		t.Errorf("priority = %v, want the header's high", got)
+	}
+	if got := priorityOf(req, "", priorityLow); got != priorityLow {
+		t.Errorf("priority = %v with no PRIORITY_HEADER, want the route's low", got)
+	}
+	req.Header.Set("X-Priority", "urgent")
+	if got := priorityOf(req, "X-Priority", priorityNormal); got != priorityNormal {
+		t.Errorf("priority = %v for an unknown value, want the route's", got)
+	}
+}
+
+func TestLoadSheddingByPriority(t *testing.T) {
+	captureLog(t)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	s := newLoadShedder(0.1, 10*time.Second, 0.4)
+	s.now = clock.now
+
+	failing := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	routes := map[requestPriority]http.HandlerFunc{
+		priorityHigh:   loadShedMiddleware(s, "X-Priority", priorityHigh)(ok),
+		priorityNormal: loadShedMiddleware(s, "X-Priority", priorityNormal)(failing),
+		priorityLow:    loadShedMiddleware(s, "X-Priority", priorityLow)(ok),
+	}
+	serve := func(h http.HandlerFunc, n int, header string) (shed int) {
+		for range n {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if header != "" {
+				req.Header.Set("X-Priority", header)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code == http.StatusServiceUnavailable {
+				shed++
+			}
+		}
+		return shed
+	}
+
+	// Drive the error rate up until shedding is at its 0.4 cap.
+	serve(routes[priorityNormal], 2000, "")
+	if f := s.fraction(); f != 0.4 {
+		t.Fatalf("shed fraction = %v, want the 0.4 cap", f)
+	}
+
+	if shed := serve(routes[priorityHigh], 500, ""); shed != 0 {
+		t.Errorf("%d of 500 high priority requests shed, want none", shed)
+	}
+	low := serve(routes[priorityLow], 1000, "")
+	normal := serve(routes[priorityNormal], 1000, "")
+	if low < 700 || low > 900 {
+		t.Errorf("%d of 1000 low priority requests shed, want about 800", low)
+	}
+	if normal < 300 || normal > 500 {
+		t.Errorf("%d of 1000 normal priority requests shed, want about 400", normal)
+	}
+	if shed := serve(routes[priorityLow], 500, "high"); shed != 0 {
+		t.Errorf("%d of 500 requests shed with a high priority header, want none", shed)
+	}
+
+	out := metricsOutput(t)
+	for _, want := range []string{`load_shed_requests_total{priority="low"}`, `load_shed_requests_total{priority="normal"}`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(out, `load_shed_requests_total{priority="high"}`) {
+		t.Error("high priority requests counted as shed")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// With HEALTH_FAST_PATH on, the probe endpoints write response bodies
+// serialised ahead of time instead of marshalling a map per request, for
+// meshes that probe every instance many times a second. The bodies leave
+// out the per-request timestamp and request ID fields; the request ID is
+// still in X-Request-ID. /livez never changes, /readyz has one body per
+// lifecycle state, and /health is rebuilt when its status, checks or
+// uptime, to the second, change.
+
+var (
+	livezBody    = mustMarshalJSON(map[string]interface{}{"status": "alive"})
+	readyzBodies = map[lifecycleState][]byte{
+		stateStarting: mustMarshalJSON(map[string]interface{}{"status": stateStarting.String()}),
+		stateReady:    mustMarshalJSON(map[string]interface{}{"status": stateReady.String()}),
+		stateDraining: mustMarshalJSON(map[string]interface{}{"status": stateDraining.String()}),
+	}
+)
+
+func mustMarshalJSON(v interface{}) []byte {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return append(body, '\n')
+}
+
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	setJSONHeaders(w, r)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func livezFastHandler(w http.ResponseWriter, r *http.Request) {
+	writeCachedJSON(w, r, http.StatusOK, livezBody)
+}
+
+func readyzFastHandler(w http.ResponseWriter, r *http.Request) {
+	state := currentLifecycle()
+	code := http.StatusOK
+	if state != stateReady {
+		code = http.StatusServiceUnavailable
+	}
+	writeCachedJSON(w, r, code, readyzBodies[state])
+}
+
+// cachedHealth is a serialised /health body and what it was built from.
+type cachedHealth struct {
+	uptime time.Duration
+	checks map[string]string
+	code   int
+	body   []byte
+}
+
+// healthFastHandler answers like healthHandler from a body cached per
+// depth. Deep probes still run the checks on every request, since their
+// results are what the probe asks for, but only compare them against the
+// cached ones; a fresh result map is built only once they differ.
+func healthFastHandler(probes *probeSources) http.HandlerFunc {
+	var liveness, deep atomic.Pointer[cachedHealth]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		uptime := time.Since(serverStartTime).Truncate(time.Second)
+		depth := probes.depthFor(r)
+
+		cache := &liveness
+		if depth == healthDeep {
+			cache = &deep
+		}
+		if cached := cache.Load(); cached != nil && cached.uptime == uptime &&
+			(depth != healthDeep || healthChecks.matches(cached.checks)) {
+			writeCachedJSON(w, r, cached.code, cached.body)
+			return
+		}
+
+		var checks map[string]string
+		healthy := true
+		if depth == healthDeep {
+			checks, healthy = healthChecks.run()
+		}
+
+		status, code := "healthy", http.StatusOK
+		if !healthy {
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+
+		health := map[string]interface{}{
+			"status":    status,
+			"depth":     depth,
+			"uptime":    uptime.String(),
+			"uptime_ms": uptime.Milliseconds(),
+		}
+		if len(checks) > 0 {
+			health["checks"] = checks
+		}
+
+		cached := &cachedHealth{uptime: uptime, checks: checks, code: code, body: mustMarshalJSON(health)}
+		cache.Store(cached)
+		writeCachedJSON(w, r, cached.code, cached.body)
+	}
+}
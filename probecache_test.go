@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// discardWriter is a ResponseWriter that keeps nothing but its headers,
+// reused across requests, so benchmarks measure the handler alone.
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(status int)      { w.status = status }
+
+func deepProbe() *http.Request {
+	return withRequestInfo(httptest.NewRequest(http.MethodGet, "/health", nil), &RequestInfo{ID: 1})
+}
+
+func decodeHealth(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	return body
+}
+
+func TestHealthFastPathMatchesHealthHandler(t *testing.T) {
+	probes := newProbeSources(nil, "deep")
+
+	slow, fast := httptest.NewRecorder(), httptest.NewRecorder()
+	healthHandler(probes)(slow, deepProbe())
+	healthFastHandler(probes)(fast, deepProbe())
+
+	want, got := decodeHealth(t, slow), decodeHealth(t, fast)
+	delete(want, "timestamp")
+	delete(want, "request_id")
+	for _, key := range []string{"status", "depth", "checks"} {
+		if w, g := want[key], got[key]; !jsonEqual(w, g) {
+			t.Errorf("%s = %v, want %v as healthHandler sends", key, g, w)
+		}
+	}
+	if fast.Code != slow.Code || fast.Header().Get("X-Request-ID") != "1" {
+		t.Errorf("status = %d, X-Request-ID = %q, want %d and 1", fast.Code, fast.Header().Get("X-Request-ID"), slow.Code)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestHealthFastPathFollowsChecks(t *testing.T) {
+	var failing atomic.Bool
+	t.Cleanup(func() { failing.Store(false) })
+	healthChecks.register("probecache:db", func() error {
+		if failing.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	handler := healthFastHandler(newProbeSources(nil, "deep"))
+	probe := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler(rec, deepProbe())
+		return rec.Code, decodeHealth(t, rec)
+	}
+
+	if code, body := probe(); code != http.StatusOK || body["checks"].(map[string]interface{})["probecache:db"] != "ok" {
+		t.Fatalf("healthy: status = %d, body = %v", code, body)
+	}
+	failing.Store(true)
+	code, body := probe()
+	if code != http.StatusServiceUnavailable || body["status"] != "unhealthy" ||
+		body["checks"].(map[string]interface{})["probecache:db"] != "connection refused" {
+		t.Errorf("failing check served from the stale body: status = %d, body = %v", code, body)
+	}
+	failing.Store(false)
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("recovered check: status = %d, want 200", code)
+	}
+}
+
+func TestReadyzFastPathFollowsLifecycle(t *testing.T) {
+	prev := currentLifecycle()
+	t.Cleanup(func() { setLifecycle(prev) })
+
+	for state, code := range map[lifecycleState]int{
+		stateStarting: http.StatusServiceUnavailable,
+		stateReady:    http.StatusOK,
+		stateDraining: http.StatusServiceUnavailable,
+	} {
+		setLifecycle(state)
+		rec := httptest.NewRecorder()
+		readyzFastHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != code || decodeHealth(t, rec)["status"] != state.String() {
+			t.Errorf("%v: status = %d, body = %s, want %d", state, rec.Code, rec.Body, code)
+		}
+	}
+}
+
+func TestHealthFastPathAllocations(t *testing.T) {
+	probes := newProbeSources(nil, "deep")
+	req := deepProbe()
+	w := &discardWriter{header: http.Header{}}
+	fast, slow := healthFastHandler(probes), healthHandler(probes)
+
+	fast(w, req)
+	fastAllocs := testing.AllocsPerRun(100, func() { fast(w, req) })
+	slowAllocs := testing.AllocsPerRun(100, func() { slow(w, req) })
+	// Only the headers are left; the uptime ticking over a second can
+	// rebuild the body once within the run.
+	if fastAllocs > 8 || fastAllocs*4 > slowAllocs {
+		t.Errorf("fast path allocates %v per probe against %v, want far fewer", fastAllocs, slowAllocs)
+	}
+}
+
+func BenchmarkHealthFast(b *testing.B) {
+	healthChecks.register("bench:ok", func() error { return nil })
+	handler := healthFastHandler(newProbeSources(nil, "deep"))
+	req := deepProbe()
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	for b.Loop() {
+		handler(w, req)
+	}
+}
+
+func BenchmarkHealth(b *testing.B) {
+	healthChecks.register("bench:ok", func() error { return nil })
+	handler := healthHandler(newProbeSources(nil, "deep"))
+	req := deepProbe()
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	for b.Loop() {
+		handler(w, req)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// healthDepth is how much work a health request does. A liveness probe only
+// needs to know the process is serving, so it skips the registered checks
+// that a deep probe runs.
+type healthDepth string
+
+const (
+	healthLiveness healthDepth = "liveness"
+	healthDeep     healthDepth = "deep"
+)
+
+func parseHealthDepth(value string) (healthDepth, bool) {
+	switch depth := healthDepth(strings.ToLower(strings.TrimSpace(value))); depth {
+	case healthLiveness, healthDeep:
+		return depth, true
+	}
+	return "", false
+}
+
+// probeRule maps one probe source, matched by User-Agent substring or by
+// client address, to a health depth.
+type probeRule struct {
+	userAgent string
+	prefix    netip.Prefix
+	depth     healthDepth
+}
+
+func (pr probeRule) matches(r *http.Request) bool {
+	if pr.userAgent != "" {
+		return strings.Contains(strings.ToLower(r.UserAgent()), pr.userAgent)
+	}
+
+	// The client IP from RequestInfo has been through TRUSTED_PROXIES, so a
+	// monitor behind a load balancer is matched by its own address.
+	addr, err := netip.ParseAddr(requestInfo(r.Context()).ClientIP)
+	return err == nil && pr.prefix.Contains(addr.Unmap())
+}
+
+// probeSources picks the health depth for a request from the first rule its
+// source matches, falling back to a default.
+type probeSources struct {
+	rules    []probeRule
+	fallback healthDepth
+}
+
+// newProbeSources parses entries of the form "ua:<substring>=<depth>" or
+// "ip:<address or CIDR>=<depth>". Invalid entries are logged and skipped.
+func newProbeSources(spec []string, fallback string) *probeSources {
+	ps := &probeSources{fallback: healthDeep}
+	if depth, ok := parseHealthDepth(fallback); ok {
+		ps.fallback = depth
+	} else {
+		log.Printf("Invalid value for HEALTH_DEFAULT_DEPTH: %q, using deep", fallback)
+	}
+
+	for _, entry := range spec {
+		rule, ok := parseProbeRule(entry)
+		if !ok {
+			log.Printf("Invalid HEALTH_PROBE_SOURCES entry %q, ignoring it", entry)
+			continue
+		}
+		ps.rules = append(ps.rules, rule)
+	}
+	return ps
+}
+
+func parseProbeRule(entry string) (probeRule, bool) {
+	source, depthStr, ok := strings.Cut(entry, "=")
+	if !ok {
+		return probeRule{}, false
+	}
+	depth, ok := parseHealthDepth(depthStr)
+	if !ok {
+		return probeRule{}, false
+	}
+
+	kind, value, ok := strings.Cut(strings.TrimSpace(source), ":")
+	if !ok || value == "" {
+		return probeRule{}, false
+	}
+
+	switch strings.ToLower(kind) {
+	case "ua":
+		return probeRule{userAgent: strings.ToLower(value), depth: depth}, true
+	case "ip":
+		prefix, ok := parseAddrOrPrefix(value)
+		if !ok {
+			return probeRule{}, false
+		}
+		return probeRule{prefix: prefix, depth: depth}, true
+	}
+	return probeRule{}, false
+}
+
+func (ps *probeSources) depthFor(r *http.Request) healthDepth {
+	for _, rule := range ps.rules {
+		if rule.matches(r) {
+			return rule.depth
+		}
+	}
+	return ps.fallback
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeSourcesPickDepth(t *testing.T) {
+	captureLog(t)
+	probes := newProbeSources([]string{"ua:kube-probe=liveness", "ip:10.20.0.0/16=deep", "bogus", "ip:nope=deep"}, "liveness")
+	handler := healthHandler(probes)
+
+	tests := []struct {
+		name, userAgent, remoteAddr, clientIP string
+		want                                  healthDepth
+	}{
+		{"kube-probe", "kube-probe/1.29", "10.20.1.5:5000", "10.20.1.5", healthLiveness},
+		{"monitoring network", "Pingdom.com_bot", "10.20.1.5:5000", "10.20.1.5", healthDeep},
+		// Behind a trusted proxy the peer is the proxy; the forwarded
+		// client address is what the rule is matched against.
+		{"forwarded monitor", "monitor", "192.0.2.1:5000", "10.20.9.9", healthDeep},
+		{"forwarded outsider", "monitor", "10.20.1.5:5000", "198.51.100.7", healthLiveness},
+		{"default", "curl/8.0", "198.51.100.7:5000", "198.51.100.7", healthLiveness},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.RemoteAddr = tt.remoteAddr
+			req = withRequestInfo(req, &RequestInfo{ClientIP: tt.clientIP})
+
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			var body struct {
+				Depth healthDepth `json:"depth"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Depth != tt.want {
+				t.Errorf("depth = %q, want %q", body.Depth, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeSourcesThroughTrustedProxy(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.TrustedProxies = []string{"192.0.2.0/24"}
+	config.HealthProbeSources = []string{"ip:10.20.0.0/16=deep"}
+	config.HealthDefaultDepth = "liveness"
+	handler := newTestServer(t, config)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+	req.Header.Set("X-Forwarded-For", "10.20.3.4")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body struct {
+		Depth healthDepth `json:"depth"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if body.Depth != healthDeep {
+		t.Errorf("forwarded monitor got depth %q, want deep", body.Depth)
+	}
+}
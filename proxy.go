@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// hopByHopHeaders apply to a single transport connection (RFC 7230 section
+// 6.1) and must not be forwarded by a proxy.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders strips hop-by-hop headers from a proxied request or
+// response, including any extra ones the sender listed in Connection.
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// proxyHandler forwards requests to upstream and relays its responses, for
+// PROXY_FALLBACK_URL. Hop-by-hop headers are stripped in both directions;
+// the upstream sees the client in X-Forwarded-For, -Host and -Proto.
+// Upgrades aren't proxied, since the Upgrade header is hop-by-hop.
+//
+// Calls go through cb: connection failures and 5xx responses count against
+// the upstream, and while the breaker is open requests get a 503 straight
+// away instead of waiting on it.
+func proxyHandler(upstream *url.URL, transport http.RoundTripper, cb *circuitBreaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		out.URL.Scheme = upstream.Scheme
+		out.URL.Host = upstream.Host
+		out.URL.Path = strings.TrimSuffix(upstream.Path, "/") + r.URL.Path
+		if r.URL.RawPath != "" {
+			out.URL.RawPath = strings.TrimSuffix(upstream.EscapedPath(), "/") + r.URL.RawPath
+		}
+		out.Host = upstream.Host
+		if r.ContentLength == 0 {
+			out.Body = nil
+		}
+
+		removeHopByHopHeaders(out.Header)
+		out.Header.Set("X-Forwarded-For", strings.Join(append(r.Header.Values("X-Forwarded-For"), clientIP(r)), ", "))
+		out.Header.Set("X-Forwarded-Host", r.Host)
+		if r.TLS != nil {
+			out.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			out.Header.Set("X-Forwarded-Proto", "http")
+		}
+
+		var resp *http.Response
+		err := cb.call(func() error {
+			var err error
+			resp, err = transport.RoundTrip(out)
+			if err == nil && resp.StatusCode >= 500 {
+				return errUpstreamStatus
+			}
+			return err
+		})
+		if errors.Is(err, errCircuitOpen) {
+			breakerOpenHandler(w, r, cb)
+			return
+		}
+		if resp == nil {
+			log.Printf("[%v] Proxying %s %s to %s failed: %v", requestInfo(r.Context()).ID, r.Method, r.URL.Path, upstream.Host, err)
+			errorHandler(w, r, http.StatusBadGateway, "Upstream request failed")
+			return
+		}
+		defer resp.Body.Close()
+
+		removeHopByHopHeaders(resp.Header)
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		for name := range resp.Trailer {
+			w.Header().Add("Trailer", name)
+		}
+		w.WriteHeader(resp.StatusCode)
+
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("[%v] Proxied response from %s cut short: %v", requestInfo(r.Context()).ID, upstream.Host, err)
+			return
+		}
+		for name, values := range resp.Trailer {
+			w.Header()[name] = values
+		}
+	}
+}
+
+// errUpstreamStatus marks a 5xx from the upstream as a failure for the
+// breaker. The response itself is still relayed.
+var errUpstreamStatus = errors.New("upstream answered with a server error")
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Hop")
+	h.Add("Connection", "x-other-hop")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	h.Set("Te", "trailers")
+	h.Set("Trailer", "X-Checksum")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Upgrade", "websocket")
+	h.Set("X-Hop", "1")
+	h.Set("X-Other-Hop", "1")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-End-To-End", "kept")
+
+	removeHopByHopHeaders(h)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "X-Hop", "X-Other-Hop"} {
+		if v := h.Values(name); len(v) > 0 {
+			t.Errorf("%s = %q, want it removed", name, v)
+		}
+	}
+	for _, name := range []string{"Content-Type", "X-End-To-End"} {
+		if h.Get(name) == "" {
+			t.Errorf("end-to-end header %s was removed", name)
+		}
+	}
+}
+
+func TestProxyHandlerStripsHopByHopHeaders(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("X-Upstream", "kept")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "from upstream")
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL + "/base")
+	h := proxyHandler(target, http.DefaultTransport, testBreaker(t, 5))
+
+	req := httptest.NewRequest(http.MethodGet, "http://portserver.test/a/b?x=1", nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("X-Client", "kept")
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if got == nil {
+		t.Fatal("request never reached the upstream")
+	}
+	if got.URL.Path != "/base/a/b" || got.URL.RawQuery != "x=1" {
+		t.Errorf("upstream saw %s?%s, want /base/a/b?x=1", got.URL.Path, got.URL.RawQuery)
+	}
+	for _, name := range []string{"X-Client-Hop", "Keep-Alive", "Proxy-Authorization", "Upgrade"} {
+		if v := got.Header.Get(name); v != "" {
+			t.Errorf("upstream received %s: %q", name, v)
+		}
+	}
+	if got.Header.Get("X-Client") != "kept" {
+		t.Error("end-to-end request header X-Client was not forwarded")
+	}
+	if xff := got.Header.Get("X-Forwarded-For"); !strings.HasSuffix(xff, "192.0.2.1") {
+		t.Errorf("X-Forwarded-For = %q, want the client address", xff)
+	}
+	if got.Header.Get("X-Forwarded-Host") != "portserver.test" {
+		t.Errorf("X-Forwarded-Host = %q", got.Header.Get("X-Forwarded-Host"))
+	}
+
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "from upstream" {
+		t.Errorf("response = %d %q, want the upstream's", rec.Code, rec.Body.String())
+	}
+	for _, name := range []string{"Connection", "X-Upstream-Hop", "Keep-Alive", "Proxy-Authenticate"} {
+		if v := rec.Header().Get(name); v != "" {
+			t.Errorf("client received %s: %q", name, v)
+		}
+	}
+	if rec.Header().Get("X-Upstream") != "kept" {
+		t.Error("end-to-end response header X-Upstream was not relayed")
+	}
+}
+
+func TestProxyHandlerUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	rec := httptest.NewRecorder()
+	proxyHandler(target, http.DefaultTransport, testBreaker(t, 5))(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestFallbackProxyRoute(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	routes := routeTable(&Config{ProxyFallbackURL: upstream.URL, HealthDefaultDepth: "deep"})
+	i := slices.IndexFunc(routes, func(rt route) bool { return rt.pattern == "/" })
+	if i < 0 || !strings.Contains(routes[i].description, "Forwards") {
+		t.Fatalf("the catch-all route doesn't proxy: %+v", routes[i])
+	}
+}
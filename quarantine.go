@@ -0,0 +1,253 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	quarantinedClients = metrics.gauge("quarantined_clients",
+		"Client IPs currently quarantined.")
+	quarantineRejections = metrics.counter("quarantine_rejections_total",
+		"Requests turned away because the client is quarantined.")
+)
+
+// quarantine is the list of blocked client IPs. It is set up with the
+// routes.
+var quarantine *quarantineList
+
+// quarantineList blocks client IPs for a while. Clients end up on it by
+// hitting the rate limit violations times within window, or by an operator
+// adding them; either way they come off once their TTL is up.
+type quarantineList struct {
+	violations int
+	window     time.Duration
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	until   map[string]time.Time
+	strikes map[string][]time.Time
+	now     func() time.Time
+}
+
+type quarantinedClient struct {
+	IP    string `json:"ip"`
+	Until string `json:"until"`
+}
+
+// newQuarantineList leaves automatic quarantining off when violations is
+// zero; clients can still be quarantined by hand.
+func newQuarantineList(violations int, window, ttl time.Duration) *quarantineList {
+	quarantinedClients.set(0)
+	return &quarantineList{
+		violations: violations,
+		window:     window,
+		ttl:        ttl,
+		until:      make(map[string]time.Time),
+		strikes:    make(map[string][]time.Time),
+		now:        time.Now,
+	}
+}
+
+// violation records a rate limit violation by ip and quarantines it once
+// there have been enough of them within the window.
+func (q *quarantineList) violation(ip string) {
+	if q == nil || q.violations <= 0 {
+		return
+	}
+	ip, _ = quarantineKey(ip)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if until, ok := q.until[ip]; ok && until.After(now) {
+		return
+	}
+	strikes := append(recentStrikes(q.strikes[ip], now.Add(-q.window)), now)
+	if len(strikes) < q.violations {
+		q.strikes[ip] = strikes
+		return
+	}
+
+	delete(q.strikes, ip)
+	q.until[ip] = now.Add(q.ttl)
+	quarantinedClients.set(float64(len(q.until)))
+	log.Printf("Client %s quarantined for %v after %d rate limit violations within %v", ip, q.ttl, len(strikes), q.window)
+}
+
+// quarantineKey is the form a client IP is quarantined under, so a client
+// matches its entry however its address is written: an IPv4-mapped IPv6
+// address is keyed as the IPv4 address and a zone is dropped. It reports
+// false, and returns ip unchanged, when ip isn't an IP address.
+func quarantineKey(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip, false
+	}
+	return addr.Unmap().WithZone("").String(), true
+}
+
+func recentStrikes(strikes []time.Time, since time.Time) []time.Time {
+	for i, t := range strikes {
+		if t.After(since) {
+			return strikes[i:]
+		}
+	}
+	return strikes[:0]
+}
+
+func (q *quarantineList) add(ip string, ttl time.Duration) {
+	ip, _ = quarantineKey(ip)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.until[ip] = q.now().Add(ttl)
+	quarantinedClients.set(float64(len(q.until)))
+}
+
+// remove takes ip off the list, reporting false if it wasn't on it.
+func (q *quarantineList) remove(ip string) bool {
+	ip, _ = quarantineKey(ip)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.until[ip]
+	delete(q.until, ip)
+	delete(q.strikes, ip)
+	quarantinedClients.set(float64(len(q.until)))
+	return ok
+}
+
+// remaining returns how much longer ip stays quarantined.
+func (q *quarantineList) remaining(ip string) (time.Duration, bool) {
+	ip, _ = quarantineKey(ip)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	until, ok := q.until[ip]
+	if !ok {
+		return 0, false
+	}
+	left := until.Sub(q.now())
+	if left <= 0 {
+		delete(q.until, ip)
+		quarantinedClients.set(float64(len(q.until)))
+		return 0, false
+	}
+	return left, true
+}
+
+// purge drops expired quarantines and strikes that have left the window,
+// for clients that never come back to have them dropped on lookup.
+func (q *quarantineList) purge() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for ip, until := range q.until {
+		if !until.After(now) {
+			delete(q.until, ip)
+		}
+	}
+	for ip, strikes := range q.strikes {
+		if strikes = recentStrikes(strikes, now.Add(-q.window)); len(strikes) == 0 {
+			delete(q.strikes, ip)
+		} else {
+			q.strikes[ip] = strikes
+		}
+	}
+	quarantinedClients.set(float64(len(q.until)))
+}
+
+func (q *quarantineList) list() []quarantinedClient {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	clients := make([]quarantinedClient, 0, len(q.until))
+	for ip, until := range q.until {
+		if until.After(now) {
+			clients = append(clients, quarantinedClient{IP: ip, Until: formatTimestamp(until)})
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].IP < clients[j].IP })
+	return clients
+}
+
+// quarantineMiddleware turns quarantined clients away with a 429 before
+// they reach the handler, Retry-After telling them when the quarantine
+// ends. Probe and admin routes don't use it, so a quarantine can't lock
+// out a load balancer or the operator who would lift it.
+func quarantineMiddleware(q *quarantineList) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if q == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if left, ok := q.remaining(requestInfo(r.Context()).ClientIP); ok {
+				quarantineRejections.inc()
+				overloadHandler(w, r, http.StatusTooManyRequests, left, "Client is quarantined")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// quarantineAddHandler quarantines the ip query parameter, for the ttl
+// parameter if given and QUARANTINE_TTL otherwise.
+func quarantineAddHandler(w http.ResponseWriter, r *http.Request) {
+	ip, ok := quarantineKey(r.URL.Query().Get("ip"))
+	if !ok {
+		errorHandler(w, r, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+
+	ttl := quarantine.ttl
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			errorHandler(w, r, http.StatusBadRequest, "ttl must be a positive duration")
+			return
+		}
+		ttl = parsed
+	}
+
+	quarantine.add(ip, ttl)
+	auditLog(r, "quarantine "+ip+" for "+ttl.String())
+	quarantineStatusHandler(w, r, "Client quarantined")
+}
+
+func quarantineRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	ip, ok := quarantineKey(r.URL.Query().Get("ip"))
+	if !ok {
+		errorHandler(w, r, http.StatusBadRequest, "ip must be an IP address")
+		return
+	}
+	if !quarantine.remove(ip) {
+		errorHandler(w, r, http.StatusNotFound, "Client is not quarantined: "+ip)
+		return
+	}
+
+	auditLog(r, "unquarantine "+ip)
+	quarantineStatusHandler(w, r, "Client released from quarantine")
+}
+
+func quarantineStatusHandler(w http.ResponseWriter, r *http.Request, message string) {
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"message":     message,
+		"quarantined": quarantine.list(),
+		"request_id":  requestInfo(r.Context()).ID,
+		"timestamp":   formatTimestamp(time.Now()),
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuarantineAfterViolations(t *testing.T) {
+	captureLog(t)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	q := newQuarantineList(3, time.Minute, 10*time.Minute)
+	q.now = clock.now
+
+	// Strikes that fall out of the window don't count.
+	q.violation("203.0.113.1")
+	clock.advance(45 * time.Second)
+	q.violation("203.0.113.1")
+	clock.advance(30 * time.Second)
+	q.violation("203.0.113.1")
+	if _, ok := q.remaining("203.0.113.1"); ok {
+		t.Fatal("quarantined with only 2 violations inside the window")
+	}
+	q.violation("203.0.113.1")
+	left, ok := q.remaining("203.0.113.1")
+	if !ok || left != 10*time.Minute {
+		t.Fatalf("remaining = %v, %v after 3 violations within a minute; want 10m", left, ok)
+	}
+	if _, ok := q.remaining("203.0.113.2"); ok {
+		t.Error("another client was quarantined")
+	}
+
+	clock.advance(10*time.Minute - time.Second)
+	if _, ok := q.remaining("203.0.113.1"); !ok {
+		t.Error("quarantine lifted before its TTL")
+	}
+	clock.advance(time.Second)
+	if _, ok := q.remaining("203.0.113.1"); ok {
+		t.Error("quarantine still in effect after its TTL")
+	}
+}
+
+func TestQuarantineDisabledByDefault(t *testing.T) {
+	q := newQuarantineList(0, time.Minute, time.Minute)
+	for range 100 {
+		q.violation("203.0.113.1")
+	}
+	if _, ok := q.remaining("203.0.113.1"); ok {
+		t.Error("quarantined with QUARANTINE_VIOLATIONS unset")
+	}
+	var nilList *quarantineList
+	nilList.violation("203.0.113.1")
+}
+
+func TestQuarantinePurge(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	q := newQuarantineList(5, time.Minute, time.Minute)
+	q.now = clock.now
+	q.add("203.0.113.1", time.Minute)
+	q.add("203.0.113.2", time.Hour)
+	q.violation("203.0.113.3")
+
+	clock.advance(2 * time.Minute)
+	q.purge()
+	if len(q.until) != 1 || len(q.strikes) != 0 {
+		t.Errorf("after purge: %d quarantined, %d with strikes; want 1 and 0", len(q.until), len(q.strikes))
+	}
+	if !strings.Contains(metricsOutput(t), "quarantined_clients 1\n") {
+		t.Error("quarantined_clients gauge not updated by purge")
+	}
+}
+
+func TestQuarantineRateLimitedClient(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.RateLimitRPS = 20
+	config.RateLimitBurst = 1
+	config.QuarantineViolations = 2
+	config.QuarantineTTL = time.Minute
+	handler := newTestServer(t, config)
+	clock := &fakeClock{t: time.Now()}
+	quarantine.now = clock.now
+
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, want := range []int{200, 429, 429} {
+		if rec := serve("/", "203.0.113.1:1234"); rec.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+	// Rate limiting runs first, so wait for a token to see the quarantine
+	// turn the client away on its own.
+	time.Sleep(60 * time.Millisecond)
+	rec := serve("/", "203.0.113.1:1234")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "Client is quarantined") {
+		t.Fatalf("after 2 violations: status = %d %s, want the quarantine 429", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want the 60s left", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if rec := serve("/health", "203.0.113.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("probe from a quarantined client = %d, want 200", rec.Code)
+	}
+	if rec := serve("/", "203.0.113.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("another client = %d, want 200", rec.Code)
+	}
+
+	clock.advance(time.Minute)
+	time.Sleep(60 * time.Millisecond)
+	if rec := serve("/", "203.0.113.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("after the TTL: status = %d %s, want 200", rec.Code, rec.Body)
+	}
+}
+
+func TestQuarantineAdminEndpoints(t *testing.T) {
+	logs := captureLog(t)
+	config := loadConfig()
+	config.AdminToken = "admin-secret"
+	config.QuarantineTTL = time.Minute
+	handler := newTestServer(t, config)
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{
+		"/admin/quarantine/add",
+		"/admin/quarantine/add?ip=not-an-ip",
+		"/admin/quarantine/add?ip=203.0.113.1&ttl=soon",
+		"/admin/quarantine/add?ip=203.0.113.1&ttl=-1m",
+		"/admin/quarantine/remove?ip=bad",
+	} {
+		if rec := post(path); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", path, rec.Code)
+		}
+	}
+
+	rec := post("/admin/quarantine/add?ip=203.0.113.1&ttl=90s")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ip":"203.0.113.1"`) {
+		t.Fatalf("add = %d %s", rec.Code, rec.Body)
+	}
+	if left, ok := quarantine.remaining("203.0.113.1"); !ok || left > 90*time.Second || left < 89*time.Second {
+		t.Errorf("remaining = %v, %v; want the 90s asked for", left, ok)
+	}
+	if !strings.Contains(metricsOutput(t), "quarantined_clients 1\n") {
+		t.Error("quarantined_clients gauge not updated")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.1:1234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("quarantined client = %d, want 429", rec.Code)
+	}
+
+	if rec := post("/admin/quarantine/remove?ip=203.0.113.1"); rec.Code != http.StatusOK {
+		t.Errorf("remove = %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("/admin/quarantine/remove?ip=203.0.113.1"); rec.Code != http.StatusNotFound {
+		t.Errorf("second remove = %d, want 404", rec.Code)
+	}
+
+	out := logs.String()
+	for _, want := range []string{"AUDIT - Action: quarantine 203.0.113.1 for 1m30s |", "AUDIT - Action: unquarantine 203.0.113.1 |"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %q", want)
+		}
+	}
+}
+
+func TestQuarantineNormalizesAddresses(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.AdminToken = "admin-secret"
+	config.QuarantineTTL = time.Minute
+	handler := newTestServer(t, config)
+
+	serve := func(method, path, remoteAddr string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct{ admin, remoteAddr string }{
+		{"203.0.113.5", "[::ffff:203.0.113.5]:1234"},
+		{"::ffff:203.0.113.6", "203.0.113.6:1234"},
+		{"fe80::1", "[fe80::1%eth0]:1234"},
+		{"2001:DB8::1", "[2001:db8::1]:1234"},
+	}
+	for _, tt := range tests {
+		if code := serve(http.MethodPost, "/admin/quarantine/add?ip="+tt.admin, "192.0.2.1:1"); code != http.StatusOK {
+			t.Fatalf("quarantining %s = %d", tt.admin, code)
+		}
+		if code := serve(http.MethodGet, "/", tt.remoteAddr); code != http.StatusTooManyRequests {
+			t.Errorf("client %s after quarantining %s = %d, want 429", tt.remoteAddr, tt.admin, code)
+		}
+		if code := serve(http.MethodPost, "/admin/quarantine/remove?ip="+tt.admin, "192.0.2.1:1"); code != http.StatusOK {
+			t.Errorf("releasing %s = %d, want 200", tt.admin, code)
+		}
+	}
+
+	// Violations by a mapped address count against the plain one.
+	q := newQuarantineList(2, time.Minute, time.Minute)
+	q.violation("::ffff:203.0.113.9")
+	q.violation("203.0.113.9")
+	if _, ok := q.remaining("203.0.113.9"); !ok {
+		t.Error("violations under both forms of one address didn't add up to a quarantine")
+	}
+	if !q.remove("::ffff:203.0.113.9") {
+		t.Error("the quarantine couldn't be lifted by the mapped address")
+	}
+}
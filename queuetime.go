@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseRequestStart reads the time a load balancer received the request from
+// an X-Request-Start style header. It accepts "t=<epoch>" or a bare epoch,
+// in seconds with an optional fraction (nginx's $msec) or as an integer in
+// seconds, milliseconds or microseconds, told apart by magnitude.
+func parseRequestStart(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(value, "t=")
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if strings.Contains(value, ".") {
+		secs, err := strconv.ParseFloat(value, 64)
+		if err != nil || secs <= 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(secs*float64(time.Second))), true
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case n >= 1e15:
+		return time.UnixMicro(n), true
+	case n >= 1e12:
+		return time.UnixMilli(n), true
+	default:
+		return time.Unix(n, 0), true
+	}
+}
+
+// queueTimeField returns the log field for time spent between the load
+// balancer receiving the request and the server starting on it. A missing or
+// malformed header, or one from a clock ahead of ours, yields nothing.
+func queueTimeField(r *http.Request, header string, start time.Time) string {
+	if header == "" {
+		return ""
+	}
+
+	received, ok := parseRequestStart(r.Header.Get(header))
+	if !ok || received.After(start) {
+		return ""
+	}
+	return " | QueueTime: " + start.Sub(received).String()
+}
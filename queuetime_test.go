@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRequestStart(t *testing.T) {
+	want := time.UnixMilli(1700000000123)
+	for _, value := range []string{"t=1700000000123", "1700000000123", "t=1700000000.123", "t=1700000000123000"} {
+		got, ok := parseRequestStart(value)
+		if !ok || got.Sub(want).Abs() > time.Microsecond {
+			t.Errorf("parseRequestStart(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	if got, ok := parseRequestStart("t=1700000000"); !ok || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("seconds: got %v, %v", got, ok)
+	}
+	for _, value := range []string{"", "t=", "t=abc", "t=-5", "0", "t=1.2.3"} {
+		if _, ok := parseRequestStart(value); ok {
+			t.Errorf("parseRequestStart(%q) accepted a malformed value", value)
+		}
+	}
+}
+
+func TestQueueTimeLogged(t *testing.T) {
+	logs := captureLog(t)
+	config := &Config{RequestStartHeader: "X-Request-Start"}
+	h := loggingMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(time.Now().Add(-250*time.Millisecond).UnixMilli(), 10))
+	h(httptest.NewRecorder(), req)
+
+	line := logs.String()
+	i := strings.Index(line, "QueueTime: ")
+	if i < 0 {
+		t.Fatalf("no queue time logged:\n%s", line)
+	}
+	fields := strings.Fields(line[i+len("QueueTime: "):])
+	if d, err := time.ParseDuration(fields[0]); err != nil || d < 250*time.Millisecond || d > 5*time.Second {
+		t.Errorf("QueueTime = %q, want about 250ms", fields[0])
+	}
+
+	for _, value := range []string{"t=garbage", "t=" + strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)} {
+		logs := captureLog(t)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Start", value)
+		h(httptest.NewRecorder(), req)
+		if strings.Contains(logs.String(), "QueueTime") {
+			t.Errorf("X-Request-Start %q produced a queue time:\n%s", value, logs)
+		}
+		if !strings.Contains(logs.String(), "Request completed") {
+			t.Errorf("X-Request-Start %q broke request logging", value)
+		}
+	}
+}
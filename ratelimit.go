@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// slowStartInitialFraction is the share of the configured rate allowed at
+// the very start of a slow-start ramp.
+const slowStartInitialFraction = 0.1
+
+// rateLimits is the rate limiter when RATE_LIMIT_RPS is set. It is set up
+// with the routes.
+var rateLimits *rateLimiter
+
+var rateLimitRequests = metrics.counter("rate_limit_requests_total",
+	"Requests checked by the rate limiter, by tenant and result.", "tenant", "result")
+
+// rateLimiter is a per-client token bucket. Requests carrying a tenant get a
+// bucket per tenant, sized by that tenant's override if it has one, so one
+// busy tenant can't use up another's quota; everything else is limited per
+// client IP. With a slow start configured the refill rate and burst ramp
+// linearly from a fraction of the limit up to the full limit, so a cold
+// instance isn't hit with full traffic straight away. The ramp starts when
+// the server becomes ready; until then the initial fraction applies.
+type rateLimiter struct {
+	mu        sync.Mutex
+	defaults  rateLimit
+	tenants   map[string]rateLimit
+	rampStart time.Time
+	rampFor   time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// rateLimit is a refill rate in requests per second and a burst size.
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+func newRateLimit(rate float64, burst int) rateLimit {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return rateLimit{rate: rate, burst: float64(burst)}
+}
+
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, slowStart time.Duration, tenants map[string]rateLimit) *rateLimiter {
+	return &rateLimiter{
+		defaults:  newRateLimit(rate, burst),
+		tenants:   tenants,
+		rampFor:   slowStart,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// startRamp starts the slow-start ramp, if it hasn't started already.
+func (l *rateLimiter) startRamp() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rampStart.IsZero() {
+		l.rampStart = l.now()
+	}
+}
+
+// limits returns the rate and burst of base in effect at the given time.
+func (l *rateLimiter) limits(base rateLimit, now time.Time) (float64, float64) {
+	if l.rampFor <= 0 {
+		return base.rate, base.burst
+	}
+
+	var progress float64
+	if !l.rampStart.IsZero() {
+		progress = float64(now.Sub(l.rampStart)) / float64(l.rampFor)
+	}
+	if progress >= 1 {
+		return base.rate, base.burst
+	}
+	if progress < 0 {
+		progress = 0
+	}
+
+	factor := slowStartInitialFraction + (1-slowStartInitialFraction)*progress
+	return base.rate * factor, math.Max(1, base.burst*factor)
+}
+
+// limitFor returns the key to bucket r under and the limit that applies.
+func (l *rateLimiter) limitFor(r *http.Request) (string, rateLimit) {
+	info := requestInfo(r.Context())
+	tenant := info.Tenant
+	if tenant == "" {
+		return "ip:" + info.ClientIP, l.defaults
+	}
+	if limit, ok := l.tenants[tenant]; ok {
+		return "tenant:" + tenant, limit
+	}
+	return "tenant:" + tenant, l.defaults
+}
+
+func (l *rateLimiter) allow(key string, base rateLimit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rate, burst := l.limits(base, now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.limit = base
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets clients and tenants whose bucket would have refilled
+// completely, since a fresh bucket behaves the same.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		rate, burst := l.limits(b.limit, now)
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) retryAfter(base rateLimit) time.Duration {
+	rate, _ := l.limits(base, l.now())
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// metricsTenant keeps the tenant label bounded: only tenants with their own
+// limit are named.
+func (l *rateLimiter) metricsTenant(r *http.Request) string {
+	tenant := requestInfo(r.Context()).Tenant
+	if tenant == "" {
+		return ""
+	}
+	if _, ok := l.tenants[tenant]; ok {
+		return tenant
+	}
+	return "other"
+}
+
+// rateLimitMiddleware limits requests through limiter, except high-priority
+// ones: the route's priority, or the one named in header, is checked first.
+func rateLimitMiddleware(limiter *rateLimiter, header string, route requestPriority) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if limiter == nil || (route == priorityHigh && header == "") {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if priorityOf(r, header, route) == priorityHigh {
+				next(w, r)
+				return
+			}
+
+			key, limit := limiter.limitFor(r)
+			if !limiter.allow(key, limit) {
+				rateLimitRequests.inc(limiter.metricsTenant(r), "limited")
+				quarantine.violation(requestInfo(r.Context()).ClientIP)
+				overloadHandler(w, r, http.StatusTooManyRequests, limiter.retryAfter(limit), "Rate limit exceeded")
+				return
+			}
+			rateLimitRequests.inc(limiter.metricsTenant(r), "allowed")
+
+			next(w, r)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// allowedOver counts how many requests a single client gets through when it
+// sends one every 10ms for a second, starting at start.
+func allowedOver(l *rateLimiter, key string, start time.Time) int {
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		l.now = func() time.Time { return now }
+		if l.allow(key, l.defaults) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+// readyAt installs l as the server's limiter and marks the server ready at
+// the given time, which starts l's slow-start ramp.
+func readyAt(t *testing.T, l *rateLimiter, at time.Time) {
+	t.Helper()
+	prev, state := rateLimits, currentLifecycle()
+	t.Cleanup(func() {
+		rateLimits = prev
+		setLifecycle(state)
+	})
+	rateLimits = l
+	l.now = func() time.Time { return at }
+	setLifecycle(stateReady)
+}
+
+func TestRateLimiterSlowStartRamp(t *testing.T) {
+	l := newRateLimiter(50, 50, 10*time.Second, nil)
+	start := time.Now().Add(time.Hour)
+
+	// Long after the limiter was made, but before the server is ready, the
+	// ramp hasn't begun.
+	starting := allowedOver(l, "ip:s", start.Add(-time.Minute))
+	readyAt(t, l, start)
+	atStart := allowedOver(l, "ip:a", start)
+	midway := allowedOver(l, "ip:b", start.Add(5*time.Second))
+	afterRamp := allowedOver(l, "ip:c", start.Add(10*time.Second))
+
+	// 10% of the limit: a burst of 5 plus 990ms of refill at 5/s.
+	if starting != 10 {
+		t.Errorf("allowed per second = %d before the server was ready, want the initial fraction's 10", starting)
+	}
+	if !(atStart < midway && midway < afterRamp) {
+		t.Errorf("allowed per second = %d at start, %d midway, %d after the ramp; want it to increase", atStart, midway, afterRamp)
+	}
+	// The full burst of 50 plus 990ms of refill at 50/s.
+	if afterRamp != 99 {
+		t.Errorf("after the ramp %d requests allowed, want the full limit's 99", afterRamp)
+	}
+}
+
+func TestRateLimiterLimitsDuringRamp(t *testing.T) {
+	l := newRateLimiter(100, 200, 10*time.Second, nil)
+	base := l.defaults
+	start := time.Now()
+	readyAt(t, l, start)
+
+	tests := []struct {
+		at    time.Duration
+		rate  float64
+		burst float64
+	}{
+		{0, 10, 20},
+		{5 * time.Second, 55, 110},
+		{10 * time.Second, 100, 200},
+		{time.Minute, 100, 200},
+	}
+	for _, tt := range tests {
+		rate, burst := l.limits(base, start.Add(tt.at))
+		if math.Abs(rate-tt.rate) > 1e-9 || math.Abs(burst-tt.burst) > 1e-9 {
+			t.Errorf("at %v: limits = (%v, %v), want (%v, %v)", tt.at, rate, burst, tt.rate, tt.burst)
+		}
+	}
+}
+
+func TestRateLimiterWithoutSlowStart(t *testing.T) {
+	l := newRateLimiter(50, 50, 0, nil)
+	if got := allowedOver(l, "ip:a", time.Now()); got != 99 {
+		t.Errorf("%d requests allowed, want 99 with no ramp", got)
+	}
+}
+
+// tenantRequests sends n requests from remoteAddr naming tenant, which may
+// be empty, and returns their status codes.
+func tenantRequests(handler http.Handler, remoteAddr string, n int, tenant func(i int) string) []int {
+	codes := make([]int, n)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if t := tenant(i); t != "" {
+			req.Header.Set("X-Tenant-ID", t)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes[i] = rec.Code
+	}
+	return codes
+}
+
+func tenantRateLimitConfig() *Config {
+	config := loadConfig()
+	config.RateLimitRPS = 0.001
+	config.RateLimitBurst = 2
+	config.RateLimitTenants = []string{"acme:0.001:4"}
+	config.TenantHeader = "X-Tenant-ID"
+	config.TrustedProxies = []string{"10.0.0.1"}
+	return config
+}
+
+// rateLimitCount reads one series of rate_limit_requests_total.
+func rateLimitCount(t *testing.T, tenant, result string) int {
+	t.Helper()
+	series := fmt.Sprintf(`rate_limit_requests_total{tenant="%s",result="%s"} `, tenant, result)
+	m := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(series) + `(\d+)$`).FindStringSubmatch(metricsOutput(t))
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func TestRateLimiterIsolatesTenants(t *testing.T) {
+	captureLog(t)
+	handler := newTestServer(t, tenantRateLimitConfig())
+	// Tenants without their own limit are counted together as "other".
+	type series struct{ tenant, result string }
+	counted := map[series]int{{"acme", "allowed"}: 4, {"acme", "limited"}: 1, {"other", "limited"}: 1}
+	before := map[series]int{}
+	for s := range counted {
+		before[s] = rateLimitCount(t, s.tenant, s.result)
+	}
+	tenant := func(name string) func(int) string { return func(int) string { return name } }
+
+	// Both tenants come through the same gateway, so only their tenant
+	// tells them apart.
+	globex := tenantRequests(handler, "10.0.0.1:1234", 3, tenant("globex"))
+	if !slices.Equal(globex, []int{200, 200, 429}) {
+		t.Errorf("globex statuses = %v, want its burst of 2 then 429", globex)
+	}
+	acme := tenantRequests(handler, "10.0.0.1:1234", 5, tenant("acme"))
+	if !slices.Equal(acme, []int{200, 200, 200, 200, 429}) {
+		t.Errorf("acme statuses = %v, want its own burst of 4 then 429", acme)
+	}
+	other := tenantRequests(handler, "10.0.0.1:1234", 1, tenant("initech"))
+	if other[0] != http.StatusOK {
+		t.Errorf("a third tenant got %d after the others were limited, want 200", other[0])
+	}
+
+	for s, want := range counted {
+		if got := rateLimitCount(t, s.tenant, s.result) - before[s]; got != want {
+			t.Errorf("rate_limit_requests_total{tenant=%q,result=%q} went up by %d, want %d", s.tenant, s.result, got, want)
+		}
+	}
+}
+
+func TestRateLimiterIgnoresUntrustedTenants(t *testing.T) {
+	captureLog(t)
+	handler := newTestServer(t, tenantRateLimitConfig())
+
+	// A client naming a new tenant on every request still has one bucket,
+	// and naming a tenant with a larger limit doesn't get it that limit.
+	rotating := tenantRequests(handler, "203.0.113.9:1234", 3, func(i int) string { return fmt.Sprintf("tenant-%d", i) })
+	if !slices.Equal(rotating, []int{200, 200, 429}) {
+		t.Errorf("rotating tenant statuses = %v, want the per-IP burst of 2 then 429", rotating)
+	}
+	borrowed := tenantRequests(handler, "203.0.113.10:1234", 3, func(int) string { return "acme" })
+	if !slices.Equal(borrowed, []int{200, 200, 429}) {
+		t.Errorf("borrowed tenant statuses = %v, want the per-IP burst of 2 then 429", borrowed)
+	}
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	l := newRateLimiter(10, 10, 0, map[string]rateLimit{"acme": newRateLimit(1, 5)})
+	start := l.now()
+	l.now = func() time.Time { return start }
+	l.allow("tenant:acme", l.tenants["acme"])
+	l.allow("ip:a", l.defaults)
+
+	// 200ms refills the default bucket but not acme's slower one.
+	now := start.Add(200 * time.Millisecond)
+	l.now = func() time.Time { return now }
+	l.sweep(now)
+	if _, ok := l.buckets["ip:a"]; ok {
+		t.Error("a refilled bucket was kept")
+	}
+	if _, ok := l.buckets["tenant:acme"]; !ok {
+		t.Error("a bucket still refilling was evicted")
+	}
+
+	now = start.Add(time.Hour)
+	l.sweep(now)
+	if len(l.buckets) != 0 {
+		t.Errorf("%d buckets left after an idle hour, want 0", len(l.buckets))
+	}
+}
+
+func TestRateLimiterExemptsProbesAdminAndHighPriority(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.RateLimitRPS = 0.001
+	config.RateLimitBurst = 1
+	config.AdminToken = "admin-secret"
+	config.PriorityHeader = "X-Priority"
+	handler := newTestServer(t, config)
+
+	serve := func(method, path string, header ...string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Use up the client's burst, so the limiter is turning it away.
+	serve(http.MethodGet, "/")
+	if code := serve(http.MethodGet, "/"); code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d once the burst was used, want 429", code)
+	}
+
+	for _, path := range []string{"/health", "/livez", "/readyz"} {
+		if code := serve(http.MethodGet, path); code != http.StatusOK {
+			t.Errorf("%s = %d while the client is limited, want 200", path, code)
+		}
+	}
+	if code := serve(http.MethodPost, "/admin/resume", "Authorization", "Bearer admin-secret"); code != http.StatusOK {
+		t.Errorf("/admin/resume = %d while the client is limited, want 200", code)
+	}
+	if code := serve(http.MethodGet, "/", "X-Priority", "high"); code != http.StatusOK {
+		t.Errorf("high-priority request = %d while the client is limited, want 200", code)
+	}
+	if code := serve(http.MethodGet, "/", "X-Priority", "low"); code != http.StatusTooManyRequests {
+		t.Errorf("low-priority request = %d, want it still limited with 429", code)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeReadyFile creates the readiness sentinel for tooling that watches the
+// filesystem rather than probing HTTP. It goes through a temporary file and a
+// rename so a watcher never sees it half-written. Failures are logged only:
+// the sentinel is a convenience and not worth refusing to serve over.
+func writeReadyFile(path string) {
+	if path == "" {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Printf("Could not write ready file %s: %v", path, err)
+		return
+	}
+	_, err = tmp.WriteString(formatTimestamp(time.Now()) + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Could not write ready file %s: %v", path, err)
+		return
+	}
+
+	log.Printf("Wrote ready file %s", path)
+}
+
+// removeReadyFile deletes the sentinel once the server stops being ready.
+func removeReadyFile(path string) {
+	if path == "" {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Could not remove ready file %s: %v", path, err)
+	}
+}
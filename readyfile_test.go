@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyFileWrittenAndRemoved(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ready")
+
+	writeReadyFile(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ready file not written: %v", err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil || time.Since(ts) > time.Minute {
+		t.Errorf("ready file holds %q, want the current timestamp", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	removeReadyFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("ready file still there after removal: %v", err)
+	}
+	// Removing it again, as a second drain would, is not an error.
+	logs := captureLog(t)
+	removeReadyFile(path)
+	if logs.String() != "" {
+		t.Errorf("removing a missing ready file logged: %s", logs)
+	}
+}
+
+func TestReadyFileErrorsAreLogged(t *testing.T) {
+	logs := captureLog(t)
+	writeReadyFile(filepath.Join(t.TempDir(), "missing", "ready"))
+	if !strings.Contains(logs.String(), "Could not write ready file") {
+		t.Errorf("write failure not logged: %s", logs)
+	}
+}
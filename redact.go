@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	defaultRedactFields  = []string{"password", "token", "secret", "api_key", "access_token"}
+)
+
+// redactor blanks out credentials before request data is written anywhere
+// outside the process. Header and field names are matched case-insensitively.
+type redactor struct {
+	headers map[string]bool
+	fields  map[string]bool
+
+	// Bodies without a structure to parse are redacted by pattern: quoted
+	// and bare values after "field=" or "field:", and, in XML, the text of
+	// elements named after a field.
+	quoted, bare *regexp.Regexp
+	elements     []*regexp.Regexp
+}
+
+func newRedactor(headers, fields []string) *redactor {
+	rd := &redactor{headers: make(map[string]bool), fields: make(map[string]bool)}
+	for _, h := range headers {
+		rd.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	var names []string
+	for _, f := range fields {
+		rd.fields[strings.ToLower(f)] = true
+		names = append(names, regexp.QuoteMeta(f))
+		rd.elements = append(rd.elements, regexp.MustCompile(
+			`(?is)(<(?:[\w.-]+:)?`+regexp.QuoteMeta(f)+`(?:\s[^>]*)?>)(?:[^<]+|<!\[CDATA\[.*?\]\]>)*(</(?:[\w.-]+:)?`+regexp.QuoteMeta(f)+`\s*>)`))
+	}
+	if len(names) > 0 {
+		field := `(?i)\b(` + strings.Join(names, "|") + `)(["']?\s*[:=]\s*)`
+		rd.quoted = regexp.MustCompile(field + `(["'])[^"']*["']`)
+		rd.bare = regexp.MustCompile(field + `[^\s"'&,;<>]+`)
+	}
+	return rd
+}
+
+func (rd *redactor) header(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		if rd.headers[http.CanonicalHeaderKey(name)] {
+			out[name] = []string{redacted}
+			continue
+		}
+		out[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+func (rd *redactor) query(rawQuery string) string {
+	out, ok := rd.form(rawQuery)
+	if !ok {
+		return redacted
+	}
+	return out
+}
+
+// form redacts matching fields of URL-encoded data, reporting false if it
+// doesn't parse.
+func (rd *redactor) form(data string) (string, bool) {
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return "", false
+	}
+	for name := range values {
+		if rd.fields[strings.ToLower(name)] {
+			values[name] = []string{redacted}
+		}
+	}
+	return values.Encode(), true
+}
+
+// body redacts a request body of the given media type. It reports false
+// when it can't, because the type is neither one it parses nor XML or text,
+// or the body doesn't parse as its type, and the body must then not be
+// written out.
+func (rd *redactor) body(mediaType string, body []byte) ([]byte, bool) {
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return rd.jsonBody(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		out, ok := rd.form(string(body))
+		return []byte(out), ok
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		for _, re := range rd.elements {
+			body = re.ReplaceAll(body, []byte("${1}"+redacted+"${2}"))
+		}
+		return rd.text(body), true
+	case strings.HasPrefix(mediaType, "text/"):
+		return rd.text(body), true
+	}
+	return nil, false
+}
+
+// text redacts the values of "field=value" and "field: value" pairs, the
+// form credentials take in log lines, config snippets and XML attributes.
+func (rd *redactor) text(body []byte) []byte {
+	if rd.quoted == nil {
+		return body
+	}
+	body = rd.quoted.ReplaceAll(body, []byte("${1}${2}${3}"+redacted+"${3}"))
+	return rd.bare.ReplaceAll(body, []byte("${1}${2}"+redacted))
+}
+
+// jsonBody redacts matching fields at any depth of a JSON document,
+// reporting false if body isn't valid JSON.
+func (rd *redactor) jsonBody(body []byte) ([]byte, bool) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, false
+	}
+
+	out, err := json.Marshal(rd.value(doc))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (rd *redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if rd.fields[strings.ToLower(key)] {
+				v[key] = redacted
+				continue
+			}
+			v[key] = rd.value(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rd.value(child)
+		}
+	}
+	return v
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedactorBody(t *testing.T) {
+	rd := newRedactor(nil, []string{"password", "Token"})
+	tests := []struct {
+		mediaType, body string
+		want            string
+		ok              bool
+	}{
+		{"application/json", `[{"PASSWORD":"x"},{"n":1}]`, `[{"PASSWORD":"[REDACTED]"},{"n":1}]`, true},
+		{"application/json", `not json`, "", false},
+		{"application/problem+json", `{"token":"x","title":"t"}`, `{"title":"t","token":"[REDACTED]"}`, true},
+		{"application/vnd.api+json", `{"token"`, "", false},
+		{"application/x-www-form-urlencoded", "token=x&a=b", "a=b&token=%5BREDACTED%5D", true},
+		{"application/x-www-form-urlencoded", "a=%", "", false},
+		{"text/plain", "user=a password=x", "user=a password=[REDACTED]", true},
+		{"text/plain", `token: "a b" next`, `token: "[REDACTED]" next`, true},
+		{"text/plain", `{"Password": "x"}`, `{"Password": "[REDACTED]"}`, true},
+		{"text/plain", "passwords are long", "passwords are long", true},
+		{"application/xml", `<login><ns:password kind="p">x</ns:password><user>a</user></login>`,
+			`<login><ns:password kind="p">[REDACTED]</ns:password><user>a</user></login>`, true},
+		{"text/xml", `<a token='t'><password><![CDATA[x<y]]></password></a>`,
+			`<a token='[REDACTED]'><password>[REDACTED]</password></a>`, true},
+		{"application/soap+xml", "<Token>x</Token>", "<Token>[REDACTED]</Token>", true},
+		{"image/png", "password=x", "", false},
+		{"", "{}", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := rd.body(tt.mediaType, []byte(tt.body))
+		if ok != tt.ok || string(got) != tt.want {
+			t.Errorf("body(%q, %q) = %q, %v; want %q, %v", tt.mediaType, tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRedactorHeaderAndQuery(t *testing.T) {
+	rd := newRedactor([]string{"authorization"}, []string{"api_key"})
+
+	h := rd.header(http.Header{"Authorization": {"Bearer x"}, "Accept": {"*/*"}})
+	if h["Authorization"][0] != redacted || h["Accept"][0] != "*/*" {
+		t.Errorf("header = %v", h)
+	}
+	if got := rd.query("api_key=x&q=1"); got != "api_key=%5BREDACTED%5D&q=1" {
+		t.Errorf("query = %q", got)
+	}
+	if got := rd.query("api_key=%zz"); got != redacted {
+		t.Errorf("unparseable query = %q, want it redacted whole", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// redirectCorrelationMaxAge is how long, in seconds, the correlation cookie
+// lives: long enough for a client to follow the redirect.
+const redirectCorrelationMaxAge = 60
+
+// redirectCorrelationMiddleware carries the request ID of a redirect to the
+// request that follows it, either as the name query parameter on the
+// Location or as a short-lived name cookie, so the two log entries can be
+// tied together. The follow-up's RequestInfo.RedirectedFrom is set from it
+// and the parameter is taken off the URL again before routing. This covers
+// every redirect, including the ones net/http's ServeMux sends for
+// unclean paths and missing trailing slashes. A mode other than "query" or
+// "cookie" leaves redirects alone.
+func redirectCorrelationMiddleware(mode, name string) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		switch {
+		case name == "" || mode == "":
+			return next
+		case mode != "query" && mode != "cookie":
+			log.Printf("Invalid REDIRECT_CORRELATION %q, redirects won't be correlated", mode)
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			info := requestInfo(r.Context())
+
+			switch mode {
+			case "query":
+				query := r.URL.Query()
+				if v := query.Get(name); v != "" {
+					info.RedirectedFrom, _ = strconv.ParseUint(v, 10, 64)
+					query.Del(name)
+					r.URL.RawQuery = query.Encode()
+				}
+			case "cookie":
+				if c, err := r.Cookie(name); err == nil {
+					info.RedirectedFrom, _ = strconv.ParseUint(c.Value, 10, 64)
+					http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+				}
+			}
+
+			next(&redirectCorrelationWriter{ResponseWriter: w, mode: mode, name: name, id: info.ID}, r)
+		}
+	}
+}
+
+type redirectCorrelationWriter struct {
+	http.ResponseWriter
+	mode string
+	name string
+	id   uint64
+	done bool
+}
+
+func (rw *redirectCorrelationWriter) WriteHeader(status int) {
+	if !rw.done && status >= 300 && status < 400 {
+		rw.tag(status)
+	}
+	if status >= 200 {
+		rw.done = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *redirectCorrelationWriter) Write(p []byte) (int, error) {
+	rw.done = true
+	return rw.ResponseWriter.Write(p)
+}
+
+// tag marks the redirect's Location with the request ID and logs it, since
+// the mux's own redirects never reach the logging middleware. Redirects to
+// other hosts keep their Location as it is; the ID is only useful to this
+// server's logs.
+func (rw *redirectCorrelationWriter) tag(status int) {
+	location := rw.Header().Get("Location")
+	if location == "" || rw.id == 0 {
+		return
+	}
+	target, err := url.Parse(location)
+	if err != nil || target.Host != "" || strings.HasPrefix(location, "//") {
+		return
+	}
+
+	id := strconv.FormatUint(rw.id, 10)
+	switch rw.mode {
+	case "query":
+		query := target.Query()
+		query.Set(rw.name, id)
+		target.RawQuery = query.Encode()
+		rw.Header().Set("Location", target.String())
+	case "cookie":
+		http.SetCookie(rw.ResponseWriter, &http.Cookie{
+			Name:     rw.name,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   redirectCorrelationMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	accessLog.printf("[%d] Redirecting - Status: %d | Location: %s", rw.id, status, rw.Header().Get("Location"))
+}
+
+func (rw *redirectCorrelationWriter) Flush() {
+	rw.done = true
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *redirectCorrelationWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// followRedirect requests an unclean path, which ServeMux redirects, and
+// follows the redirect. It returns the redirect's Location and the final
+// response.
+func followRedirect(t *testing.T, mode string) (string, *http.Response) {
+	t.Helper()
+	config := loadConfig()
+	config.RedirectCorrelation = mode
+	ts := httptest.NewServer(newTestServer(t, config))
+	t.Cleanup(ts.Close)
+
+	jar, _ := cookiejar.New(nil)
+	var location string
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		location = req.Response.Header.Get("Location")
+		return nil
+	}}
+	resp, err := client.Get(ts.URL + "/x/../health?verbose=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || location == "" {
+		t.Fatalf("status = %d after redirect to %q, want 200 after one", resp.StatusCode, location)
+	}
+	return location, resp
+}
+
+// assertCorrelated checks that the redirect and its follow-up were logged
+// and share the redirect's request ID.
+func assertCorrelated(t *testing.T, logs *logBuffer, location string) {
+	t.Helper()
+	out := logs.String()
+	m := regexp.MustCompile(`\[(\d+)\] Redirecting - Status: 30\d \| Location: `).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("redirect not logged in:\n%s", out)
+	}
+	id := m[1]
+	if !strings.Contains(out, "Location: "+location) {
+		t.Errorf("logged Location differs from the sent %q:\n%s", location, out)
+	}
+	follow := regexp.MustCompile(`\[(\d+)\] Incoming request - Method: GET \| Path: /health \|.* RedirectedFrom: ` + id + `\b`)
+	m = follow.FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("follow-up not logged with RedirectedFrom: %s in:\n%s", id, out)
+	}
+	if m[1] == id {
+		t.Errorf("follow-up logged with the redirect's own request ID %s", id)
+	}
+}
+
+func TestRedirectCorrelationQuery(t *testing.T) {
+	logs := captureLog(t)
+	location, _ := followRedirect(t, "query")
+	if !strings.Contains(location, "redirected_from=") || !strings.Contains(location, "verbose=1") {
+		t.Errorf("Location = %q, want the original query plus redirected_from", location)
+	}
+	assertCorrelated(t, logs, location)
+}
+
+func TestRedirectCorrelationCookie(t *testing.T) {
+	logs := captureLog(t)
+	location, resp := followRedirect(t, "cookie")
+	if strings.Contains(location, "redirected_from") {
+		t.Errorf("Location = %q, want it untouched in cookie mode", location)
+	}
+	assertCorrelated(t, logs, location)
+
+	// The follow-up clears the cookie so later requests aren't tied to the
+	// redirect.
+	cleared := false
+	for _, c := range resp.Cookies() {
+		if c.Name == "redirected_from" && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Errorf("follow-up didn't clear the correlation cookie: %q", resp.Header.Values("Set-Cookie"))
+	}
+}
+
+func TestRedirectCorrelationStripsParameter(t *testing.T) {
+	captureLog(t)
+	var query string
+	h := redirectCorrelationMiddleware("query", "redirected_from")(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	})
+	info := &RequestInfo{ID: 8}
+	req := httptest.NewRequest(http.MethodGet, "/health?redirected_from=7&verbose=1", nil)
+	h(httptest.NewRecorder(), withRequestInfo(req, info))
+	if query != "verbose=1" || info.RedirectedFrom != 7 {
+		t.Errorf("query = %q, RedirectedFrom = %d, want verbose=1 and 7", query, info.RedirectedFrom)
+	}
+}
+
+func TestRedirectCorrelationLeavesOtherHostsAlone(t *testing.T) {
+	captureLog(t)
+	h := redirectCorrelationMiddleware("query", "redirected_from")(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://other.example/login", http.StatusFound)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, withRequestInfo(httptest.NewRequest(http.MethodGet, "/", nil), &RequestInfo{ID: 3}))
+	if got := rec.Header().Get("Location"); got != "https://other.example/login" {
+		t.Errorf("Location = %q, want the other host's unchanged", got)
+	}
+}
+
+func TestRedirectCorrelationDisabled(t *testing.T) {
+	logs := captureLog(t)
+	next := func(w http.ResponseWriter, r *http.Request) {}
+	for _, mode := range []string{"", "header"} {
+		h := redirectCorrelationMiddleware(mode, "redirected_from")(next)
+		req := httptest.NewRequest(http.MethodGet, "/?redirected_from=7", nil)
+		info := &RequestInfo{}
+		h(httptest.NewRecorder(), withRequestInfo(req, info))
+		if info.RedirectedFrom != 0 {
+			t.Errorf("mode %q: RedirectedFrom = %d, want correlation off", mode, info.RedirectedFrom)
+		}
+	}
+	if !strings.Contains(logs.String(), `Invalid REDIRECT_CORRELATION "header"`) {
+		t.Errorf("invalid mode not logged in:\n%s", logs)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// currentConfig is the configuration in effect. Most settings are wired into
+// the server once at startup; the reloadable ones are read from here so a
+// reload reaches them. Request handling reads them through requestConfig,
+// which holds each request to the configuration it started under.
+var currentConfig atomic.Pointer[Config]
+
+func activeConfig() *Config {
+	return currentConfig.Load()
+}
+
+// reloadableFields are the Config fields a reload applies. Changes to any
+// other field are reported as needing a restart.
+var reloadableFields = []string{"TLSCertFile", "TLSKeyFile", "CORSAllowedOrigins"}
+
+// envFile tracks the variables set from ENV_FILE. The environment of a
+// running process can't be changed from outside, so a file is the way to
+// get new values to a reload.
+var envFile struct {
+	mu       sync.Mutex
+	original map[string]*string
+}
+
+// applyEnvFile sets the variables listed in path, overriding the process
+// environment. Variables set by an earlier call but no longer in the file
+// get their original values back.
+func applyEnvFile(path string) error {
+	envFile.mu.Lock()
+	defer envFile.mu.Unlock()
+
+	values := map[string]string{}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				return fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+			}
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			values[key] = value
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if envFile.original == nil {
+		envFile.original = map[string]*string{}
+	}
+	for key, orig := range envFile.original {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if orig == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *orig)
+		}
+		delete(envFile.original, key)
+	}
+	for key, value := range values {
+		if _, tracked := envFile.original[key]; !tracked {
+			if orig, ok := os.LookupEnv(key); ok {
+				envFile.original[key] = &orig
+			} else {
+				envFile.original[key] = nil
+			}
+		}
+		os.Setenv(key, value)
+	}
+	return nil
+}
+
+type fieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type reloadResult struct {
+	Changed         map[string]fieldChange
+	RequiresRestart []string
+	Certificate     string
+}
+
+// reloadMu serialises reloads from SIGHUP and the admin endpoint.
+var reloadMu sync.Mutex
+
+// reloadConfig re-reads ENV_FILE and the environment and applies the
+// reloadable fields. The serving certificate is always re-read when TLS is
+// on, so certificates rotated in place are picked up too. A certificate
+// that fails to load leaves the whole configuration as it was.
+func reloadConfig() (reloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := activeConfig()
+	if err := applyEnvFile(os.Getenv("ENV_FILE")); err != nil {
+		return reloadResult{}, fmt.Errorf("could not read ENV_FILE: %w", err)
+	}
+	loaded := loadConfig()
+
+	result := reloadResult{Changed: map[string]fieldChange{}, RequiresRestart: []string{}}
+	next := *old
+
+	oldValue, loadedValue, nextValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(loaded).Elem(), reflect.ValueOf(&next).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		before, after := oldValue.Field(i).Interface(), loadedValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if !slices.Contains(reloadableFields, name) {
+			result.RequiresRestart = append(result.RequiresRestart, name)
+			continue
+		}
+		result.Changed[name] = fieldChange{Old: before, New: after}
+		nextValue.Field(i).Set(loadedValue.Field(i))
+	}
+
+	// Turning TLS on or off changes how the listener is served.
+	if next.TLSEnabled() != old.TLSEnabled() {
+		for _, name := range []string{"TLSCertFile", "TLSKeyFile"} {
+			if _, ok := result.Changed[name]; ok {
+				delete(result.Changed, name)
+				result.RequiresRestart = append(result.RequiresRestart, name)
+			}
+		}
+		next.TLSCertFile, next.TLSKeyFile = old.TLSCertFile, old.TLSKeyFile
+	}
+	slices.Sort(result.RequiresRestart)
+
+	if next.TLSEnabled() {
+		if err := certificates.load(next.TLSCertFile, next.TLSKeyFile); err != nil {
+			return reloadResult{}, fmt.Errorf("could not load TLS certificate: %w", err)
+		}
+		result.Certificate = "reloaded"
+	}
+
+	currentConfig.Store(&next)
+	log.Printf("Configuration reloaded - Changed: %d | Requires restart: %v", len(result.Changed), result.RequiresRestart)
+	return result, nil
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	auditLog(r, "reload")
+
+	result, err := reloadConfig()
+	if err != nil {
+		log.Printf("[%v] Configuration reload failed: %v", requestInfo(r.Context()).ID, err)
+		errorHandler(w, r, http.StatusUnprocessableEntity, "Reload failed: "+err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":           "success",
+		"message":          "Configuration reloaded",
+		"changed":          result.Changed,
+		"requires_restart": result.RequiresRestart,
+		"request_id":       requestInfo(r.Context()).ID,
+		"timestamp":        formatTimestamp(time.Now()),
+	}
+	if result.Certificate != "" {
+		response["certificate"] = result.Certificate
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
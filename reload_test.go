@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// postReload calls the reload endpoint through the full handler.
+func postReload(t *testing.T, handler http.Handler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminReload(t *testing.T) {
+	logs := captureLog(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://old.example")
+	handler := newTestServer(t, loadConfig())
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://new.example,https://other.example")
+	t.Setenv("PORT", "9999")
+	rec := postReload(t, handler, "admin-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var body struct {
+		Changed         map[string]fieldChange `json:"changed"`
+		RequiresRestart []string               `json:"requires_restart"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	change, ok := body.Changed["CORSAllowedOrigins"]
+	if !ok || len(body.Changed) != 1 {
+		t.Fatalf("changed = %v, want only CORSAllowedOrigins", body.Changed)
+	}
+	if got, _ := json.Marshal(change); string(got) != `{"old":["https://old.example"],"new":["https://new.example","https://other.example"]}` {
+		t.Errorf("CORSAllowedOrigins change = %s", got)
+	}
+	if !slices.Equal(body.RequiresRestart, []string{"Port"}) {
+		t.Errorf("requires_restart = %v, want [Port]", body.RequiresRestart)
+	}
+
+	current := activeConfig()
+	if !slices.Equal(current.CORSAllowedOrigins, []string{"https://new.example", "https://other.example"}) {
+		t.Errorf("CORSAllowedOrigins = %v after reload", current.CORSAllowedOrigins)
+	}
+	if current.Port == "9999" {
+		t.Error("a restart-only field was applied by the reload")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Origin", "https://new.example")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://new.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the reloaded origin", got)
+	}
+
+	if !strings.Contains(logs.String(), "AUDIT - Action: reload |") {
+		t.Errorf("no audit record for the reload in:\n%s", logs)
+	}
+}
+
+func TestAdminReloadRequiresAdminToken(t *testing.T) {
+	captureLog(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("API_TOKEN", "api-secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://old.example")
+	handler := newTestServer(t, loadConfig())
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://new.example")
+	for _, token := range []string{"", "wrong", "api-secret"} {
+		if rec := postReload(t, handler, token); rec.Code != http.StatusUnauthorized && rec.Code != http.StatusForbidden {
+			t.Errorf("token %q: status = %d, want it refused", token, rec.Code)
+		}
+	}
+	if got := activeConfig().CORSAllowedOrigins; !slices.Equal(got, []string{"https://old.example"}) {
+		t.Errorf("CORSAllowedOrigins = %v after refused reloads", got)
+	}
+}
+
+func TestAdminReloadKeepsConfigOnError(t *testing.T) {
+	captureLog(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://old.example")
+	handler := newTestServer(t, loadConfig())
+
+	envFile := filepath.Join(t.TempDir(), "server.env")
+	if err := os.WriteFile(envFile, []byte("CORS_ALLOWED_ORIGINS=https://new.example\nnot a setting\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENV_FILE", envFile)
+	t.Cleanup(func() { applyEnvFile("") })
+
+	rec := postReload(t, handler, "admin-secret")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "expected KEY=VALUE") {
+		t.Errorf("status = %d, body = %s; want 422 naming the bad line", rec.Code, rec.Body)
+	}
+	if got := activeConfig().CORSAllowedOrigins; !slices.Equal(got, []string{"https://old.example"}) {
+		t.Errorf("CORSAllowedOrigins = %v after a failed reload", got)
+	}
+}
+
+func TestAdminReloadFromEnvFile(t *testing.T) {
+	captureLog(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://old.example")
+	envFile := filepath.Join(t.TempDir(), "server.env")
+	t.Setenv("ENV_FILE", envFile)
+	t.Cleanup(func() { applyEnvFile("") })
+	if err := os.WriteFile(envFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	handler := newTestServer(t, loadConfig())
+
+	if err := os.WriteFile(envFile, []byte("export CORS_ALLOWED_ORIGINS=\"https://file.example\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postReload(t, handler, "admin-secret"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := activeConfig().CORSAllowedOrigins; !slices.Equal(got, []string{"https://file.example"}) {
+		t.Errorf("CORSAllowedOrigins = %v, want the ENV_FILE value", got)
+	}
+
+	// Dropping the line from the file restores the process environment.
+	if err := os.WriteFile(envFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postReload(t, handler, "admin-secret"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := activeConfig().CORSAllowedOrigins; !slices.Equal(got, []string{"https://old.example"}) {
+		t.Errorf("CORSAllowedOrigins = %v, want the environment value back", got)
+	}
+}
+
+func TestReloadDuringSlowRequest(t *testing.T) {
+	captureLog(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://old.example")
+	config := loadConfig()
+	server := newTestServer(t, config)
+
+	// A slow request through the same front layers as the server's chain,
+	// holding until the reload is done.
+	started, reloaded := make(chan struct{}), make(chan struct{})
+	var seen []string
+	slow := chain(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-reloaded
+		seen = requestConfig(r.Context()).CORSAllowedOrigins
+	}, requestInfoMiddleware(config), corsMiddleware)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", "https://old.example")
+		rec := httptest.NewRecorder()
+		slow(rec, req)
+		done <- rec
+	}()
+
+	<-started
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://new.example")
+	if rec := postReload(t, server, "admin-secret"); rec.Code != http.StatusOK {
+		t.Fatalf("reload: status = %d: %s", rec.Code, rec.Body)
+	}
+	close(reloaded)
+	rec := <-done
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://old.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the old origin set at the start", got)
+	}
+	if len(seen) != 1 || seen[0] != "https://old.example" {
+		t.Errorf("handler saw CORS_ALLOWED_ORIGINS %q after the reload, want the old snapshot", seen)
+	}
+
+	// The next request starts under the reloaded configuration.
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Origin", "https://new.example")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://new.example" {
+		t.Errorf("after the reload: Access-Control-Allow-Origin = %q, want the new origin", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestInfo holds the values that tie a request's log lines, metrics and
+// responses together. requestInfoMiddleware stores one per request in the
+// context under "requestInfo"; middleware further in fills in what it
+// learns (the tenant, the experiment variant) on the same struct, so every
+// later reader sees it without another context lookup.
+type RequestInfo struct {
+	ID       uint64
+	TraceID  string
+	ClientIP string
+	Tenant   string
+	Variant  string
+
+	// RedirectedFrom is the ID of the redirect that led to this request,
+	// when redirects are correlated.
+	RedirectedFrom uint64
+
+	// Error is the detail of a 5xx response, as logged or sent to the
+	// client.
+	Error string
+
+	// config is the configuration in effect when the request arrived.
+	config *Config
+}
+
+// requestInfo returns the request's RequestInfo, or an empty one for
+// contexts that never went through requestInfoMiddleware.
+func requestInfo(ctx context.Context) *RequestInfo {
+	if info, ok := ctx.Value("requestInfo").(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{}
+}
+
+// requestConfig returns the configuration the request started under, so
+// every layer of one request sees the same values even if a reload swaps
+// the configuration partway through. Outside a request it is the current
+// one.
+func requestConfig(ctx context.Context) *Config {
+	if config := requestInfo(ctx).config; config != nil {
+		return config
+	}
+	return activeConfig()
+}
+
+// requestInfoMiddleware assigns the request ID, snapshots the configuration
+// and records the client IP and, with tracing on, the trace ID. It runs
+// ahead of everything that logs.
+func requestInfoMiddleware(config *Config) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			info := &RequestInfo{
+				ID:       atomic.AddUint64(&requestIDCounter, 1),
+				ClientIP: clientIP(r),
+				config:   activeConfig(),
+			}
+			if config.TracingEnabled {
+				info.TraceID = traceIDFromRequest(r)
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), "requestInfo", info)))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestInfoPopulated(t *testing.T) {
+	captureLog(t)
+	config := &Config{TracingEnabled: true}
+	prev := currentConfig.Load()
+	currentConfig.Store(config)
+	t.Cleanup(func() { currentConfig.Store(prev) })
+
+	proxies := parseTrustedProxies([]string{"10.0.0.1"})
+	var seen []*RequestInfo
+	h := chain(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, requestInfo(r.Context()))
+	},
+		requestInfoMiddleware(config),
+		forwardedMiddleware(proxies),
+		experimentMiddleware(newExperiment([]string{"treatment:100"}, "client_ip")),
+		tenantMiddleware("X-Tenant-ID", proxies),
+	)
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.Header.Set("X-Tenant-ID", "acme")
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		h(httptest.NewRecorder(), req)
+	}
+
+	info := seen[0]
+	if info.ID == 0 {
+		t.Error("ID not assigned")
+	}
+	if info.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %q", info.TraceID)
+	}
+	if info.ClientIP != "203.0.113.9" {
+		t.Errorf("ClientIP = %q, want the forwarded client", info.ClientIP)
+	}
+	if info.Tenant != "acme" {
+		t.Errorf("Tenant = %q", info.Tenant)
+	}
+	if info.Variant != "treatment" {
+		t.Errorf("Variant = %q", info.Variant)
+	}
+	if info.config != config {
+		t.Error("config not snapshotted")
+	}
+	if seen[1].ID <= info.ID {
+		t.Errorf("request IDs %d then %d, want them increasing", info.ID, seen[1].ID)
+	}
+}
+
+func TestRequestInfoWithoutTracing(t *testing.T) {
+	var info *RequestInfo
+	h := requestInfoMiddleware(&Config{})(func(w http.ResponseWriter, r *http.Request) {
+		info = requestInfo(r.Context())
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h(httptest.NewRecorder(), req)
+
+	if info.TraceID != "" {
+		t.Errorf("TraceID = %q with tracing off, want none", info.TraceID)
+	}
+	if info.ClientIP != "192.0.2.1" {
+		t.Errorf("ClientIP = %q, want the peer address", info.ClientIP)
+	}
+}
+
+func TestRequestConfigIsSnapshotted(t *testing.T) {
+	first, second := &Config{Port: "1"}, &Config{Port: "2"}
+	prev := currentConfig.Load()
+	currentConfig.Store(first)
+	t.Cleanup(func() { currentConfig.Store(prev) })
+
+	var before, after *Config
+	h := requestInfoMiddleware(first)(func(w http.ResponseWriter, r *http.Request) {
+		before = requestConfig(r.Context())
+		currentConfig.Store(second)
+		after = requestConfig(r.Context())
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if before != first || after != first {
+		t.Error("a reload during the request changed the configuration it sees")
+	}
+	if requestConfig(context.Background()) != second {
+		t.Error("outside a request, requestConfig isn't the current configuration")
+	}
+}
+
+func TestRequestInfoOutsideRequest(t *testing.T) {
+	info := requestInfo(context.Background())
+	if info == nil || info.ID != 0 || info.ClientIP != "" {
+		t.Errorf("requestInfo = %+v, want an empty RequestInfo", info)
+	}
+}
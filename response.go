@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"iter"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// responseCharset is appended to the Content-Type of JSON and text
+// responses. An empty value leaves the media type bare.
+var responseCharset = "utf-8"
+
+func contentType(mediaType string) string {
+	if responseCharset == "" {
+		return mediaType
+	}
+	return mediaType + "; charset=" + responseCharset
+}
+
+// writeJSON is where JSON responses are encoded, alongside streamJSONArray
+// for collections too large to buffer. Values echoed from the request, such
+// as the path, are escaped by encoding/json: quotes and control characters
+// can't break out of their string, "<", ">" and "&" are written as \u
+// escapes, and invalid UTF-8 is replaced rather than passed through.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		log.Printf("[%v] Could not encode response: %v", requestInfo(r.Context()).ID, err)
+		status = http.StatusInternalServerError
+		buf.Reset()
+		buf.WriteString(`{"status":"error","message":"Internal server error"}` + "\n")
+	}
+
+	setJSONHeaders(w, r)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func setJSONHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType("application/json"))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if id := requestInfo(r.Context()).ID; id != 0 {
+		w.Header().Set("X-Request-ID", strconv.FormatUint(id, 10))
+	}
+}
+
+// jsonStreamFlushEvery is how many array elements streamJSONArray writes
+// between flushes.
+const jsonStreamFlushEvery = 100
+
+// streamJSONArray writes items as a JSON array one element at a time, so
+// the collection is never held in memory as a whole. The status goes out
+// before the first element, so an error partway through can't change it:
+// the array is closed where it stands, keeping the body valid JSON, and
+// the error is logged. Check the source for errors before calling if a
+// failure should still produce an error status.
+func streamJSONArray[T any](w http.ResponseWriter, r *http.Request, status int, items iter.Seq2[T, error]) {
+	rc := http.NewResponseController(w)
+	requestID := requestInfo(r.Context()).ID
+
+	setJSONHeaders(w, r)
+	w.WriteHeader(status)
+	io.WriteString(w, "[")
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	n := 0
+	for item, err := range items {
+		if err == nil {
+			err = r.Context().Err()
+		}
+		if err == nil {
+			buf.Reset()
+			err = enc.Encode(item)
+		}
+		if err != nil {
+			log.Printf("[%v] JSON stream stopped after %d items: %v", requestID, n, err)
+			break
+		}
+
+		if n > 0 {
+			io.WriteString(w, ",")
+		}
+		if _, err := w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))); err != nil {
+			log.Printf("[%v] JSON stream stopped after %d items: %v", requestID, n, err)
+			return
+		}
+		n++
+		if n%jsonStreamFlushEvery == 0 {
+			rc.Flush()
+		}
+	}
+
+	io.WriteString(w, "]\n")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWriteJSONEscapesEchoedValues(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, map[string]string{
+		"path": "/\"}<script>&\u2028\xff",
+	})
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	if strings.ContainsAny(body, "<>&\u2028") || !utf8.ValidString(body) {
+		t.Errorf("body has unescaped characters: %s", body)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body is not valid JSON: %v", err)
+	}
+	if want := "/\"}<script>&\u2028\ufffd"; got["path"] != want {
+		t.Errorf("path = %q, want %q", got["path"], want)
+	}
+}
+
+func TestWriteJSONEncodeFailure(t *testing.T) {
+	captureLog(t)
+	rec := httptest.NewRecorder()
+	writeJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, map[string]interface{}{"bad": make(chan int)})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("fallback body is not valid JSON: %s", rec.Body)
+	}
+}
+
+// FuzzJSONResponses sends arbitrary paths, queries and header values
+// through the full handler and checks that every JSON response is valid,
+// correctly typed and echoes the path as data rather than markup.
+func FuzzJSONResponses(f *testing.F) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(out) })
+	handler := newTestServer(f, loadConfig())
+
+	f.Add("/", "", "")
+	f.Add("/health", "depth=deep", "curl/8.0")
+	f.Add("/missing/\"}{\"injected\":true", "a=<b>&c=\"d\"", "x\"y")
+	f.Add("/café/ /\U0001F600", "n=1e400", "é")
+	f.Add("/\xff\xfe", "q=%ff", "\x7f")
+	f.Add("/a%2Fb", "x=9007199254740993", "")
+
+	f.Fuzz(func(t *testing.T, path, query, header string) {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(header, "\r\n\x00") {
+			return
+		}
+		u := &url.URL{Path: path, RawQuery: url.Values{"q": {query}}.Encode()}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL, req.RequestURI = u, u.RequestURI()
+		req.Header.Set("User-Agent", header)
+		req.Header.Set("Accept", "application/json")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		ct := rec.Header().Get("Content-Type")
+		if !strings.HasPrefix(ct, "application/json") {
+			if rec.Code == http.StatusNotFound {
+				t.Fatalf("404 for %q is %q, not JSON", path, ct)
+			}
+			return
+		}
+		if ct != "application/json; charset=utf-8" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		body := rec.Body.Bytes()
+		if !json.Valid(body) || !utf8.Valid(body) {
+			t.Fatalf("invalid JSON for %q: %s", path, body)
+		}
+		if bytes.ContainsAny(body, "<>") {
+			t.Fatalf("unescaped markup in %s", body)
+		}
+		if bytes.Count(body, []byte("\n")) != 1 {
+			t.Fatalf("response is not a single JSON line: %q", body)
+		}
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("response is not a JSON object: %s", body)
+		}
+		// encoding/json replaces each invalid byte with U+FFFD, as
+		// ranging over the string does.
+		if echoed, ok := resp["path"]; ok && echoed != strings.Map(func(r rune) rune { return r }, path) {
+			t.Fatalf("path echoed as %q, want %q", echoed, path)
+		}
+		if _, ok := resp["injected"]; ok {
+			t.Fatalf("request data became a response field: %s", body)
+		}
+	})
+}
+
+func TestResponseCharset(t *testing.T) {
+	t.Cleanup(func() { responseCharset = "utf-8" })
+	for _, tt := range []struct{ charset, want string }{
+		{"utf-8", "application/json; charset=utf-8"},
+		{"iso-8859-1", "application/json; charset=iso-8859-1"},
+		{"", "application/json"},
+	} {
+		responseCharset = tt.charset
+		for name, h := range map[string]http.HandlerFunc{"main": mainHandler, "not found": notFoundHandler} {
+			rec := httptest.NewRecorder()
+			h(rec, withRequestInfo(httptest.NewRequest(http.MethodGet, "/", nil), &RequestInfo{config: loadConfig()}))
+			if got := rec.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("%s handler with charset %q: Content-Type = %q, want %q", name, tt.charset, got, tt.want)
+			}
+		}
+	}
+}
+
+// flushRecorder counts the flushes a handler asks for and what had been
+// written at each one.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.flushedAt = append(fr.flushedAt, fr.Body.Len())
+	fr.ResponseRecorder.Flush()
+}
+
+func count(n int, failAt int, err error) iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		for i := range n {
+			if i == failAt {
+				yield(0, err)
+				return
+			}
+			if !yield(i, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	const items = 2*jsonStreamFlushEvery + 50
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	streamJSONArray(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, count(items, -1, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var got []int
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body is not a JSON array: %v", err)
+	}
+	if len(got) != items || got[items-1] != items-1 {
+		t.Errorf("decoded %d items, want %d", len(got), items)
+	}
+	if len(rec.flushedAt) != items/jsonStreamFlushEvery {
+		t.Errorf("flushed %d times, want %d", len(rec.flushedAt), items/jsonStreamFlushEvery)
+	}
+	for i := 1; i < len(rec.flushedAt); i++ {
+		if rec.flushedAt[i] <= rec.flushedAt[i-1] {
+			t.Errorf("flush %d wrote nothing new", i)
+		}
+	}
+}
+
+func TestStreamJSONArrayEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	streamJSONArray(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, count(0, -1, nil))
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("body = %q, want an empty array", body)
+	}
+}
+
+func TestStreamJSONArrayError(t *testing.T) {
+	logs := captureLog(t)
+
+	rec := httptest.NewRecorder()
+	streamJSONArray(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, count(10, 3, errors.New("disk gone")))
+
+	var got []int
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body is not a closed JSON array after an error: %v\n%s", err, rec.Body)
+	}
+	if len(got) != 3 {
+		t.Errorf("decoded %d items, want the 3 before the error", len(got))
+	}
+	if !strings.Contains(logs.String(), "JSON stream stopped after 3 items: disk gone") {
+		t.Errorf("log = %q, want the error", logs.String())
+	}
+}
+
+func TestStreamJSONArrayStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	items := func(yield func(int, error) bool) {
+		for i := 0; ; i++ {
+			if i == 5 {
+				cancel()
+			}
+			if !yield(i, nil) {
+				return
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	streamJSONArray(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), http.StatusOK, items)
+	var got []int
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got) != 5 {
+		t.Errorf("decoded %v, %v; want the 5 items before the client left", got, err)
+	}
+}
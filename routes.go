@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// route describes one registered endpoint. The same table drives the mux
+// registration and the route introspection endpoints, so they can't drift
+// apart.
+type route struct {
+	pattern     string
+	methods     []string
+	mediaType   string
+	kind        routeKind
+	auth        authLevel
+	description string
+	handler     http.HandlerFunc
+
+	// streaming routes keep their response open, so they are exempt from
+	// the server-wide read and write timeouts and are never cached.
+	streaming bool
+
+	// plain routes serve fixed text for crawlers and scanners, so they skip
+	// everything in the server chain but recovery and logging.
+	plain bool
+
+	// deprecated routes announce their sunset and successor in response
+	// headers.
+	deprecated *deprecation
+
+	// timeout is the handler's deadline: HANDLER_TIMEOUT unless
+	// ROUTE_TIMEOUTS overrides it. Streaming routes have none.
+	timeout time.Duration
+
+	// adaptive, when set, replaces timeout with a deadline derived from
+	// the route's recent latency. Routes with a ROUTE_TIMEOUTS entry keep
+	// theirs.
+	adaptive *adaptiveTimeout
+}
+
+// routeKind groups routes by who they serve. Operational controls such as
+// pausing only apply to application routes, so probes, metrics and admin
+// endpoints keep working while they are in effect.
+type routeKind int
+
+const (
+	routeApplication routeKind = iota
+	routeProbe
+	routeOperational
+	routeAdmin
+)
+
+func routeTable(config *Config) []route {
+	probes := newProbeSources(config.HealthProbeSources, config.HealthDefaultDepth)
+	health, livez, readyz := healthHandler(probes), http.HandlerFunc(livezHandler), http.HandlerFunc(readyzHandler)
+	if config.HealthFastPath {
+		health, livez, readyz = healthFastHandler(probes), livezFastHandler, readyzFastHandler
+	}
+
+	root := route{
+		pattern:     "/",
+		mediaType:   "application/json",
+		auth:        authNone,
+		description: "Confirms the server is up and echoes the request",
+		handler:     mainHandler,
+	}
+	if config.ProxyFallbackURL != "" {
+		upstream, err := url.Parse(config.ProxyFallbackURL)
+		if err != nil || upstream.Host == "" || (upstream.Scheme != "http" && upstream.Scheme != "https") {
+			log.Fatalf("Invalid PROXY_FALLBACK_URL %q: want an http or https URL", config.ProxyFallbackURL)
+		}
+		root.mediaType = ""
+		root.description = "Forwards requests no other route matches to " + upstream.Redacted()
+		root.handler = proxyHandler(upstream, http.DefaultTransport.(*http.Transport).Clone(), newCircuitBreaker("proxy-fallback", config))
+	}
+
+	routes := []route{
+		root,
+		{
+			pattern:     "/health",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "application/json",
+			kind:        routeProbe,
+			auth:        authNone,
+			description: "Health status and uptime",
+			handler:     health,
+		},
+		{
+			pattern:     "/healthz",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "application/json",
+			kind:        routeProbe,
+			auth:        authNone,
+			description: "Alias of /health",
+			handler:     health,
+		},
+		{
+			pattern:     "/livez",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "application/json",
+			kind:        routeProbe,
+			auth:        authNone,
+			description: "Liveness: the process is serving requests",
+			handler:     livez,
+		},
+		{
+			pattern:     "/readyz",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "application/json",
+			kind:        routeProbe,
+			auth:        authNone,
+			description: "Readiness: warm-up is done and the server isn't draining",
+			handler:     readyz,
+		},
+		{
+			pattern:     "/robots.txt",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "text/plain",
+			kind:        routeOperational,
+			auth:        authNone,
+			description: "Crawler rules from ROBOTS_TXT or ROBOTS_TXT_FILE",
+			handler:     textFileHandler(textFile("robots.txt", config.RobotsTxt, config.RobotsTxtFile)),
+			plain:       true,
+		},
+		{
+			pattern:     "/.well-known/security.txt",
+			methods:     []string{http.MethodGet, http.MethodHead},
+			mediaType:   "text/plain",
+			kind:        routeOperational,
+			auth:        authNone,
+			description: "Security contact details from SECURITY_TXT or SECURITY_TXT_FILE",
+			handler:     textFileHandler(textFile("security.txt", config.SecurityTxt, config.SecurityTxtFile)),
+			plain:       true,
+		},
+	}
+
+	if config.MetricsEnabled {
+		routes = append(routes, route{
+			pattern:     "/metrics",
+			methods:     []string{http.MethodGet},
+			mediaType:   "text/plain",
+			kind:        routeOperational,
+			auth:        authNone,
+			description: "Prometheus metrics",
+			handler:     metricsHandler,
+		})
+	}
+
+	if config.AdminToken != "" {
+		routes = append(routes,
+			route{
+				pattern:     "/admin/pause",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Stop accepting application requests until resumed",
+				handler:     pauseHandler,
+			},
+			route{
+				pattern:     "/admin/resume",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Resume accepting application requests",
+				handler:     resumeHandler,
+			},
+			route{
+				pattern:     "/admin/routes/disable",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Disable the route given by the route query parameter",
+				handler:     disableRouteHandler,
+			},
+			route{
+				pattern:     "/admin/routes/enable",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Re-enable the route given by the route query parameter",
+				handler:     enableRouteHandler,
+			},
+			route{
+				pattern:     "/admin/reload",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Reload the reloadable configuration, like SIGHUP",
+				handler:     reloadHandler,
+			},
+			route{
+				pattern:     "/admin/quarantine/add",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Quarantine the client IP given by the ip query parameter",
+				handler:     quarantineAddHandler,
+			},
+			route{
+				pattern:     "/admin/quarantine/remove",
+				methods:     []string{http.MethodPost},
+				mediaType:   "application/json",
+				kind:        routeAdmin,
+				auth:        authAdmin,
+				description: "Release the client IP given by the ip query parameter from quarantine",
+				handler:     quarantineRemoveHandler,
+			},
+		)
+	}
+
+	if config.DebugEndpoints {
+		routes = append(routes, route{
+			pattern:     "/debug/collection",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/json",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Postman collection of the server's routes",
+		})
+		routes[len(routes)-1].handler = collectionHandler(postmanCollection(routes, config))
+
+		routes = append(routes, route{
+			pattern:     "/debug/events",
+			methods:     []string{http.MethodGet},
+			mediaType:   "text/event-stream",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Server-sent heartbeat events",
+			handler:     eventsHandler(config.StreamIdleTimeout),
+			streaming:   true,
+		}, route{
+			pattern:     "/debug/slow",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/json",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Most recent requests slower than the slow request threshold",
+			handler:     slowRequestsHandler(config.SlowRequestThreshold, config.SlowRequestLimit),
+		}, route{
+			pattern:     "/debug/errors",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/json",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Most recent 5xx responses per route, with the redacted request",
+			handler:     errorSamplesHandler,
+		}, route{
+			pattern:     "/debug/info",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/json",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Build, configuration, runtime and feature flag diagnostics",
+			handler:     infoHandler,
+		}, route{
+			pattern:     "/debug/routes/chain",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/json",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "Middleware wrapping each route, outermost first",
+			handler:     routeChainsHandler,
+		})
+		if config.CaptureFile != "" {
+			routes = append(routes, route{
+				pattern:     "/debug/captures",
+				methods:     []string{http.MethodGet},
+				mediaType:   "application/json",
+				kind:        routeOperational,
+				auth:        authToken,
+				description: "Requests captured to CAPTURE_FILE, streamed as a JSON array",
+				handler:     capturesHandler,
+				streaming:   true,
+			})
+		}
+	}
+
+	if config.WebSocketEcho {
+		routes = append(routes, route{
+			pattern:     "/ws/echo",
+			methods:     []string{http.MethodGet},
+			mediaType:   "application/octet-stream",
+			auth:        authNone,
+			description: "WebSocket that echoes every message back",
+			handler:     webSocketEchoHandler,
+			streaming:   true,
+		})
+	}
+
+	if config.Dashboard {
+		routes = append(routes, route{
+			pattern:     "/dashboard",
+			methods:     []string{http.MethodGet},
+			mediaType:   "text/html",
+			kind:        routeOperational,
+			auth:        authToken,
+			description: "HTML overview of server status, requests, errors and health checks",
+			handler:     dashboardHandler,
+		})
+	}
+
+	timeouts := parseRouteTimeouts(config.RouteTimeouts)
+	adaptive := newAdaptiveTimeout(config)
+	for i := range routes {
+		if routes[i].streaming {
+			continue
+		}
+		routes[i].timeout, routes[i].adaptive = config.HandlerTimeout, adaptive
+		if timeout, ok := timeouts[routes[i].pattern]; ok {
+			routes[i].timeout, routes[i].adaptive = timeout, nil
+		}
+	}
+
+	return routes
+}
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// postmanCollection renders the route table as a Postman v2.1 collection
+// with one sample request per route and method.
+func postmanCollection(routes []route, config *Config) []byte {
+	scheme := "http"
+	if config.TLSEnabled() {
+		scheme = "https"
+	}
+
+	items := make([]map[string]interface{}, 0, len(routes))
+	for _, rt := range routes {
+		methods := rt.methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		accept := rt.mediaType
+		if accept == "" {
+			accept = "*/*"
+		}
+
+		path := []string{}
+		if trimmed := strings.Trim(rt.pattern, "/"); trimmed != "" {
+			path = strings.Split(trimmed, "/")
+		}
+
+		for _, method := range methods {
+			items = append(items, map[string]interface{}{
+				"name": method + " " + rt.pattern,
+				"request": map[string]interface{}{
+					"method":      method,
+					"description": rt.description,
+					"header": []map[string]string{
+						{"key": "Accept", "value": accept},
+					},
+					"url": map[string]interface{}{
+						"raw":  "{{baseUrl}}" + rt.pattern,
+						"host": []string{"{{baseUrl}}"},
+						"path": path,
+					},
+				},
+			})
+		}
+	}
+
+	collection := map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":   "portServerT",
+			"schema": postmanSchema,
+		},
+		"item": items,
+		"variable": []map[string]string{
+			{"key": "baseUrl", "value": scheme + "://localhost:" + config.Port},
+		},
+	}
+
+	body, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		log.Printf("Could not build route collection: %v", err)
+	}
+	return body
+}
+
+func collectionHandler(collection []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType("application/json"))
+		w.Header().Set("Content-Disposition", `attachment; filename="portServerT.postman_collection.json"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(collection)
+	}
+}
+
+// methodMiddleware answers methods a route doesn't register with 405 and an
+// Allow header built from the route's own methods. OPTIONS is always listed
+// since CORS preflights are answered for every route.
+func methodMiddleware(methods []string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if len(methods) == 0 {
+			return next
+		}
+
+		allow := strings.Join(append(append([]string(nil), methods...), http.MethodOptions), ", ")
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			for _, method := range methods {
+				if r.Method == method {
+					next(w, r)
+					return
+				}
+			}
+
+			w.Header().Set("Allow", allow)
+			errorHandler(w, r, http.StatusMethodNotAllowed, "Method "+r.Method+" is not allowed")
+		}
+	}
+}
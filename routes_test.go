@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestPostmanCollectionListsRoutes(t *testing.T) {
+	config := &Config{Port: "10001", DebugEndpoints: true, MetricsEnabled: true, HealthDefaultDepth: "deep"}
+	routes := routeTable(config)
+	rec := httptest.NewRecorder()
+	collectionHandler(postmanCollection(routes, config))(rec, httptest.NewRequest(http.MethodGet, "/debug/collection", nil))
+
+	if cd := rec.Header().Get("Content-Disposition"); cd == "" {
+		t.Error("collection is not served as a download")
+	}
+
+	var collection struct {
+		Info struct {
+			Schema string `json:"schema"`
+		} `json:"info"`
+		Item []struct {
+			Name    string `json:"name"`
+			Request struct {
+				Method string `json:"method"`
+				URL    struct {
+					Raw string `json:"raw"`
+				} `json:"url"`
+			} `json:"request"`
+		} `json:"item"`
+		Variable []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"variable"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &collection); err != nil {
+		t.Fatalf("collection is not valid JSON: %v", err)
+	}
+	if collection.Info.Schema != postmanSchema {
+		t.Errorf("schema = %q, want %q", collection.Info.Schema, postmanSchema)
+	}
+
+	var names []string
+	for _, item := range collection.Item {
+		names = append(names, item.Name)
+	}
+	for _, want := range []string{"GET /", "GET /health", "HEAD /health", "GET /readyz", "GET /metrics", "GET /debug/collection", "GET /debug/info"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("collection is missing %q; has %q", want, names)
+		}
+	}
+	if len(collection.Variable) != 1 || collection.Variable[0].Value != "http://localhost:10001" {
+		t.Errorf("baseUrl variable = %+v, want http://localhost:10001", collection.Variable)
+	}
+}
+
+func TestMethodMiddlewareAllowHeader(t *testing.T) {
+	h := methodMiddleware([]string{http.MethodGet})(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/only-get", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, OPTIONS" {
+		t.Errorf("Allow = %q, want %q", got, "GET, OPTIONS")
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/only-get", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMethodMiddlewareAllowFromRouteTable(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, rt := range routeTable(&Config{HealthDefaultDepth: "deep"}) {
+		mux.HandleFunc(rt.pattern, methodMiddleware(rt.methods)(rt.handler))
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/health", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE /health status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want the route's own methods plus OPTIONS", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var scheduler = &taskScheduler{stop: make(chan struct{})}
+
+type scheduledTask struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+	running  atomic.Bool
+
+	// final tasks also run once at shutdown.
+	final bool
+}
+
+// taskScheduler runs background tasks on fixed intervals, one goroutine per
+// task, so a slow run of one task never delays another. Runs of the same
+// task never overlap: the next one is due an interval after the last ended.
+type taskScheduler struct {
+	mu      sync.Mutex
+	tasks   []*scheduledTask
+	started bool
+
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// every registers a task. Tasks registered after start are ignored.
+func (s *taskScheduler) every(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started || interval <= 0 {
+		return
+	}
+	s.tasks = append(s.tasks, &scheduledTask{name: name, interval: interval, run: run})
+}
+
+// flushEvery registers a task like every that also runs one last time at
+// shutdown, so nothing it buffers is lost.
+func (s *taskScheduler) flushEvery(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.every(name, interval, run)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.tasks); n > 0 && s.tasks[n-1].name == name {
+		s.tasks[n-1].final = true
+	}
+}
+
+func (s *taskScheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.loop(t)
+	}
+}
+
+func (s *taskScheduler) loop(t *scheduledTask) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			if t.final {
+				s.runTask(t)
+			}
+			return
+		case <-timer.C:
+		}
+
+		s.runTask(t)
+		timer.Reset(t.interval)
+	}
+}
+
+func (s *taskScheduler) runTask(t *scheduledTask) {
+	t.running.Store(true)
+	defer t.running.Store(false)
+
+	if err := t.run(s.ctx); err != nil {
+		log.Printf("Scheduled task %s failed: %v", t.name, err)
+	}
+}
+
+// running lists the tasks that are partway through a run.
+func (s *taskScheduler) running() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for _, t := range s.tasks {
+		if t.running.Load() {
+			names = append(names, t.name)
+		}
+	}
+	return names
+}
+
+// shutdown stops the scheduler. Tasks waiting for their next run stop
+// straight away, after a last run for those registered with flushEvery; a
+// task partway through a run gets up to timeout (or until
+// ctx expires) to finish it, since cancelling mid-write could leave its
+// state half updated. Only then is the context passed to the tasks
+// cancelled.
+func (s *taskScheduler) shutdown(ctx context.Context, timeout time.Duration) {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return
+	}
+
+	close(s.stop)
+	defer s.cancel()
+
+	if names := s.running(); len(names) > 0 {
+		log.Printf("Waiting for running scheduled task(s) to finish: %v", names)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Cancelling scheduled task(s) still running at shutdown: %v", s.running())
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestScheduler() *taskScheduler {
+	return &taskScheduler{stop: make(chan struct{})}
+}
+
+func TestSchedulerShutdownLetsRunningTaskFinish(t *testing.T) {
+	logs := captureLog(t)
+	s := newTestScheduler()
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	var cancelledDuringRun atomic.Bool
+	s.every("writer", 5*time.Millisecond, func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+		cancelledDuringRun.Store(ctx.Err() != nil)
+		finished.Store(true)
+		return nil
+	})
+	s.start()
+	<-started
+
+	s.shutdown(context.Background(), 5*time.Second)
+	if !finished.Load() {
+		t.Fatal("shutdown returned before the running task finished")
+	}
+	if cancelledDuringRun.Load() {
+		t.Error("the task's context was cancelled while it was finishing")
+	}
+	if !strings.Contains(logs.String(), "Waiting for running scheduled task(s) to finish: [writer]") {
+		t.Errorf("running task not logged:\n%s", logs)
+	}
+}
+
+func TestSchedulerShutdownBetweenRuns(t *testing.T) {
+	captureLog(t)
+	s := newTestScheduler()
+	var runs atomic.Int32
+	s.every("hourly", time.Hour, func(context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	s.start()
+
+	start := time.Now()
+	s.shutdown(context.Background(), 5*time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v with no task running", elapsed)
+	}
+	if n := runs.Load(); n != 0 {
+		t.Errorf("task ran %d times, want it stopped before its first run", n)
+	}
+}
+
+func TestSchedulerShutdownCancelsOverrunningTask(t *testing.T) {
+	logs := captureLog(t)
+	s := newTestScheduler()
+
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	s.every("stuck", 5*time.Millisecond, func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	s.start()
+	<-started
+
+	start := time.Now()
+	s.shutdown(context.Background(), 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v, want it bounded by the timeout", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the overrunning task's context was never cancelled")
+	}
+	if !strings.Contains(logs.String(), "Cancelling scheduled task(s) still running at shutdown: [stuck]") {
+		t.Errorf("overrunning task not logged:\n%s", logs)
+	}
+}
+
+func TestSchedulerFlushTaskRunsAtShutdown(t *testing.T) {
+	captureLog(t)
+	s := newTestScheduler()
+	var flushes, plain atomic.Int32
+	s.flushEvery("flush", time.Hour, func(context.Context) error {
+		flushes.Add(1)
+		return nil
+	})
+	s.every("plain", time.Hour, func(context.Context) error {
+		plain.Add(1)
+		return nil
+	})
+	s.start()
+	s.every("late", time.Millisecond, func(context.Context) error {
+		t.Error("a task registered after start ran")
+		return nil
+	})
+
+	s.shutdown(context.Background(), 5*time.Second)
+	if flushes.Load() != 1 || plain.Load() != 0 {
+		t.Errorf("runs at shutdown: flush %d, plain %d; want 1 and 0", flushes.Load(), plain.Load())
+	}
+}
+
+func TestSchedulerShutdownBeforeStart(t *testing.T) {
+	s := newTestScheduler()
+	s.every("never", time.Millisecond, func(context.Context) error { return nil })
+	s.shutdown(context.Background(), time.Second)
+}
+
+// TestSchedulerRunningWhileRegistering is for -race: running may be asked
+// for while tasks are still being registered.
+func TestSchedulerRunningWhileRegistering(t *testing.T) {
+	s := newTestScheduler()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			s.every(fmt.Sprintf("task-%d", i), time.Minute, func(context.Context) error { return nil })
+		}
+	}()
+	for range 100 {
+		if names := s.running(); len(names) != 0 {
+			t.Fatalf("running = %v before the scheduler started", names)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slowRequests keeps the most recent requests that took at least
+// SLOW_REQUEST_THRESHOLD. It is set up with the routes.
+var slowRequests *slowRequestLog
+
+type slowRequest struct {
+	RequestID  uint64  `json:"request_id"`
+	Method     string  `json:"method"`
+	Route      string  `json:"route"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Duration   string  `json:"duration"`
+	DurationMs float64 `json:"duration_ms"`
+	Timestamp  string  `json:"timestamp"`
+}
+
+// slowRequestLog is a fixed-size ring buffer, so a burst of slow requests
+// only ever pushes out older entries.
+type slowRequestLog struct {
+	mu      sync.Mutex
+	entries []slowRequest
+	next    int
+	full    bool
+}
+
+func newSlowRequestLog(size int) *slowRequestLog {
+	if size < 1 {
+		size = 1
+	}
+	return &slowRequestLog{entries: make([]slowRequest, size)}
+}
+
+func (sl *slowRequestLog) record(entry slowRequest) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.entries[sl.next] = entry
+	sl.next = (sl.next + 1) % len(sl.entries)
+	if sl.next == 0 {
+		sl.full = true
+	}
+}
+
+// recent returns up to n entries, newest first.
+func (sl *slowRequestLog) recent(n int) []slowRequest {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	count := sl.next
+	if sl.full {
+		count = len(sl.entries)
+	}
+	n = min(n, count)
+
+	out := make([]slowRequest, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, sl.entries[(sl.next-i+len(sl.entries))%len(sl.entries)])
+	}
+	return out
+}
+
+func recordSlowRequest(r *http.Request, requestID uint64, status int, start time.Time, duration time.Duration) {
+	if slowRequests == nil {
+		return
+	}
+	slowRequests.record(slowRequest{
+		RequestID:  requestID,
+		Method:     r.Method,
+		Route:      r.Pattern,
+		Path:       r.URL.Path,
+		Status:     status,
+		Duration:   duration.String(),
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		Timestamp:  formatTimestamp(start),
+	})
+}
+
+// slowRequestsHandler lists recent slow requests, defaultLimit of them
+// unless the limit query parameter asks for a different number.
+func slowRequestsHandler(threshold time.Duration, defaultLimit int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				errorHandler(w, r, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = n
+		}
+
+		requests := []slowRequest{}
+		if slowRequests != nil {
+			requests = slowRequests.recent(limit)
+		}
+
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"status":     "success",
+			"threshold":  threshold.String(),
+			"requests":   requests,
+			"request_id": requestInfo(r.Context()).ID,
+			"timestamp":  formatTimestamp(time.Now()),
+		})
+	}
+}
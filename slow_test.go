@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestLogKeepsNewest(t *testing.T) {
+	sl := newSlowRequestLog(3)
+	if got := sl.recent(10); len(got) != 0 {
+		t.Errorf("empty log returned %d entries", len(got))
+	}
+	for id := uint64(1); id <= 5; id++ {
+		sl.record(slowRequest{RequestID: id})
+	}
+
+	var ids []uint64
+	for _, entry := range sl.recent(10) {
+		ids = append(ids, entry.RequestID)
+	}
+	if !slices.Equal(ids, []uint64{5, 4, 3}) {
+		t.Errorf("recent = %v, want the newest 3, newest first", ids)
+	}
+	if got := sl.recent(2); len(got) != 2 || got[0].RequestID != 5 {
+		t.Errorf("recent(2) = %+v, want entries 5 and 4", got)
+	}
+}
+
+func TestLoggingRecordsSlowRequests(t *testing.T) {
+	captureLog(t)
+	prev := slowRequests
+	slowRequests = newSlowRequestLog(10)
+	t.Cleanup(func() { slowRequests = prev })
+
+	config := &Config{SlowRequestThreshold: 20 * time.Millisecond}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /slow/{id}", loggingMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("GET /fast", loggingMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), withRequestInfo(httptest.NewRequest(http.MethodGet, "/fast", nil), &RequestInfo{ID: 1}))
+	mux.ServeHTTP(httptest.NewRecorder(), withRequestInfo(httptest.NewRequest(http.MethodGet, "/slow/7", nil), &RequestInfo{ID: 2}))
+
+	got := slowRequests.recent(10)
+	if len(got) != 1 {
+		t.Fatalf("%d slow requests recorded, want only the slow one: %+v", len(got), got)
+	}
+	entry := got[0]
+	if entry.RequestID != 2 || entry.Route != "GET /slow/{id}" || entry.Path != "/slow/7" || entry.Status != http.StatusAccepted {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.DurationMs < 30 {
+		t.Errorf("duration_ms = %v, want at least 30", entry.DurationMs)
+	}
+}
+
+func TestSlowRequestsEndpoint(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.DebugEndpoints = true
+	config.APIToken = "api-secret"
+	config.SlowRequestThreshold = time.Nanosecond
+	config.SlowRequestLimit = 2
+	handler := newTestServer(t, config)
+
+	get := func(query string) (int, []slowRequest) {
+		req := httptest.NewRequest(http.MethodGet, "/debug/slow"+query, nil)
+		req.Header.Set("Authorization", "Bearer api-secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var body struct {
+			Threshold string        `json:"threshold"`
+			Requests  []slowRequest `json:"requests"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Threshold != "1ns" {
+				t.Errorf("threshold = %q, want 1ns", body.Threshold)
+			}
+		}
+		return rec.Code, body.Requests
+	}
+
+	for _, path := range []string{"/health", "/livez"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for _, limit := range []string{"0", "-1", "x"} {
+		if code, _ := get("?limit=" + limit); code != http.StatusBadRequest {
+			t.Errorf("?limit=%s: status = %d, want 400", limit, code)
+		}
+	}
+
+	code, got := get("")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(got) != 2 || got[0].Path != "/debug/slow" || got[1].Path != "/debug/slow" {
+		t.Fatalf("requests = %+v, want the newest SLOW_REQUEST_LIMIT entries", got)
+	}
+	if got[0].Status != http.StatusBadRequest || got[0].Route != "/debug/slow" || got[0].RequestID <= got[1].RequestID {
+		t.Errorf("requests = %+v, want route, status and request IDs recorded", got)
+	}
+
+	// The listing above was slow too and is now the newest.
+	_, got = get("?limit=10")
+	var paths []string
+	for _, entry := range got {
+		paths = append(paths, entry.Path)
+	}
+	if want := []string{"/debug/slow", "/debug/slow", "/debug/slow", "/debug/slow", "/livez", "/health"}; !slices.Equal(paths, want) {
+		t.Errorf("?limit=10 paths = %v, want %v", paths, want)
+	}
+	if got[0].Status != http.StatusOK {
+		t.Errorf("newest entry = %+v, want the last listing", got[0])
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// net/http follows RFC 9112 on a request carrying both Content-Length and
+// Transfer-Encoding: chunked, framing it by the chunking and dropping
+// Content-Length before any handler runs. A proxy in front may have framed
+// the same bytes by Content-Length, which is what request smuggling relies
+// on. To see the original headers, plain-text HTTP/1 connections are run
+// through framingConn, which follows the request framing on the raw bytes
+// and notes every header block that had both.
+//
+// TLS connections are encrypted at this layer and HTTP/2 has its own
+// framing, so neither is checked. Behind a TLS-terminating proxy, which is
+// where smuggling matters, the connection to the server is plain text.
+// Nor is anything after an Upgrade request, whose bytes may no longer be
+// HTTP/1 at all.
+
+const (
+	maxFramingLine   = 16 << 10
+	maxFramingBlocks = 1024
+)
+
+type framingListener struct {
+	net.Listener
+}
+
+func (l framingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &framingConn{Conn: c}, nil
+}
+
+type framingState int
+
+const (
+	framingHeaders framingState = iota
+	framingBody
+	framingChunkSize
+	framingChunkData
+	framingTrailers
+	framingOff
+)
+
+type framingConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	state     framingState
+	line      []byte
+	remaining int64
+
+	// Per header block: the request line, which length headers were
+	// present, and the body length they give.
+	requestLine string
+	header      string
+	hasLength   bool
+	hasTE       bool
+	chunked     bool
+	length      int64
+	upgrade     bool
+
+	// blocks has one entry per complete header block, in order.
+	blocks []framedRequest
+}
+
+type framedRequest struct {
+	requestLine string
+	ambiguous   bool
+}
+
+func (c *framingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.scan(p[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// ambiguous reports whether r had both Content-Length and
+// Transfer-Encoding. Header blocks ahead of r's are those of requests
+// answered before the route chain, such as OPTIONS * and the mux's
+// redirects, and are skipped.
+func (c *framingConn) ambiguous(r *http.Request) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	want := r.Method + " " + r.RequestURI + " "
+	for len(c.blocks) > 0 {
+		block := c.blocks[0]
+		c.blocks = c.blocks[1:]
+		if strings.HasPrefix(block.requestLine, want) {
+			return block.ambiguous
+		}
+	}
+	return false
+}
+
+func (c *framingConn) scan(data []byte) {
+	for len(data) > 0 && c.state != framingOff {
+		if c.state == framingBody || c.state == framingChunkData {
+			skip := min(int64(len(data)), c.remaining)
+			data = data[skip:]
+			if c.remaining -= skip; c.remaining == 0 {
+				if c.state == framingBody {
+					c.state = framingHeaders
+				} else {
+					c.state = framingChunkSize
+				}
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			c.line = append(c.line, data...)
+			if len(c.line) > maxFramingLine {
+				c.state = framingOff
+			}
+			return
+		}
+		c.line = append(c.line, data[:i]...)
+		data = data[i+1:]
+		c.scanLine(strings.TrimSuffix(string(c.line), "\r"))
+		c.line = c.line[:0]
+	}
+}
+
+func (c *framingConn) scanLine(line string) {
+	switch c.state {
+	case framingHeaders:
+		c.scanHeaderLine(line)
+	case framingChunkSize:
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		switch {
+		case err != nil || n < 0:
+			c.state = framingOff
+		case n == 0:
+			c.state = framingTrailers
+		default:
+			// The chunk's data is followed by a CRLF.
+			c.state, c.remaining = framingChunkData, n+2
+		}
+	case framingTrailers:
+		if line == "" {
+			c.state = framingHeaders
+		}
+	}
+}
+
+func (c *framingConn) scanHeaderLine(line string) {
+	if c.requestLine == "" {
+		switch {
+		case line == "":
+			// Blank lines ahead of the request line are allowed.
+		case strings.HasPrefix(line, "PRI * HTTP/2"):
+			c.state = framingOff
+		default:
+			c.requestLine = line
+		}
+		return
+	}
+
+	// A line starting with whitespace continues the header before it
+	// (obs-fold), which net/http unfolds, so hold each header back until
+	// the next line shows whether it continues.
+	if line != "" && (line[0] == ' ' || line[0] == '\t') {
+		if c.header == "" {
+			c.state = framingOff
+			return
+		}
+		if c.header += " " + strings.TrimSpace(line); len(c.header) > maxFramingLine {
+			c.state = framingOff
+		}
+		return
+	}
+	if c.header != "" {
+		c.scanHeader(c.header)
+		c.header = ""
+		if c.state == framingOff {
+			return
+		}
+	}
+	if line != "" {
+		c.header = line
+		return
+	}
+
+	c.blocks = append(c.blocks, framedRequest{requestLine: c.requestLine, ambiguous: c.hasLength && c.hasTE})
+	switch {
+	case c.upgrade || len(c.blocks) > maxFramingBlocks:
+		c.state = framingOff
+	case c.chunked:
+		c.state = framingChunkSize
+	case c.hasTE:
+		// Anything but chunked last is rejected by net/http.
+		c.state = framingOff
+	case c.hasLength && c.length > 0:
+		c.state, c.remaining = framingBody, c.length
+	}
+	c.requestLine, c.hasLength, c.hasTE, c.chunked, c.length, c.upgrade = "", false, false, false, 0, false
+}
+
+func (c *framingConn) scanHeader(header string) {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok {
+		c.state = framingOff
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(name) {
+	case "content-length":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			// net/http rejects the request and closes the connection.
+			c.state = framingOff
+			return
+		}
+		c.hasLength, c.length = true, n
+	case "upgrade":
+		c.upgrade = true
+	case "transfer-encoding":
+		c.hasTE = true
+		codings := strings.Split(value, ",")
+		c.chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+	}
+}
+
+// ambiguousLengthMiddleware rejects requests that arrived with both
+// Content-Length and Transfer-Encoding with a 400 and closes the
+// connection, since the two ends may disagree on where the request ended.
+func ambiguousLengthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ci := connInfoFromContext(r.Context())
+		if r.ProtoMajor != 1 || ci == nil || ci.framing == nil || !ci.framing.ambiguous(r) {
+			next(w, r)
+			return
+		}
+
+		log.Printf("[%d] WARNING - Possible request smuggling: request has both Content-Length and Transfer-Encoding | Method: %s | Path: %s | RemoteAddr: %s",
+			requestInfo(r.Context()).ID, r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("Connection", "close")
+		errorHandler(w, r, http.StatusBadRequest, "Request has both Content-Length and Transfer-Encoding")
+	}
+}
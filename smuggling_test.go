@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scanFraming(data ...[]byte) *framingConn {
+	c := &framingConn{}
+	for _, d := range data {
+		c.scan(d)
+	}
+	return c
+}
+
+func TestFramingAmbiguity(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []bool
+	}{
+		{"content-length", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello", []bool{false}},
+		{"chunked", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", []bool{false}},
+		{"CL.TE", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG", []bool{true}},
+		{"TE.CL", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n", []bool{true}},
+		{"duplicate CL", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello", []bool{false}},
+		{"obs-fold TE", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n", []bool{true}},
+		{"pipelined", "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 2\r\n\r\nhiGET /b HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", []bool{false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := scanFraming([]byte(tt.raw))
+			var got []bool
+			for _, b := range c.blocks {
+				got = append(got, b.ambiguous)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ambiguous per request = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramingAmbiguousMatchesRequest(t *testing.T) {
+	c := scanFraming([]byte("OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+
+	r := &http.Request{Method: http.MethodPost, RequestURI: "/a"}
+	if !c.ambiguous(r) {
+		t.Error("ambiguous(POST /a) = false, want true past the OPTIONS * block")
+	}
+	if c.ambiguous(r) {
+		t.Error("a header block was reported twice")
+	}
+}
+
+// FuzzFraming checks framingConn against net/http's own header parsing:
+// for any request net/http would accept, the first header block is flagged
+// exactly when it carries both Content-Length and Transfer-Encoding, and
+// where the reads split the bytes makes no difference.
+func FuzzFraming(f *testing.F) {
+	f.Add([]byte("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG"), uint(20))
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"), uint(3))
+
+	f.Fuzz(func(t *testing.T, data []byte, split uint) {
+		if len(data) > maxFramingLine {
+			return
+		}
+
+		whole := scanFraming(data)
+		at := int(split % uint(len(data)+1))
+		parts := scanFraming(data[:at], data[at:])
+		if !reflect.DeepEqual(whole.blocks, parts.blocks) || whole.state != parts.state {
+			t.Fatalf("split at %d changed the result: %+v vs %+v", at, whole.blocks, parts.blocks)
+		}
+
+		if _, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			return
+		}
+		tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+		if _, err := tp.ReadLine(); err != nil {
+			return
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		_, hasLength := header["Content-Length"]
+		_, hasTE := header["Transfer-Encoding"]
+		want := hasLength && hasTE
+
+		if len(whole.blocks) == 0 {
+			if want {
+				t.Fatalf("missed an ambiguous request: %q", data)
+			}
+			return
+		}
+		if got := whole.blocks[0].ambiguous; got != want {
+			t.Fatalf("ambiguous = %v, want %v for %q", got, want, data)
+		}
+		if line, _, _ := strings.Cut(string(data), "\n"); strings.TrimSuffix(line, "\r") != whole.blocks[0].requestLine {
+			t.Fatalf("request line = %q, want %q", whole.blocks[0].requestLine, line)
+		}
+	})
+}
+
+// serveFraming serves the full handler on a listener from listen, so the
+// connection wrapping is the one the server uses.
+func serveFraming(t *testing.T, reject bool) string {
+	t.Helper()
+	config := loadConfig()
+	config.Port = "0"
+	config.RejectAmbiguousLength = reject
+	ln, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newTestServer(t, config), ConnContext: connContext}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// rawRequest writes request on a new connection and reads one response.
+// With waitClose it also reports whether the server then closed the
+// connection, which takes until the deadline if it didn't.
+func rawRequest(t *testing.T, addr, request string, waitClose bool) (*http.Response, bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !waitClose {
+		return resp, false
+	}
+	_, err = br.ReadByte()
+	return resp, err == io.EOF
+}
+
+func TestAmbiguousLengthRejected(t *testing.T) {
+	logs := captureLog(t)
+	addr := serveFraming(t, true)
+
+	resp, closed := rawRequest(t, addr, "POST /smuggle HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true)
+	if resp.StatusCode != http.StatusBadRequest || !resp.Close {
+		t.Errorf("status = %d, close = %v; want 400 with Connection: close", resp.StatusCode, resp.Close)
+	}
+	if !closed {
+		t.Error("connection left open after an ambiguous request")
+	}
+	if !strings.Contains(logs.String(), "WARNING - Possible request smuggling: request has both Content-Length and Transfer-Encoding | Method: POST | Path: /smuggle") {
+		t.Errorf("no smuggling warning in:\n%s", logs)
+	}
+
+	for name, request := range map[string]string{
+		"chunked":        "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nbody\r\n0\r\n\r\n",
+		"content-length": "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody",
+	} {
+		if resp, _ := rawRequest(t, addr, request, false); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s request: status = %d, want 200", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestAmbiguousLengthAllowedWhenDisabled(t *testing.T) {
+	logs := captureLog(t)
+	addr := serveFraming(t, false)
+
+	resp, _ := rawRequest(t, addr, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", false)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d with REJECT_AMBIGUOUS_LENGTH off, want net/http's own handling", resp.StatusCode)
+	}
+	if strings.Contains(logs.String(), "Possible request smuggling") {
+		t.Error("smuggling warning logged with the check off")
+	}
+}
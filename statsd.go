@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// statsdMaxPacket keeps datagrams under a typical MTU, so they aren't
+	// fragmented on the way to the agent.
+	statsdMaxPacket = 1432
+
+	// statsdMaxTimings caps the observations buffered between flushes.
+	// Beyond it further observations are dropped until the next flush.
+	statsdMaxTimings = 10000
+)
+
+// statsd pushes the metrics registry to a StatsD agent when STATSD_ADDR is
+// set. It is nil otherwise, and every method is a no-op on nil.
+var statsd *statsdExporter
+
+// statsdExporter sends the registry's counters as deltas, its gauges as
+// values and each histogram observation as a timing, with labels as
+// DogStatsD tags. It flushes on the scheduler, one last time at shutdown.
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+
+	mu      sync.Mutex
+	sent    map[string]float64
+	timings []statsdTiming
+	dropped int
+}
+
+type statsdTiming struct {
+	name  string
+	tags  string
+	value float64
+}
+
+func newStatsdExporter(addr, prefix string) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdExporter{conn: conn, prefix: prefix, sent: make(map[string]float64)}, nil
+}
+
+// observe buffers a histogram observation, in seconds, as a timing in
+// milliseconds.
+func (e *statsdExporter) observe(name string, labels, labelValues []string, seconds float64) {
+	if e == nil {
+		return
+	}
+
+	timing := statsdTiming{name: strings.TrimSuffix(name, "_seconds"), tags: statsdTags(labels, labelValues), value: seconds * 1000}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.timings) >= statsdMaxTimings {
+		e.dropped++
+		return
+	}
+	e.timings = append(e.timings, timing)
+}
+
+// flush sends everything recorded since the last flush. A send that fails
+// is logged and its lines are lost; the agent is expected to be local and
+// UDP gives no delivery guarantee anyway.
+func (e *statsdExporter) flush(context.Context) error {
+	if e == nil {
+		return nil
+	}
+
+	metrics.mu.Lock()
+	families := append([]metricFamily(nil), metrics.families...)
+	metrics.mu.Unlock()
+
+	e.mu.Lock()
+	var lines []string
+	for _, family := range families {
+		m, ok := family.(*metricVec)
+		if !ok {
+			continue
+		}
+
+		m.mu.Lock()
+		for key, s := range m.series {
+			tags := statsdTags(m.labels, s.labelValues)
+			switch m.kind {
+			case "counter":
+				sentKey := m.name + "\xfe" + key
+				delta := s.value - e.sent[sentKey]
+				e.sent[sentKey] = s.value
+				if delta != 0 {
+					lines = append(lines, e.line(strings.TrimSuffix(m.name, "_total"), formatMetricValue(delta), "c", tags))
+				}
+			case "gauge":
+				lines = append(lines, e.line(m.name, formatMetricValue(s.value), "g", tags))
+			}
+		}
+		m.mu.Unlock()
+	}
+	for _, t := range e.timings {
+		lines = append(lines, e.line(t.name, strconv.FormatFloat(t.value, 'f', 3, 64), "ms", t.tags))
+	}
+	e.timings = e.timings[:0]
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("StatsD: dropped %d timing(s) over the %d buffered between flushes", dropped, statsdMaxTimings)
+	}
+	return e.send(lines)
+}
+
+func (e *statsdExporter) line(name, value, kind, tags string) string {
+	return e.prefix + name + ":" + value + "|" + kind + tags
+}
+
+// send packs lines into as few datagrams as fit under statsdMaxPacket.
+func (e *statsdExporter) send(lines []string) error {
+	var packet bytes.Buffer
+	write := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return write()
+}
+
+// statsdTags renders labels as DogStatsD tags, "|#name:value,...". StatsD
+// has no escaping, so the characters that delimit a line are replaced.
+func statsdTags(labels, labelValues []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("|#")
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+		b.WriteString(label + ":" + statsdTagEscaper.Replace(value))
+	}
+	return b.String()
+}
+
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// startStatsd sets up the exporter and its flush task. It must be called
+// before the scheduler starts.
+func startStatsd(addr, prefix string, interval time.Duration) {
+	if addr == "" {
+		return
+	}
+
+	exporter, err := newStatsdExporter(addr, prefix)
+	if err != nil {
+		log.Printf("StatsD export disabled: %v", err)
+		return
+	}
+	statsd = exporter
+	scheduler.flushEvery("statsd-flush", interval, statsd.flush)
+	log.Printf("Pushing metrics to StatsD at %s every %v", addr, interval)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStatsd is a UDP listener standing in for a StatsD agent.
+func fakeStatsd(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// receive returns the lines of every datagram that arrives until none has
+// for a while.
+func receive(t *testing.T, pc net.PacketConn) (lines []string, packets int) {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	for {
+		pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return lines, packets
+		}
+		if n > statsdMaxPacket {
+			t.Errorf("datagram of %d bytes, want at most %d", n, statsdMaxPacket)
+		}
+		packets++
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+// useStatsd points the package's exporter at pc for the test.
+func useStatsd(t *testing.T, pc net.PacketConn) {
+	t.Helper()
+	e, err := newStatsdExporter(pc.LocalAddr().String(), "test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := statsd
+	statsd = e
+	t.Cleanup(func() {
+		statsd = saved
+		e.conn.Close()
+	})
+}
+
+func hasLinePrefix(lines []string, prefix string) bool {
+	return slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, prefix) })
+}
+
+func TestStatsdFlush(t *testing.T) {
+	captureLog(t)
+	pc := fakeStatsd(t)
+	useStatsd(t, pc)
+	handler := newTestServer(t, loadConfig())
+
+	// The first flush sends everything counted before the exporter existed.
+	statsd.flush(context.Background())
+	receive(t, pc)
+
+	for range 2 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	}
+	if err := statsd.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lines, _ := receive(t, pc)
+
+	if want := "test.http_requests:2|c|#method:GET,route:/health,code:200"; !slices.Contains(lines, want) {
+		t.Errorf("no %q in:\n%s", want, strings.Join(lines, "\n"))
+	}
+	timings := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l, "test.http_request_duration:") && strings.HasSuffix(l, "|ms|#method:GET,route:/health") {
+			timings++
+		}
+	}
+	if timings != 2 {
+		t.Errorf("%d request duration timings, want one per request", timings)
+	}
+	if !slices.Contains(lines, "test.http_requests_in_flight:0|g") {
+		t.Errorf("no gauges in:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// Counters go out as deltas: with nothing served since, they aren't
+	// sent again, while gauges are.
+	statsd.flush(context.Background())
+	lines, _ = receive(t, pc)
+	if hasLinePrefix(lines, "test.http_requests:") || hasLinePrefix(lines, "test.http_request_duration:") {
+		t.Errorf("unchanged counters or old timings resent:\n%s", strings.Join(lines, "\n"))
+	}
+	if !slices.Contains(lines, "test.http_requests_in_flight:0|g") {
+		t.Errorf("gauges not resent:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestStatsdSplitsPackets(t *testing.T) {
+	pc := fakeStatsd(t)
+	e, err := newStatsdExporter(pc.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.conn.Close()
+
+	var sent []string
+	for i := range 100 {
+		sent = append(sent, "metric_"+strings.Repeat("x", 40)+":"+string(rune('0'+i%10))+"|c")
+	}
+	if err := e.send(sent); err != nil {
+		t.Fatal(err)
+	}
+	lines, packets := receive(t, pc)
+	if !slices.Equal(lines, sent) {
+		t.Errorf("received %d lines, want the %d sent, in order", len(lines), len(sent))
+	}
+	if packets < 2 {
+		t.Errorf("%d datagram(s), want the lines split across several", packets)
+	}
+}
+
+func TestStatsdTags(t *testing.T) {
+	tests := []struct {
+		labels, values []string
+		want           string
+	}{
+		{nil, nil, ""},
+		{[]string{"method", "route"}, []string{"GET", "/"}, "|#method:GET,route:/"},
+		{[]string{"route"}, []string{"/a,b|c#d\ne"}, "|#route:/a_b_c_d_e"},
+		{[]string{"a", "b"}, []string{"1"}, "|#a:1,b:"},
+	}
+	for _, tt := range tests {
+		if got := statsdTags(tt.labels, tt.values); got != tt.want {
+			t.Errorf("statsdTags(%q, %q) = %q, want %q", tt.labels, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestStatsdFlushesAtShutdown(t *testing.T) {
+	logs := captureLog(t)
+	pc := fakeStatsd(t)
+	saved, savedScheduler := statsd, scheduler
+	scheduler = newTestScheduler()
+	t.Cleanup(func() {
+		statsd.conn.Close()
+		statsd, scheduler = saved, savedScheduler
+	})
+
+	startStatsd(pc.LocalAddr().String(), "test.", time.Hour)
+	if statsd == nil {
+		t.Fatalf("exporter not set up:\n%s", logs)
+	}
+	scheduler.start()
+	receive(t, pc)
+
+	statsd.observe("http_request_duration_seconds", []string{"method", "route"}, []string{"GET", "/"}, 0.25)
+	scheduler.shutdown(context.Background(), 5*time.Second)
+
+	lines, _ := receive(t, pc)
+	if !slices.Contains(lines, "test.http_request_duration:250.000|ms|#method:GET,route:/") {
+		t.Errorf("timing not flushed at shutdown, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestStatsdDisabled(t *testing.T) {
+	var e *statsdExporter
+	e.observe("x_seconds", nil, nil, 1)
+	if err := e.flush(context.Background()); err != nil {
+		t.Errorf("flush on a disabled exporter = %v", err)
+	}
+}
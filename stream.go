@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseHeartbeatInterval is how often /debug/events sends an event. It stays
+// well under the default write timeout, which a stream only outlives because
+// streamingMiddleware lifts it.
+const sseHeartbeatInterval = 10 * time.Second
+
+// stopStreams is closed when the server starts shutting down. Shutdown waits
+// for handlers to return, so long-lived streams watch it and end themselves
+// rather than holding the shutdown up until it times out.
+var stopStreams = make(chan struct{})
+
+// extendStreamDeadline pushes the connection's write deadline idle into the
+// future, or clears it when idle is zero. Streaming handlers call it after
+// each successful write so a stream is only cut off once the client stops
+// keeping up, not after the server-wide WriteTimeout.
+func extendStreamDeadline(w http.ResponseWriter, idle time.Duration) error {
+	var deadline time.Time
+	if idle > 0 {
+		deadline = time.Now().Add(idle)
+	}
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}
+
+// streamingMiddleware lifts the server-wide read timeout and replaces the
+// write timeout with a per-stream idle timeout, for routes whose responses
+// are meant to stay open. It also declares the trailers every stream ends
+// with.
+func streamingMiddleware(idle time.Duration) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(time.Time{}); err != nil {
+				errorHandler(w, r, http.StatusInternalServerError, "Streaming is not supported on this connection")
+				return
+			}
+			if err := extendStreamDeadline(w, idle); err != nil {
+				errorHandler(w, r, http.StatusInternalServerError, "Streaming is not supported on this connection")
+				return
+			}
+
+			// Headers are long gone by the time a stream ends, so the request
+			// ID and how the stream finished are sent as trailers instead.
+			w.Header().Add("Trailer", "X-Request-ID")
+			w.Header().Add("Trailer", "X-Stream-Status")
+
+			next(w, r)
+
+			if id := requestInfo(r.Context()).ID; id != 0 {
+				w.Header().Set("X-Request-ID", strconv.FormatUint(id, 10))
+			}
+			status := "complete"
+			if r.Context().Err() != nil {
+				status = "aborted"
+			}
+			w.Header().Set("X-Stream-Status", status)
+		}
+	}
+}
+
+// eventsHandler streams a heartbeat as server-sent events until the client
+// goes away or the server shuts down.
+func eventsHandler(idle time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		w.Header().Set("Content-Type", contentType("text/event-stream"))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(sseHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-stopStreams:
+				fmt.Fprint(w, "event: shutdown\ndata: {}\n\n")
+				rc.Flush()
+				return
+			case now := <-ticker.C:
+				_, err := fmt.Fprintf(w, "event: heartbeat\ndata: {\"timestamp\":%q}\n\n", formatTimestamp(now))
+				if err == nil {
+					err = rc.Flush()
+				}
+				if err != nil {
+					return
+				}
+				extendStreamDeadline(w, idle)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tickingHandler writes n chunks, one every interval, extending the stream
+// deadline after each as the streaming handlers do.
+func tickingHandler(n int, interval, idle time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range n {
+			if _, err := fmt.Fprintf(w, "data: %d\n\n", i); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			extendStreamDeadline(w, idle)
+			time.Sleep(interval)
+		}
+	}
+}
+
+// newTimeoutServer serves h with every server-wide timeout set to timeout.
+func newTimeoutServer(t *testing.T, h http.HandlerFunc, timeout time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ReadTimeout = timeout
+	srv.Config.WriteTimeout = timeout
+	srv.Config.IdleTimeout = timeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStreamOutlivesServerTimeouts(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+	h := requestInfoMiddleware(&Config{})(streamingMiddleware(time.Second)(tickingHandler(8, 50*time.Millisecond, time.Second)))
+	srv := newTimeoutServer(t, h, timeout)
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream cut off after %v: %v", time.Since(start), err)
+	}
+	if got := strings.Count(string(body), "data: "); got != 8 {
+		t.Errorf("received %d events, want 8", got)
+	}
+	if elapsed := time.Since(start); elapsed < 2*timeout {
+		t.Errorf("stream lasted %v, not long enough to outlive the %v timeouts", elapsed, timeout)
+	}
+}
+
+func TestUnstreamedRouteKeepsWriteTimeout(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+	plain := func(w http.ResponseWriter, r *http.Request) {
+		for i := range 8 {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			http.NewResponseController(w).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	srv := newTimeoutServer(t, plain, timeout)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && strings.Count(string(body), "data: ") == 8 {
+		t.Error("a route without streamingMiddleware outlived WriteTimeout")
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	const idle = 100 * time.Millisecond
+	stalled := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 0\n\n"))
+		http.NewResponseController(w).Flush()
+		time.Sleep(3 * idle)
+		// The write deadline set when the stream began has passed.
+		for i := range 64 {
+			if _, err := fmt.Fprintf(w, "data: %d %s\n\n", i, strings.Repeat("x", 4096)); err != nil {
+				return
+			}
+		}
+		http.NewResponseController(w).Flush()
+	}
+	srv := newTimeoutServer(t, streamingMiddleware(idle)(stalled), time.Minute)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && strings.Count(string(body), "data: ") > 1 {
+		t.Errorf("a stream idle past STREAM_IDLE_TIMEOUT kept writing: %d events", strings.Count(string(body), "data: "))
+	}
+}
+
+func TestStreamTrailers(t *testing.T) {
+	h := requestInfoMiddleware(&Config{})(streamingMiddleware(time.Second)(tickingHandler(3, 10*time.Millisecond, time.Second)))
+	srv := newTimeoutServer(t, h, time.Minute)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Request-ID") != "" {
+		t.Error("request ID sent as a header on a stream")
+	}
+	if resp.Trailer.Get("X-Request-ID") != "" {
+		t.Error("trailer values known before the body was read")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "data: 2") {
+		t.Errorf("body = %q", body)
+	}
+	if id := resp.Trailer.Get("X-Request-ID"); id == "" || id == "0" {
+		t.Errorf("X-Request-ID trailer = %q, want the request ID", id)
+	}
+	if got := resp.Trailer.Get("X-Stream-Status"); got != "complete" {
+		t.Errorf("X-Stream-Status trailer = %q, want complete", got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxTenantLen bounds the tenant IDs accepted from the tenant header, since
+// each one can end up as a rate limiter bucket.
+const maxTenantLen = 64
+
+// tenantMiddleware records the tenant named in header on the request's
+// RequestInfo. The header is only believed from a trusted proxy: a gateway
+// there should set it and strip it from client requests. Anyone else could
+// pick a fresh tenant on every request and get a fresh rate limit bucket
+// with it, so their requests are treated as having no tenant. Values that
+// don't look like an ID are ignored.
+func tenantMiddleware(header string, proxies trustedProxies) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if header == "" {
+			return next
+		}
+		if len(proxies) == 0 {
+			log.Printf("TENANT_HEADER is set but TRUSTED_PROXIES is not, so %s is ignored", header)
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !proxies.trusts(clientIP(r)) {
+				next(w, r)
+				return
+			}
+			if tenant := r.Header.Get(header); validTenant(tenant) {
+				requestInfo(r.Context()).Tenant = tenant
+			}
+			next(w, r)
+		}
+	}
+}
+
+func validTenant(tenant string) bool {
+	if tenant == "" || len(tenant) > maxTenantLen {
+		return false
+	}
+	for _, c := range tenant {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseTenantRateLimits reads "tenant:rps" or "tenant:rps:burst" entries.
+// Invalid entries are logged and skipped.
+func parseTenantRateLimits(spec []string) map[string]rateLimit {
+	limits := make(map[string]rateLimit, len(spec))
+	for _, entry := range spec {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || !validTenant(parts[0]) {
+			log.Printf("Invalid RATE_LIMIT_TENANTS entry %q, ignoring it", entry)
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate <= 0 {
+			log.Printf("Invalid RATE_LIMIT_TENANTS entry %q, ignoring it", entry)
+			continue
+		}
+		burst := 0
+		if len(parts) == 3 {
+			if burst, err = strconv.Atoi(parts[2]); err != nil || burst < 1 {
+				log.Printf("Invalid RATE_LIMIT_TENANTS entry %q, ignoring it", entry)
+				continue
+			}
+		}
+		limits[parts[0]] = newRateLimit(rate, burst)
+	}
+	return limits
+}
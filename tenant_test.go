@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	logs := captureLog(t)
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name       string
+		header     string
+		proxies    trustedProxies
+		remoteAddr string
+		value      string
+		want       string
+	}{
+		{"trusted proxy", "X-Tenant-ID", proxies, "10.1.2.3:1234", "acme", "acme"},
+		{"untrusted peer", "X-Tenant-ID", proxies, "203.0.113.9:1234", "acme", ""},
+		{"invalid value", "X-Tenant-ID", proxies, "10.1.2.3:1234", "acme corp", ""},
+		{"too long", "X-Tenant-ID", proxies, "10.1.2.3:1234", strings.Repeat("a", maxTenantLen+1), ""},
+		{"header unset", "", proxies, "10.1.2.3:1234", "acme", ""},
+		{"no trusted proxies", "X-Tenant-ID", nil, "10.1.2.3:1234", "acme", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := tenantMiddleware(tt.header, tt.proxies)(func(w http.ResponseWriter, r *http.Request) {
+				got = requestInfo(r.Context()).Tenant
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Tenant-ID", tt.value)
+			h(httptest.NewRecorder(), withRequestInfo(req, &RequestInfo{}))
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if !strings.Contains(logs.String(), "TENANT_HEADER is set but TRUSTED_PROXIES is not") {
+		t.Errorf("no warning for a tenant header without trusted proxies in:\n%s", logs)
+	}
+}
+
+func TestParseTenantRateLimits(t *testing.T) {
+	captureLog(t)
+	got := parseTenantRateLimits([]string{"acme:50", "globex:2.5:10", "bad", "bad rps:x", "zero:0", "neg:1:-1", "a b:1"})
+	want := map[string]rateLimit{
+		"acme":   {rate: 50, burst: 50},
+		"globex": {rate: 2.5, burst: 10},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("limits = %v, want %v", got, want)
+	}
+	for tenant, limit := range want {
+		if got[tenant] != limit {
+			t.Errorf("%s: limit = %v, want %v", tenant, got[tenant], limit)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handlerTimeoutMiddleware gives the handler a context deadline of timeout,
+// or the one adaptive works out from the route's recent latency, and
+// advertises it in X-Timeout, in seconds, so clients can size their own
+// timeouts to match. Handlers are expected to give up once their context is
+// done; one that returns past the deadline without having written anything
+// is answered with a 503.
+func handlerTimeoutMiddleware(static time.Duration, adaptive *adaptiveTimeout) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if static <= 0 && adaptive == nil {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			timeout := static
+			if adaptive != nil {
+				timeout = adaptive.deadline(r.Pattern)
+			}
+			w.Header().Set("X-Timeout", strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			sw := &statusWriter{ResponseWriter: w}
+			next(sw, r.WithContext(ctx))
+
+			if sw.status == 0 && !sw.hijacked && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Printf("[%d] Handler timed out after %v", requestInfo(r.Context()).ID, timeout)
+				errorHandler(w, r, http.StatusServiceUnavailable, "Request timed out")
+			}
+		}
+	}
+}
+
+// parseRouteTimeouts reads "pattern=duration" entries. Invalid entries are
+// logged and skipped.
+func parseRouteTimeouts(spec []string) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration, len(spec))
+	for _, entry := range spec {
+		pattern, value, ok := strings.Cut(entry, "=")
+		if !ok || pattern == "" {
+			log.Printf("Invalid ROUTE_TIMEOUTS entry %q, ignoring it", entry)
+			continue
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			log.Printf("Invalid ROUTE_TIMEOUTS entry %q, ignoring it", entry)
+			continue
+		}
+		timeouts[pattern] = timeout
+	}
+	return timeouts
+}
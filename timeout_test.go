@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHeader(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.HandlerTimeout = 5 * time.Second
+	config.RouteTimeouts = []string{"/health=1500ms", "/livez=0", "bogus", "/readyz=-1s"}
+	config.DebugEndpoints = true
+	config.APIToken = "api-secret"
+	handler := newTestServer(t, config)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "5"},
+		{"/readyz", "5"},
+		{"/health", "1.5"},
+		{"/livez", ""},
+		{"/debug/events", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// The recorder can't take stream deadlines, so /debug/events
+			// answers at once; only the header matters here.
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer api-secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if got := rec.Header().Get("X-Timeout"); got != tt.want {
+				t.Errorf("X-Timeout = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerTimeoutDeadline(t *testing.T) {
+	captureLog(t)
+	var deadline time.Time
+	h := handlerTimeoutMiddleware(50*time.Millisecond, nil)(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		<-r.Context().Done()
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("X-Timeout") != "0.05" {
+		t.Errorf("status = %d, X-Timeout = %q; want 503 and 0.05", rec.Code, rec.Header().Get("X-Timeout"))
+	}
+	if d := deadline.Sub(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("handler deadline %v after the start, want 50ms", d)
+	}
+}
+
+func TestHandlerTimeoutKeepsWrittenResponse(t *testing.T) {
+	h := handlerTimeoutMiddleware(10*time.Millisecond, nil)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		<-r.Context().Done()
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want the handler's own 202", rec.Code)
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	captureLog(t)
+	got := parseRouteTimeouts([]string{"/health=1s", "/slow=0", "=1s", "/bad=soon", "/neg=-1s", "noequals"})
+	if len(got) != 2 || got["/health"] != time.Second || got["/slow"] != 0 {
+		t.Errorf("parseRouteTimeouts = %v, want /health=1s and /slow=0", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const rfc3339Milli = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampLayout is shared by response bodies and log lines so the two can
+// be lined up at the same precision.
+var timestampLayout = rfc3339Milli
+
+func timestampLayoutFor(precision string) string {
+	switch strings.ToLower(precision) {
+	case "second", "seconds", "s":
+		return time.RFC3339
+	case "nano", "nanos", "ns":
+		return time.RFC3339Nano
+	case "", "milli", "millis", "ms":
+		return rfc3339Milli
+	}
+
+	log.Printf("Invalid value for TIMESTAMP_PRECISION: %q, using milliseconds", precision)
+	return rfc3339Milli
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.Format(timestampLayout)
+}
+
+// timestampWriter prefixes each log line with a timestamp in
+// timestampLayout, replacing the log package's own date and time flags.
+type timestampWriter struct {
+	out io.Writer
+}
+
+func (tw timestampWriter) Write(p []byte) (int, error) {
+	line := make([]byte, 0, len(timestampLayout)+1+len(p))
+	line = time.Now().AppendFormat(line, timestampLayout)
+	line = append(line, ' ')
+	line = append(line, p...)
+
+	if _, err := tw.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// setTimestampPrecision switches the shared layout for the rest of the test.
+func setTimestampPrecision(t *testing.T, precision string) {
+	t.Helper()
+	old := timestampLayout
+	timestampLayout = timestampLayoutFor(precision)
+	t.Cleanup(func() { timestampLayout = old })
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		pattern   string
+	}{
+		{"second", `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ$`},
+		{"", `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`},
+		{"milli", `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`},
+		{"bogus", `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`},
+		// RFC3339Nano drops trailing zeros, so up to nine digits.
+		{"nano", `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d{1,9})?Z$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.precision, func(t *testing.T) {
+			setTimestampPrecision(t, tt.precision)
+			want := regexp.MustCompile(tt.pattern)
+
+			rec := httptest.NewRecorder()
+			mainHandler(rec, withRequestInfo(httptest.NewRequest(http.MethodGet, "/", nil), &RequestInfo{config: loadConfig()}))
+			var body struct {
+				Timestamp string `json:"timestamp"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !want.MatchString(body.Timestamp) {
+				t.Errorf("response timestamp %q doesn't match %s", body.Timestamp, tt.pattern)
+			}
+
+			var buf bytes.Buffer
+			timestampWriter{out: &buf}.Write([]byte("line\n"))
+			prefix, _, _ := bytes.Cut(buf.Bytes(), []byte(" "))
+			if !want.Match(prefix) {
+				t.Errorf("log timestamp %q doesn't match %s", prefix, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestFormatTimestampNano(t *testing.T) {
+	setTimestampPrecision(t, "nano")
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	if got, want := formatTimestamp(ts), "2024-05-01T12:00:00.123456789Z"; got != want {
+		t.Errorf("formatTimestamp = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// tlsConnLogger returns a ConnState hook that logs the negotiated TLS
+// parameters once per connection, after the handshake has completed.
+func tlsConnLogger() func(net.Conn, http.ConnState) {
+	var logged sync.Map
+
+	return func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateActive:
+			tc, ok := c.(*tls.Conn)
+			if !ok {
+				return
+			}
+			if _, seen := logged.LoadOrStore(c, struct{}{}); seen {
+				return
+			}
+			logTLSConnectionState(c.RemoteAddr().String(), tc.ConnectionState())
+
+		case http.StateHijacked, http.StateClosed:
+			logged.Delete(c)
+		}
+	}
+}
+
+func logTLSConnectionState(remoteAddr string, cs tls.ConnectionState) {
+	clientCert := "none"
+	if len(cs.PeerCertificates) > 0 {
+		clientCert = cs.PeerCertificates[0].Subject.String()
+	}
+
+	log.Printf("TLS connection established - RemoteAddr: %s | Version: %s | CipherSuite: %s | ServerName: %s | Protocol: %s | ClientCert: %s",
+		remoteAddr,
+		tls.VersionName(cs.Version),
+		tls.CipherSuiteName(cs.CipherSuite),
+		cs.ServerName,
+		cs.NegotiatedProtocol,
+		clientCert,
+	)
+}
+
+// certificates holds the serving certificate. The TLS config asks it for
+// the certificate on every handshake, so a reload takes effect for new
+// connections without a restart.
+var certificates certReloader
+
+type certReloader struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// load reads the key pair from disk. On failure the certificate in use, if
+// any, is kept.
+func (cr *certReloader) load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert.Store(&cert)
+	return nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for commonName and its key
+// to dir, returning the two paths.
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConnLoggerLogsHandshake(t *testing.T) {
+	logs := captureLog(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Config.ConnState = tlsConnLogger()
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := ts.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.ServerName = "example.com"
+	for range 2 {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	cs := func() tls.ConnectionState {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return *resp.TLS
+	}()
+
+	out := logs.String()
+	for _, want := range []string{
+		"Version: " + tls.VersionName(cs.Version),
+		"CipherSuite: " + tls.CipherSuiteName(cs.CipherSuite),
+		"ServerName: example.com",
+		"ClientCert: none",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %q:\n%s", want, out)
+		}
+	}
+	// The three requests shared one connection, which is logged once.
+	if n := strings.Count(out, "TLS connection established"); n != 1 {
+		t.Errorf("handshake logged %d times, want once per connection", n)
+	}
+}
+
+func TestTLSConnLoggerClientCert(t *testing.T) {
+	logs := captureLog(t)
+
+	clientCertFile, clientKeyFile := writeTestCert(t, t.TempDir(), "client.example")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Config.ConnState = tlsConnLogger()
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := ts.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if out := logs.String(); !strings.Contains(out, "ClientCert: CN=client.example") {
+		t.Errorf("log is missing the client certificate subject:\n%s", out)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	var cr certReloader
+	dir := t.TempDir()
+
+	certFile, keyFile := writeTestCert(t, dir, "first.example")
+	if err := cr.load(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := cr.getCertificate(nil)
+
+	if err := cr.load(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Fatal("loading a missing certificate succeeded")
+	}
+	if kept, _ := cr.getCertificate(nil); kept != first {
+		t.Error("a failed load replaced the certificate in use")
+	}
+
+	certFile, keyFile = writeTestCert(t, dir, "second.example")
+	if err := cr.load(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := cr.getCertificate(nil)
+	leaf, err := x509.ParseCertificate(second.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "second.example" {
+		t.Errorf("serving %q after reload, want second.example", leaf.Subject.CommonName)
+	}
+}
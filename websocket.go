@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsCloseNormal     = 1000
+	wsCloseGoingAway  = 1001
+	wsCloseProtocol   = 1002
+	wsCloseNoStatus   = 1005
+	wsCloseTooBig     = 1009
+	wsOpContinuation  = 0x0
+	wsOpText          = 0x1
+	wsOpBinary        = 0x2
+	wsOpClose         = 0x8
+	wsOpPing          = 0x9
+	wsOpPong          = 0xA
+	wsMaxMessageBytes = 1 << 20
+)
+
+// wsAcceptGUID is appended to the client's key to derive
+// Sec-WebSocket-Accept (RFC 6455, section 4.2.2).
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	errWSClosing  = errors.New("websocket: close frame already sent")
+	errWSProtocol = errors.New("websocket: protocol error")
+	errWSTooBig   = errors.New("websocket: message too large")
+)
+
+var webSockets = &wsRegistry{conns: make(map[*wsConn]struct{})}
+
+// wsConn is a hijacked connection carrying a WebSocket. Frames written by the
+// handler and the close frame sent during shutdown share writeMu so they can't
+// interleave on the wire.
+type wsConn struct {
+	net.Conn
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// writeFrame sends one unfragmented frame. Nothing more may be sent once
+// the close frame is out.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return errWSClosing
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+	}
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := c.Conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(payload)
+	return err
+}
+
+func (c *wsConn) writeClose(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	return c.writeFrame(wsOpClose, payload)
+}
+
+// upgradeWebSocket completes the opening handshake and takes the connection
+// over from the server. The connection is tracked so shutdown can drain it,
+// and the caller must call release once it is done with it. When the
+// request can't be upgraded it has already been answered and err is
+// non-nil.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (wc *wsConn, br *bufio.Reader, release func(), err error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 ||
+		!headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		errorHandler(w, r, http.StatusBadRequest, "Not a valid WebSocket upgrade request")
+		return nil, nil, nil, errWSProtocol
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		errorHandler(w, r, http.StatusUpgradeRequired, "Unsupported WebSocket version")
+		return nil, nil, nil, errWSProtocol
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		errorHandler(w, r, http.StatusInternalServerError, "WebSocket is not supported on this connection")
+		return nil, nil, nil, err
+	}
+	// The server's read and write deadlines stay on a hijacked connection;
+	// a WebSocket lives as long as its peer keeps it open.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	wc, release = webSockets.track(conn)
+	return wc, brw.Reader, release, nil
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readWSFrame reads one frame sent by a client, which must be masked, and
+// unmasks its payload.
+func readWSFrame(br *bufio.Reader) (wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return wsFrame{}, err
+	}
+	f := wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0F}
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		return f, errWSProtocol
+	}
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if f.opcode >= wsOpClose && (n > 125 || !f.fin) {
+		return f, errWSProtocol
+	}
+	if n > wsMaxMessageBytes {
+		return f, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(br, mask[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(br, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// webSocketEchoHandler sends every message it receives straight back. It
+// answers pings, and returns once either side has closed the connection:
+// on a close from the client it replies with the same code, and during
+// shutdown the client's reply to the registry's going-away frame ends it.
+func webSocketEchoHandler(w http.ResponseWriter, r *http.Request) {
+	wc, br, release, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer release()
+	defer wc.Close()
+
+	var message []byte
+	var messageType byte
+	for {
+		f, err := readWSFrame(br)
+		switch {
+		case errors.Is(err, errWSProtocol):
+			wc.writeClose(wsCloseProtocol, "protocol error")
+			return
+		case errors.Is(err, errWSTooBig):
+			wc.writeClose(wsCloseTooBig, "message too large")
+			return
+		case err != nil:
+			return
+		}
+
+		switch f.opcode {
+		case wsOpClose:
+			code := uint16(wsCloseNormal)
+			if len(f.payload) >= 2 {
+				code = binary.BigEndian.Uint16(f.payload)
+			}
+			if code == wsCloseNoStatus {
+				code = wsCloseNormal
+			}
+			wc.writeClose(code, "")
+			return
+		case wsOpPing:
+			wc.writeFrame(wsOpPong, f.payload)
+		case wsOpPong:
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if (f.opcode == wsOpContinuation) != (messageType != 0) {
+				wc.writeClose(wsCloseProtocol, "unexpected continuation")
+				return
+			}
+			if f.opcode != wsOpContinuation {
+				messageType = f.opcode
+			}
+			if len(message)+len(f.payload) > wsMaxMessageBytes {
+				wc.writeClose(wsCloseTooBig, "message too large")
+				return
+			}
+			message = append(message, f.payload...)
+			if !f.fin {
+				continue
+			}
+			if err := wc.writeFrame(messageType, message); err != nil && !errors.Is(err, errWSClosing) {
+				return
+			}
+			message, messageType = message[:0], 0
+		default:
+			wc.writeClose(wsCloseProtocol, "unknown opcode")
+			return
+		}
+	}
+}
+
+// wsRegistry tracks WebSocket connections, which http.Server stops tracking
+// once they are hijacked and so never drains on Shutdown.
+type wsRegistry struct {
+	mu    sync.Mutex
+	conns map[*wsConn]struct{}
+}
+
+// track registers a hijacked connection. The handler must call the returned
+// release func once the connection is closed.
+func (reg *wsRegistry) track(conn net.Conn) (*wsConn, func()) {
+	wc := &wsConn{Conn: conn}
+
+	reg.mu.Lock()
+	reg.conns[wc] = struct{}{}
+	reg.mu.Unlock()
+
+	release := func() {
+		reg.mu.Lock()
+		delete(reg.conns, wc)
+		reg.mu.Unlock()
+	}
+	return wc, release
+}
+
+func (reg *wsRegistry) count() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.conns)
+}
+
+func (reg *wsRegistry) snapshot() []*wsConn {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	conns := make([]*wsConn, 0, len(reg.conns))
+	for wc := range reg.conns {
+		conns = append(conns, wc)
+	}
+	return conns
+}
+
+// drain sends a going-away close frame to every tracked connection and waits
+// for the handlers to release them. Whatever is still open when ctx expires
+// is closed outright.
+func (reg *wsRegistry) drain(ctx context.Context) {
+	conns := reg.snapshot()
+	if len(conns) == 0 {
+		return
+	}
+
+	log.Printf("Closing %d WebSocket connection(s)...", len(conns))
+	for _, wc := range conns {
+		wc.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := wc.writeClose(wsCloseGoingAway, "server shutting down"); err != nil {
+			log.Printf("Could not send close frame to %s: %v", wc.RemoteAddr(), err)
+		}
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for reg.count() > 0 {
+		select {
+		case <-ctx.Done():
+			remaining := reg.snapshot()
+			log.Printf("Force closing %d WebSocket connection(s)", len(remaining))
+			for _, wc := range remaining {
+				wc.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+
+	log.Println("All WebSocket connections closed")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWebSocket opens a WebSocket to srv's path and returns the
+// connection and a reader positioned after the handshake response.
+func dialWebSocket(t *testing.T, srv *httptest.Server, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	// The sample handshake from RFC 6455, section 1.3.
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("Sec-WebSocket-Accept = %q, want %q", got, want)
+	}
+	return conn, br
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	payload := make([]byte, head[1]&0x7F)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(webSocketEchoHandler))
+	defer srv.Close()
+
+	conn, br := dialWebSocket(t, srv, "/ws/echo")
+
+	writeClientFrame(t, conn, wsOpText, []byte("hello"))
+	if op, payload := readServerFrame(t, br); op != wsOpText || string(payload) != "hello" {
+		t.Errorf("echo = (%#x, %q), want (%#x, %q)", op, payload, wsOpText, "hello")
+	}
+
+	writeClientFrame(t, conn, wsOpPing, []byte("p"))
+	if op, payload := readServerFrame(t, br); op != wsOpPong || string(payload) != "p" {
+		t.Errorf("ping answered with (%#x, %q), want a pong", op, payload)
+	}
+
+	writeClientFrame(t, conn, wsOpClose, []byte{0x03, 0xE8})
+	op, payload := readServerFrame(t, br)
+	if op != wsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("close answered with (%#x, %v), want a 1000 close", op, payload)
+	}
+}
+
+func TestWebSocketRejectsPlainRequest(t *testing.T) {
+	rec := httptest.NewRecorder()
+	webSocketEchoHandler(rec, httptest.NewRequest(http.MethodGet, "/ws/echo", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestWebSocketDrainSendsGoingAway(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(webSocketEchoHandler))
+	defer srv.Close()
+
+	conn, br := dialWebSocket(t, srv, "/ws/echo")
+	waitFor(t, func() bool { return webSockets.count() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		webSockets.drain(ctx)
+		close(drained)
+	}()
+
+	op, payload := readServerFrame(t, br)
+	if op != wsOpClose || len(payload) < 2 {
+		t.Fatalf("got frame (%#x, %v), want a close frame", op, payload)
+	}
+	if code := binary.BigEndian.Uint16(payload); code != wsCloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, wsCloseGoingAway)
+	}
+	if reason := string(payload[2:]); !strings.Contains(reason, "shutting down") {
+		t.Errorf("close reason = %q", reason)
+	}
+
+	// Answering the close lets the handler release the connection, so the
+	// drain finishes well before its deadline.
+	writeClientFrame(t, conn, wsOpClose, payload[:2])
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("drain did not finish after the client closed")
+	}
+	if n := webSockets.count(); n != 0 {
+		t.Errorf("%d connections still tracked after drain", n)
+	}
+}
+
+func TestWebSocketDrainForceClosesAtDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(webSocketEchoHandler))
+	defer srv.Close()
+
+	_, br := dialWebSocket(t, srv, "/ws/echo")
+	waitFor(t, func() bool { return webSockets.count() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	webSockets.drain(ctx)
+
+	// The client never answered the close frame, so the connection was cut.
+	readServerFrame(t, br)
+	if _, err := br.ReadByte(); err == nil {
+		t.Error("connection still open after the drain deadline")
+	}
+	waitFor(t, func() bool { return webSockets.count() == 0 })
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// textFile returns the content configured inline or, failing that, read
+// from path. It returns nil when neither is set or the file can't be read.
+func textFile(name, inline, path string) []byte {
+	if inline != "" {
+		return []byte(inline)
+	}
+	if path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Could not read %s from %s, not serving it: %v", name, path, err)
+		return nil
+	}
+	return content
+}
+
+// textFileHandler serves fixed plain-text content such as robots.txt, or a
+// 404 when there is none configured.
+func textFileHandler(content []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if content == nil {
+			notFoundHandler(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType("text/plain"))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(content)
+		}
+	}
+}
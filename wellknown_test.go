@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWellKnownTextFiles(t *testing.T) {
+	captureLog(t)
+	securityFile := filepath.Join(t.TempDir(), "security.txt")
+	security := "Contact: mailto:security@example.com\nExpires: 2027-01-01T00:00:00Z\n"
+	if err := os.WriteFile(securityFile, []byte(security), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := loadConfig()
+	config.RobotsTxt = "User-agent: *\nDisallow: /admin/\n"
+	config.RobotsTxtFile = filepath.Join(t.TempDir(), "ignored.txt")
+	config.SecurityTxtFile = securityFile
+	handler := newTestServer(t, config)
+
+	for path, want := range map[string]string{"/robots.txt": config.RobotsTxt, "/.well-known/security.txt": security} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, rec.Code, rec.Body, want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("GET %s Content-Type = %q", path, ct)
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != strconv.Itoa(len(want)) {
+			t.Errorf("HEAD %s = %d with %d body bytes and Content-Length %q", path, rec.Code, rec.Body.Len(), rec.Header().Get("Content-Length"))
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("POST %s = %d, want 405", path, rec.Code)
+		}
+	}
+}
+
+func TestWellKnownTextFilesUnset(t *testing.T) {
+	logs := captureLog(t)
+	config := loadConfig()
+	config.SecurityTxtFile = filepath.Join(t.TempDir(), "missing.txt")
+	handler := newTestServer(t, config)
+
+	for _, path := range []string{"/robots.txt", "/.well-known/security.txt"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404 when unconfigured", path, rec.Code)
+		}
+	}
+	if !strings.Contains(logs.String(), "Could not read security.txt from") {
+		t.Errorf("unreadable file not logged:\n%s", logs)
+	}
+}
+
+func TestWellKnownTextFilesSkipServerChain(t *testing.T) {
+	captureLog(t)
+	config := loadConfig()
+	config.RobotsTxt = "User-agent: *\n"
+	config.RateLimitRPS = 0.001
+	config.RateLimitBurst = 1
+	handler := newTestServer(t, config)
+
+	for i := range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want robots.txt exempt from rate limiting", i, rec.Code)
+		}
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("application route = %d, want it still rate limited", rec.Code)
+	}
+}